@@ -0,0 +1,176 @@
+// Package adherence tracks how well the buses adhere to the timetable. The Tracker consumes the arrival and departure
+// events of the simulation and computes a punctuality report per line and stop.
+package adherence
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultThreshold is the delay up to which a departure is regarded as on time.
+const DefaultThreshold = 3 * time.Minute
+
+// Visit describes the halt of a bus at a stop. Arrival and Departure are compared against the Scheduled departure time
+// because the timetable does not specify arrival times.
+type Visit struct {
+	BusId     model.BusId  `json:"busId"`
+	LineId    model.LineId `json:"lineId,omitempty"`
+	StopId    model.StopId `json:"stopId"`
+	Scheduled model.Time   `json:"scheduled"`
+	Arrival   model.Time   `json:"arrival"`
+	Departure model.Time   `json:"departure"`
+}
+
+// ArrivalDeviation returns the difference between the arrival and the scheduled departure, negative if the bus was early.
+func (v Visit) ArrivalDeviation() time.Duration {
+	return v.Arrival.Sub(v.Scheduled)
+}
+
+// Delay returns the difference between the actual and the scheduled departure.
+func (v Visit) Delay() time.Duration {
+	return v.Departure.Sub(v.Scheduled)
+}
+
+// Statistics summarizes the departure delays of several visits. Delays are given in seconds.
+type Statistics struct {
+	Departures int     `json:"departures"`
+	OnTime     float64 `json:"onTime"`
+	MeanDelay  float64 `json:"meanDelay"`
+	P95Delay   float64 `json:"p95Delay"`
+}
+
+// StopReport contains the statistics of a line at one stop.
+type StopReport struct {
+	StopId model.StopId `json:"stopId"`
+	Statistics
+}
+
+// LineReport contains the statistics of a line, overall and per stop. The stops are ordered by their first visit.
+type LineReport struct {
+	LineId model.LineId `json:"lineId"`
+	Statistics
+	Stops []StopReport `json:"stops"`
+}
+
+// Report is the punctuality report of a simulation run. The lines are sorted by their ids. Visits of buses
+// that do not serve a line are not part of the report.
+type Report struct {
+	// Type is always "punctuality". It distinguishes the report from other messages of the websocket stream.
+	Type string `json:"type"`
+	// Threshold is the delay in seconds up to which a departure is regarded as on time.
+	Threshold float64      `json:"threshold"`
+	Lines     []LineReport `json:"lines"`
+}
+
+// Tracker records the visits of the buses at the stops. It is safe to use a Tracker concurrently.
+// New trackers should be created with NewTracker.
+type Tracker struct {
+	mutex  sync.RWMutex
+	open   map[model.BusId]Visit
+	visits []Visit
+	// Threshold is the delay up to which a departure is regarded as on time.
+	Threshold time.Duration
+}
+
+// NewTracker creates a new tracker with the DefaultThreshold.
+func NewTracker() *Tracker {
+	return &Tracker{open: make(map[model.BusId]Visit), Threshold: DefaultThreshold}
+}
+
+// Event records the event. It can be used as model.EventPublisher. Events other than arrivals and departures
+// are ignored.
+func (t *Tracker) Event(event model.Event) {
+	if event.StopId == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch event.Type {
+	case model.Arrival:
+		t.open[event.BusId] = Visit{BusId: event.BusId, LineId: event.LineId, StopId: *event.StopId, Scheduled: event.Scheduled, Arrival: event.Time}
+	case model.Departure:
+		visit, ok := t.open[event.BusId]
+		if !ok || visit.StopId != *event.StopId {
+			visit = Visit{BusId: event.BusId, LineId: event.LineId, StopId: *event.StopId, Scheduled: event.Scheduled, Arrival: event.Time}
+		}
+		delete(t.open, event.BusId)
+		visit.Departure = event.Time
+		t.visits = append(t.visits, visit)
+	}
+}
+
+// Visits returns the completed visits of the bus in chronological order.
+func (t *Tracker) Visits(id model.BusId) []Visit {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	result := make([]Visit, 0)
+	for _, visit := range t.visits {
+		if visit.BusId == id {
+			result = append(result, visit)
+		}
+	}
+	return result
+}
+
+// Report computes the punctuality report of all completed visits.
+func (t *Tracker) Report() Report {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	type stopKey struct {
+		line model.LineId
+		stop model.StopId
+	}
+	lineDelays := make(map[model.LineId][]time.Duration)
+	stopDelays := make(map[stopKey][]time.Duration)
+	stops := make(map[model.LineId][]model.StopId)
+	for _, visit := range t.visits {
+		if visit.LineId == "" {
+			continue
+		}
+		key := stopKey{line: visit.LineId, stop: visit.StopId}
+		if _, ok := stopDelays[key]; !ok {
+			stops[visit.LineId] = append(stops[visit.LineId], visit.StopId)
+		}
+		lineDelays[visit.LineId] = append(lineDelays[visit.LineId], visit.Delay())
+		stopDelays[key] = append(stopDelays[key], visit.Delay())
+	}
+	result := Report{Type: "punctuality", Threshold: t.Threshold.Seconds(), Lines: make([]LineReport, 0, len(lineDelays))}
+	for line, delays := range lineDelays {
+		lineReport := LineReport{LineId: line, Statistics: t.statistics(delays), Stops: make([]StopReport, 0, len(stops[line]))}
+		for _, stop := range stops[line] {
+			lineReport.Stops = append(lineReport.Stops, StopReport{StopId: stop, Statistics: t.statistics(stopDelays[stopKey{line: line, stop: stop}])})
+		}
+		result.Lines = append(result.Lines, lineReport)
+	}
+	sort.Slice(result.Lines, func(i, j int) bool {
+		return result.Lines[i].LineId < result.Lines[j].LineId
+	})
+	return result
+}
+
+// statistics computes the statistics of the delays. The 95th percentile is determined with the nearest-rank method.
+func (t *Tracker) statistics(delays []time.Duration) Statistics {
+	sorted := make([]time.Duration, len(delays))
+	copy(sorted, delays)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	onTime := 0
+	sum := 0.0
+	for _, delay := range sorted {
+		if delay <= t.Threshold {
+			onTime = onTime + 1
+		}
+		sum = sum + delay.Seconds()
+	}
+	rank := int(math.Ceil(0.95 * float64(len(sorted))))
+	return Statistics{
+		Departures: len(sorted),
+		OnTime:     100 * float64(onTime) / float64(len(sorted)),
+		MeanDelay:  sum / float64(len(sorted)),
+		P95Delay:   sorted[rank-1].Seconds(),
+	}
+}
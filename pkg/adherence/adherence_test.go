@@ -0,0 +1,46 @@
+package adherence
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	stop1 := model.StopId("S1")
+	stop2 := model.StopId("S2")
+	visit := func(bus model.BusId, line model.LineId, stop *model.StopId, scheduled string, arrival time.Duration, delay time.Duration) {
+		departure := model.MustParseTime(scheduled)
+		tracker.Event(model.Event{Type: model.Arrival, BusId: bus, LineId: line, StopId: stop, Time: departure.Add(arrival), Scheduled: departure})
+		tracker.Event(model.Event{Type: model.Departure, BusId: bus, LineId: line, StopId: stop, Time: departure.Add(delay), Scheduled: departure})
+	}
+	visit("V1", "L2", &stop2, "8:00", -time.Minute, 0)
+	visit("V1", "L2", &stop1, "8:05", 4*time.Minute, 4*time.Minute)
+	for minute := 0; minute < 19; minute++ {
+		visit("V2", "L1", &stop1, "9:00", 0, 0)
+	}
+	visit("V2", "L1", &stop1, "9:00", 10*time.Minute, 10*time.Minute)
+	visit("V3", "", &stop1, "9:00", 0, time.Hour)
+
+	visits := tracker.Visits("V1")
+	require.Equal(t, 2, len(visits), "number of visits of V1")
+	assert.Equal(t, -time.Minute, visits[0].ArrivalDeviation(), "early arrival")
+	assert.Equal(t, time.Duration(0), visits[0].Delay(), "departure on time")
+	assert.Equal(t, stop1, visits[1].StopId, "second visit")
+
+	report := tracker.Report()
+	assert.Equal(t, "punctuality", report.Type, "type of the report")
+	assert.Equal(t, 180.0, report.Threshold, "threshold in seconds")
+	require.Equal(t, 2, len(report.Lines), "visits without line should be ignored")
+	assert.Equal(t, model.LineId("L1"), report.Lines[0].LineId, "lines should be sorted")
+	assert.Equal(t, Statistics{Departures: 20, OnTime: 95, MeanDelay: 30, P95Delay: 0}, report.Lines[0].Statistics, "statistics of L1")
+
+	l2 := report.Lines[1]
+	assert.Equal(t, Statistics{Departures: 2, OnTime: 50, MeanDelay: 120, P95Delay: 240}, l2.Statistics, "statistics of L2")
+	require.Equal(t, 2, len(l2.Stops), "stops of L2")
+	assert.Equal(t, stop2, l2.Stops[0].StopId, "stops should be ordered by their first visit")
+	assert.Equal(t, 240.0, l2.Stops[1].MeanDelay, "mean delay at the second stop")
+}
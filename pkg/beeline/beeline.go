@@ -0,0 +1,88 @@
+// Package beeline provides a route service that works without any routing server. The routes are
+// great-circle arcs between the waypoints, i.e. buses drive as the crow flies.
+package beeline
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"math"
+)
+
+const earthRadius = 6371000.0 // meters
+
+// NewRouteService creates a route service connecting the waypoints by great-circle arcs. In order to obtain smooth
+// movements, the arcs are divided into pieces that are not longer than the given resolution (in meters).
+// The returned route service never fails.
+func NewRouteService(resolution float64) model.RouteService {
+	return func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return route(resolution, coordinates)
+	}
+}
+
+func route(resolution float64, coordinates []model.Coordinate) ([]model.Coordinate, float64, error) {
+	result := make([]model.Coordinate, 0, len(coordinates))
+	distance := 0.0
+	for index, current := range coordinates {
+		if index == 0 {
+			result = append(result, coordinate{lat: current.Lat(), lon: current.Lon()})
+			continue
+		}
+		previous := coordinates[index-1]
+		angle := centralAngle(previous, current)
+		length := angle * earthRadius
+		pieces := int(math.Ceil(length / resolution))
+		for piece := 1; piece < pieces; piece++ {
+			result = append(result, interpolate(previous, current, angle, float64(piece)/float64(pieces)))
+		}
+		if pieces > 0 {
+			result = append(result, coordinate{lat: current.Lat(), lon: current.Lon()})
+		}
+		distance = distance + length
+	}
+	return result, distance, nil
+}
+
+// centralAngle computes the angle between the two coordinates as seen from the center of the earth (haversine formula).
+func centralAngle(from model.Coordinate, to model.Coordinate) float64 {
+	phi1 := toRadians(from.Lat())
+	phi2 := toRadians(to.Lat())
+	deltaPhi := phi2 - phi1
+	deltaLambda := toRadians(to.Lon() - from.Lon())
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+	return 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// interpolate computes the point at the given fraction of the great-circle arc between the two coordinates.
+func interpolate(from model.Coordinate, to model.Coordinate, angle float64, fraction float64) coordinate {
+	phi1, lambda1 := toRadians(from.Lat()), toRadians(from.Lon())
+	phi2, lambda2 := toRadians(to.Lat()), toRadians(to.Lon())
+	a := math.Sin((1-fraction)*angle) / math.Sin(angle)
+	b := math.Sin(fraction*angle) / math.Sin(angle)
+	x := a*math.Cos(phi1)*math.Cos(lambda1) + b*math.Cos(phi2)*math.Cos(lambda2)
+	y := a*math.Cos(phi1)*math.Sin(lambda1) + b*math.Cos(phi2)*math.Sin(lambda2)
+	z := a*math.Sin(phi1) + b*math.Sin(phi2)
+	phi := math.Atan2(z, math.Sqrt(x*x+y*y))
+	lambda := math.Atan2(y, x)
+	return coordinate{lat: toDegrees(phi), lon: toDegrees(lambda)}
+}
+
+func toRadians(degree float64) float64 {
+	return degree * (math.Pi / 180)
+}
+
+func toDegrees(radian float64) float64 {
+	return radian * (180 / math.Pi)
+}
+
+type coordinate struct {
+	lat float64
+	lon float64
+}
+
+func (c coordinate) Lat() float64 {
+	return c.lat
+}
+
+func (c coordinate) Lon() float64 {
+	return c.lon
+}
@@ -0,0 +1,40 @@
+package beeline
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewRouteService(t *testing.T) {
+	service := NewRouteService(100)
+	residenz := model.WayPoint{Latitude: 49.79287, Longitude: 9.93648}
+	busbahnhof := model.WayPoint{Latitude: 49.80128, Longitude: 9.93410}
+	sanderau := model.WayPoint{Latitude: 49.78227, Longitude: 9.96701}
+
+	t.Run("single leg", func(t *testing.T) {
+		route, distance, err := service(residenz, busbahnhof)
+		require.NoError(t, err)
+		assert.InDelta(t, 950.6, distance, 0.1, "distance between the coordinates")
+		require.Equal(t, 11, len(route), "number of coordinates")
+		assert.Equal(t, residenz.Lat(), route[0].Lat(), "first coordinate")
+		assert.Equal(t, busbahnhof.Lon(), route[10].Lon(), "last coordinate")
+		assert.InDelta(t, 49.79708, route[5].Lat(), 0.00001, "latitude of the coordinate in the middle")
+		assert.InDelta(t, 9.93529, route[5].Lon(), 0.00001, "longitude of the coordinate in the middle")
+	})
+	t.Run("several legs", func(t *testing.T) {
+		first, firstDistance, _ := service(busbahnhof, residenz)
+		second, secondDistance, _ := service(residenz, sanderau)
+		route, distance, err := service(busbahnhof, residenz, sanderau)
+		require.NoError(t, err)
+		assert.Equal(t, firstDistance+secondDistance, distance, "distance of the route")
+		assert.Equal(t, len(first)+len(second)-1, len(route), "shared coordinate should be contained once")
+	})
+	t.Run("same coordinate", func(t *testing.T) {
+		route, distance, err := service(residenz, residenz)
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, distance, "distance")
+		assert.Equal(t, 1, len(route), "number of coordinates")
+	})
+}
@@ -4,99 +4,353 @@ import (
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
 	"log"
 	"math"
-	"sync"
+	"time"
 )
 
+// bus holds the dynamic state of a vehicle. A bus does not run on its own; instead, the Dispatcher advances all buses
+// in lockstep by calling tick with the current simulation time. All methods must be called while holding the
+// mutex of the dispatcher.
 type bus struct {
-	mutex             sync.Mutex
 	id                model.BusId
 	dispatcher        *Dispatcher
 	assignments       []model.Assignment
 	currentAssignment int
-	gps               model.RouteService
-	heartBeatTimer    model.Ticker
 	position          model.Coordinate
-	speed             int
 	currentStop       *model.WayPoint
+	active            bool
+	finished          bool
+	nextWayPoint      int
+	route             []model.Coordinate
+	delay             time.Duration
+	time              model.Time
+	events            []model.Event
+	// routes contains the routes of the assignments. It is filled before the simulation starts, see prefetchRoutes.
+	routes    []routes
+	heading   float64
+	speed     float64
+	mileage   float64
+	tripStart float64
+	doors     int
+	boarding  int
+	alighting int
+	// occupancy is the number of passengers on the bus.
+	occupancy int
+	// ready is the time at which all passengers have boarded and alighted at the current stop.
+	ready model.Time
+	// pace is the factor by which the travel time to the next way point is stretched, 1 if the bus is not disturbed.
+	pace float64
+	// incident is the duration of the incident that will block the bus as soon as its mileage reaches incidentAt. It
+	// is 0 if there is no incident ahead.
+	incident   time.Duration
+	incidentAt float64
+	// blocked is the remaining time (in seconds) the bus is blocked by an incident.
+	blocked float64
 }
 
-func (b *bus) start(timer model.Ticker) {
-	b.heartBeatTimer = timer
-	for index, assignment := range b.assignments {
-		b.setAssignmentIndex(index)
-		b.handleAssignment(assignment)
+func (b *bus) getCurrentAssignment() *model.Assignment {
+	return &b.assignments[b.currentAssignment]
+}
+
+func (b *bus) getState() State {
+	state := State{
+		Id:           b.id,
+		Position:     [2]float64{b.position.Lat(), b.position.Lon()},
+		NextWayPoint: b.nextWayPoint,
+		Delay:        b.delay,
+		Time:         b.time,
+		InService:    b.inService(),
+	}
+	if b.active {
+		state.Assignment = &b.assignments[b.currentAssignment]
+	}
+	if b.currentStop != nil {
+		stop := *b.currentStop
+		state.Stop = &stop
+	}
+	return state
+}
+
+// tick advances the bus to the given simulation time and returns the positions to be published. Events that
+// happen during the tick are collected in the events field.
+func (b *bus) tick(now model.Time) []model.BusPosition {
+	last := b.time
+	b.time = now
+	if b.finished {
+		return nil
+	}
+	if !b.active {
+		if now.Before(b.assignments[b.currentAssignment].Departure) {
+			return nil
+		}
+		b.active = true
+		b.nextWayPoint = -1
+		b.events = append(b.events, b.event(model.AssignmentStarted))
+		first := b.getCurrentAssignment().WayPoints[0]
+		b.headForNextWayPoint()
+		if len(b.route) > 0 {
+			if b.position.Lat() != first.Lat() || b.position.Lon() != first.Lon() {
+				b.events = append(b.events, b.event(model.DeadheadStarted))
+			}
+			return nil
+		}
+	}
+	result := make([]model.BusPosition, 0, 2)
+	if b.currentStop == nil && len(b.route) > 0 {
+		b.advance(now.Sub(last).Seconds())
+		result = append(result, b.busPosition())
+		if len(b.route) > 0 {
+			return result
+		}
+	}
+	for b.active {
+		arrived := false
+		if b.currentStop == nil {
+			wayPoint := &b.getCurrentAssignment().WayPoints[b.nextWayPoint]
+			if wayPoint.Id == nil {
+				b.headForNextWayPoint()
+				if len(b.route) > 0 {
+					return result
+				}
+				continue
+			}
+			b.arriveAt(wayPoint, now)
+			arrived = true
+		}
+		if now.Before(b.currentStop.Departure) || now.Before(b.ready) || b.boardLate(now) {
+			// the position of a dwelling bus does not change, thus it is only published on arrival
+			if arrived {
+				result = append(result, b.busPosition())
+			}
+			return result
+		}
+		// the bus leaves as soon as it may, thus it drives the rest of the tick
+		departed := b.currentStop.Departure
+		if departed.Before(b.ready) {
+			departed = b.ready
+		}
+		if departed.Before(last) {
+			departed = last
+		}
+		b.delay = departed.Sub(b.currentStop.Departure)
+		departure := b.stopEvent(model.Departure)
+		departure.Time = departed
+		departure.Boarding = b.boarding
+		departure.Alighting = b.alighting
+		b.events = append(b.events, departure)
+		b.currentStop = nil
+		b.headForNextWayPoint()
+		if len(b.route) > 0 && departed.Before(now) {
+			b.advance(now.Sub(departed).Seconds())
+			result = append(result, b.busPosition())
+		}
+		if len(b.route) > 0 {
+			return result
+		}
 	}
+	return result
 }
 
-func (b *bus) setAssignmentIndex(index int) {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
-	b.currentAssignment = index
+func (b *bus) busPosition() model.BusPosition {
+	result := model.BusPosition{
+		BusId:      b.id,
+		Location:   [2]float64{b.position.Lat(), b.position.Lon()},
+		Heading:    b.heading,
+		Speed:      b.speed * 3.6,
+		Assignment: b.currentAssignment,
+		Delay:      int(b.delay / time.Second),
+		Occupancy:  b.occupancy,
+	}
+	if line := b.getCurrentAssignment().Line; line != nil {
+		result.LineId = line.Id
+	}
+	if b.currentStop != nil {
+		result.StopId = b.currentStop.Id
+		result.Departure = b.currentStop.Departure
+	}
+	if next := b.nextStop(); next != nil {
+		result.NextStopId = next.Id
+	}
+	if b.active && b.nextWayPoint > 0 {
+		result.Distance = b.mileage - b.tripStart
+	}
+	return result
 }
 
-func (b *bus) getCurrentAssignment() *model.Assignment {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
-	return &b.assignments[b.currentAssignment]
+// nextStop returns the next stop the bus will arrive at within its current assignment. If the bus is waiting at
+// a stop, this is the stop after the current stop. It returns nil if there is no such stop.
+func (b *bus) nextStop() *model.WayPoint {
+	index := b.nextWayPoint
+	if b.currentStop != nil {
+		index = index + 1
+	}
+	wayPoints := b.getCurrentAssignment().WayPoints
+	for ; index >= 0 && index < len(wayPoints); index++ {
+		if wayPoints[index].Id != nil {
+			return &wayPoints[index]
+		}
+	}
+	return nil
+}
+
+// inService returns true if the bus has started its first assignment and has not finished all assignments yet.
+func (b *bus) inService() bool {
+	return !b.finished && (b.active || b.currentAssignment > 0)
 }
 
-func (b *bus) handleAssignment(a model.Assignment) {
-	last := <-b.heartBeatTimer.HeartBeat
-	for last.Before(a.Departure) {
-		current, ok := <-b.heartBeatTimer.HeartBeat
-		if !ok {
-			return
+// headForNextWayPoint takes the route to the next way point of the current assignment from the prefetched routes. If the
+// assignment has no further way points, the bus switches to its next assignment or finishes.
+func (b *bus) headForNextWayPoint() {
+	b.route = nil
+	b.nextWayPoint = b.nextWayPoint + 1
+	if b.nextWayPoint == 1 {
+		b.tripStart = b.mileage
+	}
+	assignment := b.getCurrentAssignment()
+	if b.nextWayPoint >= len(assignment.WayPoints) {
+		b.events = append(b.events, b.event(model.AssignmentFinished))
+		b.active = false
+		b.nextWayPoint = 0
+		if b.currentAssignment == len(b.assignments)-1 {
+			b.finished = true
+		} else {
+			b.currentAssignment = b.currentAssignment + 1
 		}
-		last = current
+		return
 	}
+	b.route = b.routeToNextWayPoint(assignment)
+	b.disturb()
+}
+
+func (b *bus) routeToNextWayPoint(assignment *model.Assignment) []model.Coordinate {
+	routes := b.routes[b.currentAssignment]
+	if b.nextWayPoint == 0 {
+		return routes.approach
+	}
+	return routes.segments[b.nextWayPoint-1]
+}
+
+// routes contains the routes of an assignment: the approach to the first way point and the segments between the way points.
+type routes struct {
+	approach []model.Coordinate
+	segments [][]model.Coordinate
+}
 
-	for _, wayPoint := range a.WayPoints {
-		route, _, err := b.gps(b.position, &wayPoint)
+// prefetchRoutes takes the routes of all assignments from their resolved shapes. Only the routes of assignments whose
+// shapes are not resolved are queried from the route service. This happens before the simulation starts, thus
+// the route service is never queried while the buses are advanced.
+func (b *bus) prefetchRoutes(gps model.RouteService) {
+	if b.routes != nil {
+		return
+	}
+	query := func(from model.Coordinate, to model.Coordinate) []model.Coordinate {
+		route, _, err := gps(from, to)
 		if err != nil {
 			log.Printf("bus %s: could not find route, skipping route: %v", b.id, err)
 		}
-		for len(route) > 0 {
-			current, ok := <-b.heartBeatTimer.HeartBeat
-			if !ok {
-				return
-			}
-			deltaTime := current.Sub(last).Seconds()
-			driven := (float64(b.speed) / 3.6) * deltaTime
-			route = b.drive(route, driven)
-			last = current
-		}
-		if wayPoint.Id != nil {
-			b.currentStop = &wayPoint
+		return route
+	}
+	b.routes = make([]routes, 0, len(b.assignments))
+	from := b.position
+	for _, assignment := range b.assignments {
+		result := routes{approach: assignment.Approach}
+		if result.approach == nil {
+			result.approach = query(from, assignment.WayPoints[0])
 		}
-		for last.Before(wayPoint.Departure) {
-			b.dispatcher.publish(model.BusPosition{StopId: b.currentStop.Id, Departure: b.currentStop.Departure, BusId: b.id, Location: [2]float64{b.position.Lat(), b.position.Lon()}})
-			current, ok := <-b.heartBeatTimer.HeartBeat
-			if !ok {
-				return
+		if assignment.Shape != nil {
+			result.segments = assignment.Shape.Segments
+		} else {
+			for index := 1; index < len(assignment.WayPoints); index++ {
+				result.segments = append(result.segments, query(assignment.WayPoints[index-1], assignment.WayPoints[index]))
 			}
-			last = current
 		}
-		b.currentStop = nil
+		b.routes = append(b.routes, result)
+		from = assignment.WayPoints[len(assignment.WayPoints)-1]
+	}
+}
+
+// arriveAt lets the bus arrive at the stop. The deviation from the timetable is measured against the departure
+// time of the stop because the timetable does not specify arrival times; thus, it is negative if the bus is early.
+// The bus cannot leave the stop before the passengers have boarded and alighted, even if it is late already.
+// At the last stop of the assignment, all passengers alight and nobody boards.
+func (b *bus) arriveAt(stop *model.WayPoint, now model.Time) {
+	b.currentStop = stop
+	b.speed = 0
+	b.delay = now.Sub(stop.Departure)
+	b.events = append(b.events, b.stopEvent(model.Arrival))
+	b.boarding, b.alighting = 0, 0
+	last := b.nextStop() == nil
+	if b.dispatcher.Passengers != nil && last {
+		b.alighting = b.dispatcher.Passengers.Finish(b.id, *stop, now)
+	} else if b.dispatcher.Passengers != nil {
+		b.boarding, b.alighting = b.dispatcher.Passengers.Exchange(b.id, b.lineId(), *stop, now)
+	}
+	// the static demand of the stops may let more passengers alight than are on the bus
+	b.occupancy = b.occupancy - b.alighting
+	if b.occupancy < 0 || last {
+		b.occupancy = 0
+	}
+	b.occupancy = b.occupancy + b.boarding
+	b.ready = now.Add(b.dispatcher.Dwell.DwellTime(b.boarding, b.alighting, b.doors) + b.dwellExtension())
+}
+
+// boardLate lets the passengers board who reached the stop after the bus. If there are any, the doors are opened again
+// and the bus is not ready before they have boarded. Nobody boards at the last stop of the assignment.
+func (b *bus) boardLate(now model.Time) bool {
+	if b.dispatcher.Passengers == nil || b.nextStop() == nil {
+		return false
+	}
+	late := b.dispatcher.Passengers.Board(b.id, b.lineId(), *b.currentStop, now)
+	if late <= 0 {
+		return false
 	}
+	b.boarding = b.boarding + late
+	b.occupancy = b.occupancy + late
+	b.ready = now.Add(b.dispatcher.Dwell.DwellTime(late, 0, b.doors))
+	return now.Before(b.ready)
+}
+
+// lineId returns the line of the current assignment, or an empty id if the assignment does not serve a line.
+func (b *bus) lineId() model.LineId {
+	if assignment := b.getCurrentAssignment(); assignment.Line != nil {
+		return assignment.Line.Id
+	}
+	return ""
+}
+
+// event creates an event of the current assignment at the current time of the bus.
+func (b *bus) event(eventType model.EventType) model.Event {
+	assignment := b.getCurrentAssignment()
+	result := model.Event{Type: eventType, BusId: b.id, Time: b.time, Assignment: b.currentAssignment, Scheduled: assignment.Departure}
+	if assignment.Line != nil {
+		result.LineId = assignment.Line.Id
+	}
+	return result
+}
+
+func (b *bus) stopEvent(eventType model.EventType) model.Event {
+	result := b.event(eventType)
+	result.StopId = b.currentStop.Id
+	result.Scheduled = b.currentStop.Departure
+	return result
 }
 
 func (b *bus) drive(route []model.Coordinate, distanceToDrive float64) []model.Coordinate {
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
-	defer b.dispatcher.publish(model.BusPosition{BusId: b.id, Location: [2]float64{b.position.Lat(), b.position.Lon()}})
 	if b.position == route[0] {
 		route = route[1:]
 	}
+	if len(route) == 0 {
+		return route
+	}
 	distanceToNext := distanceTo(b.position, route[0])
 	newRoute := route
 	for distanceToDrive >= distanceToNext && len(newRoute) > 1 {
 		distanceToDrive = distanceToDrive - distanceToNext
 		distanceToNext = distanceTo(newRoute[0], newRoute[1])
-		b.position = &coordinate{newRoute[0].Lat(), newRoute[0].Lon()}
+		b.moveTo(newRoute[0].Lat(), newRoute[0].Lon())
 		newRoute = newRoute[1:]
 	}
 	if distanceToDrive >= distanceToNext {
-		b.position = &coordinate{newRoute[0].Lat(), newRoute[0].Lon()}
+		b.moveTo(newRoute[0].Lat(), newRoute[0].Lon())
 		return []model.Coordinate{}
 	}
 	lambda := distanceToDrive / distanceToNext
@@ -104,13 +358,26 @@ func (b *bus) drive(route []model.Coordinate, distanceToDrive float64) []model.C
 	deltaY := newRoute[0].Lon() - b.position.Lon()
 	lat := b.position.Lat() + lambda*deltaX
 	lon := b.position.Lon() + lambda*deltaY
-	b.position = &coordinate{lat: lat, lon: lon}
+	b.moveTo(lat, lon)
 	return newRoute
 }
 
+// moveTo sets the position of the bus and updates its heading and mileage accordingly.
+func (b *bus) moveTo(lat float64, lon float64) {
+	next := &coordinate{lat: lat, lon: lon}
+	moved := distanceTo(b.position, next)
+	if moved > 0 {
+		b.heading = bearing(b.position, next)
+	}
+	b.mileage = b.mileage + moved
+	b.position = next
+}
+
 type coordinate struct {
 	lat float64
 	lon float64
+	// speed is the segment speed in km/h, which is only set for coordinates of restored routes.
+	speed float64
 }
 
 func (c *coordinate) Lat() float64 {
@@ -121,6 +388,10 @@ func (c *coordinate) Lon() float64 {
 	return c.lon
 }
 
+func (c *coordinate) SegmentSpeed() float64 {
+	return c.speed
+}
+
 func distanceTo(c model.Coordinate, other model.Coordinate) float64 {
 	earthRadius := 6371000.0 // meters
 	delta1 := toRadians(c.Lat())
@@ -134,6 +405,20 @@ func distanceTo(c model.Coordinate, other model.Coordinate) float64 {
 	return earthRadius * atan
 }
 
+// bearing computes the initial bearing from c to other in degrees, measured clockwise from north.
+func bearing(c model.Coordinate, other model.Coordinate) float64 {
+	phi1 := toRadians(c.Lat())
+	phi2 := toRadians(other.Lat())
+	deltaLambda := toRadians(other.Lon() - c.Lon())
+	y := math.Sin(deltaLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(deltaLambda)
+	return math.Mod(toDegrees(math.Atan2(y, x))+360, 360)
+}
+
 func toRadians(degree float64) float64 {
 	return degree * (math.Pi / 180)
 }
+
+func toDegrees(radian float64) float64 {
+	return radian * (180 / math.Pi)
+}
@@ -1,10 +1,13 @@
 package bus
 
 import (
+	"encoding/json"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"sync"
 	"testing"
+	"time"
 )
 
 type mockModel struct {
@@ -68,3 +71,774 @@ func TestDispatcher_Start(t *testing.T) {
 	// but we are working with the assumption that the earth is a perfect sphere.
 	assert.Equal(t, 8, len(positions))
 }
+
+func TestDispatcher_Run_Deterministic(t *testing.T) {
+	buses := []model.Bus{
+		{
+			Id: "Bus1",
+			Assignments: []model.Assignment{
+				{
+					Departure: model.MustParseTime("16:49"),
+					WayPoints: []model.WayPoint{
+						{Longitude: 9.95075, Latitude: 49.79993},
+						{Longitude: 9.94932, Latitude: 49.79900},
+						{Longitude: 9.94550, Latitude: 49.79886},
+					},
+				},
+			},
+		},
+		{
+			Id: "Bus2",
+			Assignments: []model.Assignment{
+				{
+					Departure: model.MustParseTime("16:50"),
+					WayPoints: []model.WayPoint{
+						{Longitude: 9.94449, Latitude: 49.79871},
+						{Longitude: 9.94316, Latitude: 49.79919},
+						{Longitude: 9.94932, Latitude: 49.79900},
+					},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	run := func() ([]model.BusPosition, []model.Time) {
+		positions := make([]model.BusPosition, 0)
+		times := make([]model.Time, 0)
+		var dispatcher *Dispatcher
+		publisher := func(position model.BusPosition) {
+			positions = append(positions, position)
+			times = append(times, dispatcher.Now())
+		}
+		dispatcher = NewDispatcher(&mockModel{buses: buses}, publisher, routeService)
+		dispatcher.Frequency = 1000
+		dispatcher.Warp = 10000
+		dispatcher.Run(model.MustParseTime("16:49"))
+		return positions, times
+	}
+	positions1, times1 := run()
+	positions2, times2 := run()
+	require.NotEmpty(t, positions1, "positions should have been published")
+	assert.Equal(t, positions1, positions2, "two runs should publish the same positions")
+	assert.Equal(t, times1, times2, "two runs should publish at the same simulation times")
+	assert.Equal(t, model.BusId("Bus1"), positions1[0].BusId, "first bus to drive")
+	assert.Equal(t, model.MustParseTime("16:49").Add(10*time.Second), times1[0], "time of the first position")
+}
+
+func TestDispatcher_Control(t *testing.T) {
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Longitude: 9.95075, Latitude: 49.79993},
+					{Longitude: 9.94932, Latitude: 49.79900},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	statuses := make(chan Status, 1000)
+	dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(model.BusPosition) {}, routeService)
+	dispatcher.PublishStatus = func(status Status) {
+		statuses <- status
+	}
+	dispatcher.Frequency = 100
+	assert.Equal(t, Pending, dispatcher.Status().State, "state before start")
+	assert.Error(t, dispatcher.JumpTo(model.MustParseTime("16:30")), "jumping should not be possible before start")
+	done := make(chan bool)
+	go func() {
+		dispatcher.Run(model.MustParseTime("16:00"))
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return dispatcher.Status().State == Running }, time.Second, time.Millisecond, "simulation should run")
+
+	require.NoError(t, dispatcher.Pause())
+	assert.Error(t, dispatcher.Pause(), "pausing twice should not be possible")
+	paused := dispatcher.Now()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, paused, dispatcher.Now(), "time should not pass while paused")
+
+	assert.Error(t, dispatcher.SetWarp(0), "warp must be positive")
+	require.NoError(t, dispatcher.SetWarp(60))
+	assert.Equal(t, 60.0, dispatcher.Status().Warp, "warp after change")
+	require.NoError(t, dispatcher.JumpTo(model.MustParseTime("16:30")))
+	assert.Equal(t, model.MustParseTime("16:30"), dispatcher.Now(), "time after jump")
+	assert.Equal(t, Paused, dispatcher.Status().State, "jumping should not resume the simulation")
+	assert.Error(t, dispatcher.JumpTo(model.MustParseTime("16:20")), "jumping back should not be possible")
+
+	require.NoError(t, dispatcher.Resume())
+	require.NoError(t, dispatcher.JumpTo(model.MustParseTime("17:30")))
+	<-done
+	assert.Equal(t, Finished, dispatcher.Status().State, "state after all assignments are served")
+	close(statuses)
+	var last Status
+	for status := range statuses {
+		last = status
+	}
+	assert.Equal(t, Status{Type: "simulation", Time: dispatcher.Now(), State: Finished, Warp: 60}, last, "last published status")
+}
+
+func TestDispatcher_RunHeadless(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9.95075, Latitude: 49.79993},
+					{Longitude: 9.94932, Latitude: 49.79900},
+					{Id: &stop2, Departure: model.MustParseTime("17:02"), Longitude: 9.94550, Latitude: 49.79886},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	run := func(headless bool) ([]model.BusPosition, []model.Event) {
+		positions := make([]model.BusPosition, 0)
+		events := make([]model.Event, 0)
+		dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+			positions = append(positions, position)
+		}, routeService)
+		dispatcher.PublishEvent = func(event model.Event) {
+			events = append(events, event)
+		}
+		dispatcher.Frequency = 1000
+		dispatcher.Warp = 10000
+		if headless {
+			dispatcher.RunHeadless(model.MustParseTime("16:58"))
+		} else {
+			dispatcher.Run(model.MustParseTime("16:58"))
+		}
+		assert.Equal(t, Finished, dispatcher.Status().State, "state after the run")
+		return positions, events
+	}
+	positions, events := run(true)
+	expectedPositions, expectedEvents := run(false)
+	assert.Equal(t, expectedPositions, positions, "headless run should publish the same positions")
+	assert.Equal(t, expectedEvents, events, "headless run should publish the same events")
+	require.Equal(t, 6, len(events), "number of events")
+	assert.Equal(t, model.Event{Type: model.AssignmentStarted, BusId: "Bus1", Time: model.MustParseTime("17:00"), Scheduled: model.MustParseTime("17:00")}, events[0], "first event")
+	// the bus needs one tick to approach the first stop of the assignment, thus it arrives late
+	assert.Equal(t, model.Event{Type: model.Arrival, BusId: "Bus1", Time: model.MustParseTime("17:00").Add(10 * time.Second), StopId: &stop1, Scheduled: model.MustParseTime("17:00")}, events[1], "second event")
+	assert.Equal(t, model.Departure, events[2].Type, "third event")
+	assert.Equal(t, model.Event{Type: model.Departure, BusId: "Bus1", Time: model.MustParseTime("17:02"), StopId: &stop2, Scheduled: model.MustParseTime("17:02")}, events[4], "fifth event")
+	assert.Equal(t, model.Event{Type: model.AssignmentFinished, BusId: "Bus1", Time: model.MustParseTime("17:02"), Scheduled: model.MustParseTime("17:00")}, events[5], "last event")
+}
+
+func TestDispatcher_Deadhead(t *testing.T) {
+	line := model.Line{Id: "L1"}
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Longitude: 9.95075, Latitude: 49.79993},
+					{Longitude: 9.94932, Latitude: 49.79900},
+				},
+			},
+			{
+				Line:      &line,
+				Departure: model.MustParseTime("17:05"),
+				WayPoints: []model.WayPoint{
+					{Longitude: 9.94550, Latitude: 49.79886},
+					{Longitude: 9.94449, Latitude: 49.79871},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	events := make([]model.Event, 0)
+	dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(model.BusPosition) {}, routeService)
+	dispatcher.PublishEvent = func(event model.Event) {
+		events = append(events, event)
+	}
+	dispatcher.Frequency = 1000
+	dispatcher.Warp = 10000
+	dispatcher.RunHeadless(model.MustParseTime("16:58"))
+	types := make([]model.EventType, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	expected := []model.EventType{model.AssignmentStarted, model.AssignmentFinished, model.AssignmentStarted, model.DeadheadStarted, model.AssignmentFinished}
+	require.Equal(t, expected, types, "types of the events")
+	assert.Equal(t, model.MustParseTime("17:05"), events[3].Time, "start of the deadhead")
+	assert.Equal(t, model.LineId("L1"), events[3].LineId, "line of the deadhead")
+	assert.Equal(t, 1, events[3].Assignment, "assignment of the deadhead")
+}
+
+func TestDispatcher_Dwelling(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9.95075, Latitude: 49.79993},
+					{Id: &stop2, Departure: model.MustParseTime("17:10"), Longitude: 9.94932, Latitude: 49.79900},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	var dispatcher *Dispatcher
+	atStop := make([]model.BusPosition, 0)
+	snapshots := make([][]model.BusPosition, 0)
+	dispatcher = NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+		if position.StopId != nil {
+			atStop = append(atStop, position)
+			snapshots = append(snapshots, dispatcher.QueryBusPositions())
+		}
+	}, routeService)
+	dispatcher.Frequency = 1000
+	dispatcher.Warp = 10000
+	assert.Empty(t, dispatcher.QueryBusPositions(), "bus should not be in service before the start")
+	dispatcher.RunHeadless(model.MustParseTime("16:58"))
+	// the bus arrives late at the first stop (see TestDispatcher_RunHeadless), thus it does not wait there
+	require.Equal(t, 1, len(atStop), "dwelling buses should publish their position only on arrival")
+	assert.Equal(t, &stop2, atStop[0].StopId, "stop of the arrival")
+	assert.Equal(t, model.MustParseTime("17:10"), atStop[0].Departure, "departure at the stop")
+	assert.Equal(t, []model.BusPosition{atStop[0]}, snapshots[0], "positions while waiting at the stop")
+	assert.Empty(t, dispatcher.QueryBusPositions(), "bus should not be in service after finishing")
+}
+
+func TestDispatcher_DepartureWithinTick(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	departure := model.MustParseTime("17:00").Add(25 * time.Second)
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: departure, Longitude: 9.95075, Latitude: 49.79993},
+					{Id: &stop2, Departure: model.MustParseTime("17:10"), Longitude: 9.94932, Latitude: 49.79900},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	positions := make(map[model.Time]model.BusPosition)
+	events := make([]model.Event, 0)
+	var dispatcher *Dispatcher
+	dispatcher = NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+		positions[dispatcher.Now()] = position
+	}, routeService)
+	dispatcher.PublishEvent = func(event model.Event) {
+		events = append(events, event)
+	}
+	dispatcher.Frequency = 1000
+	dispatcher.Warp = 10000
+	dispatcher.Acceleration = 0
+	dispatcher.RunHeadless(model.MustParseTime("17:00"))
+	require.True(t, len(events) > 3, "events expected")
+	assert.Equal(t, model.Departure, events[2].Type, "type of the third event")
+	assert.Equal(t, departure, events[2].Time, "the bus departs between two ticks")
+	// the bus drives 5 of the 10 seconds of the tick
+	assert.InDelta(t, 55.6, positions[model.MustParseTime("17:00").Add(30*time.Second)].Distance, 0.1, "distance after the departure")
+}
+
+func TestDispatcher_PrefetchRoutes(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9.95075, Latitude: 49.79993},
+					{Longitude: 9.94932, Latitude: 49.79900},
+				},
+			},
+			{
+				Departure: model.MustParseTime("17:10"),
+				WayPoints: []model.WayPoint{
+					{Longitude: 9.94550, Latitude: 49.79886},
+					{Longitude: 9.94316, Latitude: 49.79919},
+				},
+			},
+		},
+	}
+	ticked := false
+	queries := 0
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		assert.False(t, ticked, "the route service must not be queried while simulating")
+		queries = queries + 1
+		return coordinates, 0, nil
+	}
+	dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(model.BusPosition) {}, routeService)
+	dispatcher.AfterTick = func(model.Time) {
+		ticked = true
+	}
+	dispatcher.Frequency = 1000
+	dispatcher.Warp = 10000
+	dispatcher.RunHeadless(model.MustParseTime("17:00"))
+	// one approach and one segment per assignment
+	assert.Equal(t, 4, queries, "number of queried routes")
+	assert.Equal(t, Finished, dispatcher.Status().State, "state after the run")
+}
+
+func TestDispatcher_VehicleState(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	line := model.Line{Id: "L1"}
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Line:      &line,
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9.95075, Latitude: 49.79993},
+					{Longitude: 9.94932, Latitude: 49.79900},
+					{Id: &stop2, Departure: model.MustParseTime("17:10"), Longitude: 9.94550, Latitude: 49.79886},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	positions := make([]model.BusPosition, 0)
+	dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+		positions = append(positions, position)
+	}, routeService)
+	dispatcher.Frequency = 1000
+	dispatcher.Warp = 10000
+	dispatcher.RunHeadless(model.MustParseTime("16:58"))
+	require.True(t, len(positions) > 3, "number of positions")
+
+	driving := positions[1]
+	assert.Equal(t, model.LineId("L1"), driving.LineId, "line of the bus")
+	assert.Equal(t, 0, driving.Assignment, "assignment index")
+	assert.Equal(t, &stop2, driving.NextStopId, "next stop")
+	assert.Equal(t, 10, driving.Delay, "the bus left the first stop late")
+	assert.InDelta(t, 40, driving.Speed, 0.01, "speed while driving")
+	assert.InDelta(t, 224.8, driving.Heading, 0.1, "heading towards the south west")
+	assert.InDelta(t, 111.1, driving.Distance, 0.1, "distance after one tick")
+	assert.Greater(t, positions[2].Distance, driving.Distance, "distance should increase")
+
+	last := positions[len(positions)-1]
+	assert.Equal(t, &stop2, last.StopId, "last position should be at the last stop")
+	assert.Equal(t, 0.0, last.Speed, "speed at the stop")
+	assert.Nil(t, last.NextStopId, "there is no stop after the last stop")
+	assert.InDelta(t, 420.3, last.Distance, 0.1, "length of the trip")
+}
+
+func TestDispatcher_Checkpoint(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9.95075, Latitude: 49.79993},
+					{Longitude: 9.94932, Latitude: 49.79900},
+					{Id: &stop2, Departure: model.MustParseTime("17:02"), Longitude: 9.94550, Latitude: 49.79886},
+				},
+			},
+			{
+				Departure: model.MustParseTime("17:04"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop2, Departure: model.MustParseTime("17:04"), Longitude: 9.94550, Latitude: 49.79886},
+					{Id: &stop1, Departure: model.MustParseTime("17:06"), Longitude: 9.95075, Latitude: 49.79993},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	newDispatcher := func(output *[]interface{}) *Dispatcher {
+		dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+			*output = append(*output, position)
+		}, routeService)
+		dispatcher.PublishEvent = func(event model.Event) {
+			*output = append(*output, event)
+		}
+		dispatcher.Frequency = 0.2
+		dispatcher.Acceleration = 1
+		dispatcher.Deceleration = 1
+		return dispatcher
+	}
+	start := model.MustParseTime("16:59")
+	step := 5 * time.Second
+	// ticks runs the dispatcher from the given time until all buses have finished and returns the time of every tick
+	ticks := func(dispatcher *Dispatcher, next model.Time) []model.Time {
+		result := make([]model.Time, 0)
+		for ; ; next = next.Add(step) {
+			result = append(result, next)
+			if dispatcher.tick(next) {
+				return result
+			}
+		}
+	}
+	expected := make([]interface{}, 0)
+	original := newDispatcher(&expected)
+	original.start(start)
+	times := ticks(original, start)
+
+	// checkpoints before the departure, at a stop, while driving, and between the assignments
+	for _, index := range []int{5, 18, 30, 50} {
+		output := make([]interface{}, 0)
+		dispatcher := newDispatcher(&output)
+		dispatcher.start(start)
+		for _, now := range times[:index] {
+			dispatcher.tick(now)
+		}
+		checkpoint := dispatcher.Checkpoint()
+		assert.Equal(t, times[index-1], checkpoint.Time, "time of the checkpoint")
+		data, err := json.Marshal(checkpoint)
+		require.NoError(t, err)
+		var loaded Checkpoint
+		require.NoError(t, json.Unmarshal(data, &loaded))
+
+		restored := make([]interface{}, 0)
+		dispatcher = newDispatcher(&restored)
+		require.NoError(t, dispatcher.Restore(loaded))
+		// the clock starts at the checkpoint, not at the given time
+		next := dispatcher.start(start)
+		assert.Equal(t, loaded.Time.Add(step), next, "first tick after restoring")
+		ticks(dispatcher, next)
+		assert.Equal(t, expected[len(output):], restored, "restored simulation after tick %d should continue like the original", index)
+	}
+
+	dispatcher := newDispatcher(&expected)
+	invalid := original.Checkpoint()
+	invalid.Buses[0].Assignment = 2
+	assert.EqualError(t, dispatcher.Restore(invalid), "bus \"Bus1\" has no assignment with index 2")
+	invalid.Buses[0].Id = "Bus2"
+	assert.EqualError(t, dispatcher.Restore(invalid), "bus \"Bus2\" of the checkpoint is not part of the scenario")
+	invalid.Version = 0
+	assert.EqualError(t, dispatcher.Restore(invalid), "checkpoint has version 0, but only version 3 is supported")
+}
+
+func TestDispatcher_Stop(t *testing.T) {
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Longitude: 9.95075, Latitude: 49.79993},
+					{Longitude: 9.94932, Latitude: 49.79900},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(model.BusPosition) {}, routeService)
+	dispatcher.Frequency = 100
+	done := make(chan bool)
+	go func() {
+		dispatcher.Run(model.MustParseTime("16:00"))
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return dispatcher.Status().State == Running }, time.Second, time.Millisecond, "simulation should run")
+	dispatcher.Stop()
+	<-done
+	assert.Equal(t, Finished, dispatcher.Status().State, "state after stopping")
+	assert.False(t, dispatcher.Checkpoint().Buses[0].Finished, "bus should not have finished")
+	dispatcher.Stop()
+}
+
+func TestDispatcher_Kinematics(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49},
+					{Id: &stop2, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49.0072},
+				},
+			},
+		},
+	}
+	// the route is about 800 meters long; between 300 and 500 meters, the segment speed is 18 km/h
+	route := []model.Coordinate{
+		&coordinate{lat: 49, lon: 9},
+		&coordinate{lat: 49.0027, lon: 9},
+		&coordinate{lat: 49.0045, lon: 9, speed: 18},
+		&coordinate{lat: 49.0072, lon: 9},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		if coordinates[0].Lat() == coordinates[1].Lat() {
+			return coordinates, 0, nil
+		}
+		return route, 800, nil
+	}
+	run := func(acceleration float64, deceleration float64) ([]model.BusPosition, model.Time) {
+		positions := make([]model.BusPosition, 0)
+		var arrival model.Time
+		dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+			positions = append(positions, position)
+		}, routeService)
+		dispatcher.PublishEvent = func(event model.Event) {
+			if event.Type == model.Arrival && *event.StopId == stop2 {
+				arrival = event.Time
+			}
+		}
+		dispatcher.Frequency = 1
+		dispatcher.BusSpeedKmh = 36
+		dispatcher.Acceleration = acceleration
+		dispatcher.Deceleration = deceleration
+		dispatcher.RunHeadless(model.MustParseTime("17:00"))
+		return positions, arrival
+	}
+
+	positions, arrival := run(1, 1)
+	require.True(t, len(positions) > 10, "number of positions")
+	assert.InDelta(t, 3.6, positions[1].Speed, 0.01, "speed after accelerating for one second")
+	for _, position := range positions {
+		assert.LessOrEqual(t, position.Speed, 36.0+1e-9, "the bus should not exceed its speed")
+		if position.Distance > 301 && position.Distance < 500 {
+			assert.LessOrEqual(t, position.Speed, 18.0+1e-9, "the bus should not exceed the segment speed after %.0fm", position.Distance)
+		}
+	}
+	last := positions[len(positions)-1]
+	assert.InDelta(t, 800.6, last.Distance, 0.1, "the bus should reach the stop")
+	assert.Equal(t, 0.0, last.Speed, "the bus should brake into the stop")
+	assert.Less(t, positions[len(positions)-2].Speed, 5.0, "speed one second before the stop")
+
+	_, instant := run(0, 0)
+	assert.InDelta(t, 100, instant.Sub(model.MustParseTime("17:00")).Seconds(), 2, "travel time without acceleration")
+	// 10s for accelerating to 10 m/s (50m), 21s at 10 m/s, 5s for braking to 5 m/s (37.5m), 40s at 5 m/s, 5s for
+	// accelerating again, 21s at 10 m/s and 10s for braking into the stop
+	assert.InDelta(t, 112.5, arrival.Sub(model.MustParseTime("17:00")).Seconds(), 3, "travel time")
+}
+
+func TestDispatcher_PassengerDwell(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	stop3 := model.StopId("stop3")
+	bus1 := model.Bus{
+		Id:    "Bus1",
+		Doors: 2,
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49},
+					{Id: &stop2, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49.0036, Boarding: 20, Alighting: 5},
+					{Id: &stop3, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49.0072},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	run := func(passengers PassengerCounter) map[model.StopId][]model.Event {
+		events := make(map[model.StopId][]model.Event)
+		dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(model.BusPosition) {}, routeService)
+		dispatcher.PublishEvent = func(event model.Event) {
+			if event.StopId != nil {
+				events[*event.StopId] = append(events[*event.StopId], event)
+			}
+		}
+		dispatcher.Frequency = 1
+		dispatcher.BusSpeedKmh = 36
+		dispatcher.Dwell = DwellModel{Overhead: 4 * time.Second, BoardingTime: 2 * time.Second, AlightingTime: time.Second}
+		dispatcher.Passengers = passengers
+		dispatcher.RunHeadless(model.MustParseTime("17:00"))
+		return events
+	}
+
+	empty := run(nil)
+	crowded := run(StopDemand{})
+	require.Equal(t, 2, len(crowded[stop2]), "events at the crowded stop")
+	require.Equal(t, 2, len(crowded[stop3]), "events at the last stop")
+	assert.Equal(t, empty[stop2][0].Time, crowded[stop2][0].Time, "arrival at the crowded stop")
+	departure := crowded[stop2][1]
+	assert.Equal(t, 20, departure.Boarding, "boarding passengers")
+	assert.Equal(t, 5, departure.Alighting, "alighting passengers")
+	// 4s for the doors and 10 passengers boarding through each door
+	assert.Equal(t, 24*time.Second, departure.Time.Sub(crowded[stop2][0].Time), "dwell time at the crowded stop")
+	assert.Equal(t, 24*time.Second, crowded[stop3][0].Time.Sub(empty[stop3][0].Time), "delay at the next stop")
+	assert.Equal(t, 0, crowded[stop3][1].Boarding, "boarding passengers at the last stop")
+
+	late := run(&latePassengers{late: map[model.StopId]int{stop2: 3}})
+	require.Equal(t, 2, len(late[stop2]), "events at the stop with late passengers")
+	assert.Equal(t, 23, late[stop2][1].Boarding, "boarding passengers including the late ones")
+	// the doors are opened again for 4s and 2 passengers boarding through one door
+	assert.Equal(t, 32*time.Second, late[stop2][1].Time.Sub(late[stop2][0].Time), "dwell time with late passengers")
+}
+
+// latePassengers takes the static demand of the stops and lets the given number of passengers board in the last moment.
+type latePassengers struct {
+	StopDemand
+	late map[model.StopId]int
+}
+
+func (l *latePassengers) Board(_ model.BusId, _ model.LineId, stop model.WayPoint, _ model.Time) int {
+	result := l.late[*stop.Id]
+	delete(l.late, *stop.Id)
+	return result
+}
+
+func TestDispatcher_Occupancy(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	stop3 := model.StopId("stop3")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:01"), Longitude: 9, Latitude: 49, Boarding: 10, Alighting: 5},
+					{Id: &stop2, Departure: model.MustParseTime("17:02"), Longitude: 9, Latitude: 49.0036, Boarding: 3, Alighting: 4},
+					{Id: &stop3, Departure: model.MustParseTime("17:03"), Longitude: 9, Latitude: 49.0072, Boarding: 8, Alighting: 2},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	occupancy := make(map[model.StopId]int)
+	dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+		if position.StopId != nil {
+			occupancy[*position.StopId] = position.Occupancy
+		}
+	}, routeService)
+	var last model.Event
+	dispatcher.PublishEvent = func(event model.Event) {
+		if event.Type == model.Departure && event.StopId != nil && *event.StopId == stop3 {
+			last = event
+		}
+	}
+	dispatcher.Frequency = 1
+	dispatcher.BusSpeedKmh = 36
+	dispatcher.RunHeadless(model.MustParseTime("17:00"))
+	assert.Equal(t, 10, occupancy[stop1], "occupancy should not become negative")
+	assert.Equal(t, 9, occupancy[stop2], "occupancy after the exchange")
+	assert.Equal(t, 0, occupancy[stop3], "all passengers should alight at the last stop")
+	assert.Equal(t, 0, last.Boarding, "nobody should board at the last stop")
+	assert.Equal(t, 2, last.Alighting, "alighting passengers at the last stop")
+}
+
+func TestDwellModel_DwellTime(t *testing.T) {
+	dwell := DwellModel{Overhead: 4 * time.Second, BoardingTime: 3 * time.Second, AlightingTime: 2 * time.Second, Doors: 2}
+	assert.Equal(t, time.Duration(0), dwell.DwellTime(0, 0, 3), "no passengers")
+	assert.Equal(t, 10*time.Second, dwell.DwellTime(3, 0, 0), "default doors")
+	assert.Equal(t, 7*time.Second, dwell.DwellTime(3, 0, 3), "one passenger per door")
+	assert.Equal(t, 24*time.Second, dwell.DwellTime(2, 10, 1), "alighting takes longer than boarding")
+}
+
+func TestDispatcher_Disturbances(t *testing.T) {
+	stop1 := model.StopId("stop1")
+	stop2 := model.StopId("stop2")
+	stop3 := model.StopId("stop3")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Departure: model.MustParseTime("17:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stop1, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49},
+					{Id: &stop2, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49.0036},
+					{Id: &stop3, Departure: model.MustParseTime("17:00"), Longitude: 9, Latitude: 49.0072},
+				},
+			},
+		},
+	}
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	run := func(disturbances *model.Disturbances) ([]model.BusPosition, []model.Event) {
+		positions := make([]model.BusPosition, 0)
+		events := make([]model.Event, 0)
+		dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(position model.BusPosition) {
+			positions = append(positions, position)
+		}, routeService)
+		dispatcher.PublishEvent = func(event model.Event) {
+			events = append(events, event)
+		}
+		dispatcher.Frequency = 1
+		dispatcher.BusSpeedKmh = 36
+		dispatcher.Disturbances = disturbances
+		dispatcher.RunHeadless(model.MustParseTime("17:00"))
+		return positions, events
+	}
+	// find returns the time of the first event of the given type at the stop
+	find := func(events []model.Event, eventType model.EventType, stop model.StopId) model.Time {
+		for _, event := range events {
+			if event.Type == eventType && event.StopId != nil && *event.StopId == stop {
+				return event.Time
+			}
+		}
+		require.Fail(t, "event not found", "%s at %s", eventType, stop)
+		return 0
+	}
+	seed := func(seed int64) *int64 {
+		return &seed
+	}
+
+	_, undisturbed := run(nil)
+	assert.NotEqual(t, model.RunStarted, undisturbed[0].Type, "undisturbed runs should not announce a seed")
+	arrival := find(undisturbed, model.Arrival, stop3)
+
+	t.Run("travel time noise", func(t *testing.T) {
+		positions, events := run(&model.Disturbances{Seed: seed(8), TravelTimeNoise: 0.3})
+		assert.Equal(t, model.Event{Type: model.RunStarted, Time: model.MustParseTime("17:00"), Seed: seed(8)}, events[0], "announced seed")
+		again, eventsAgain := run(&model.Disturbances{Seed: seed(8), TravelTimeNoise: 0.3})
+		assert.Equal(t, positions, again, "positions with the same seed")
+		assert.Equal(t, events, eventsAgain, "events with the same seed")
+		assert.NotEqual(t, arrival, find(events, model.Arrival, stop3), "the noise should change the travel time")
+		_, other := run(&model.Disturbances{Seed: seed(7), TravelTimeNoise: 0.3})
+		assert.NotEqual(t, find(events, model.Arrival, stop3), find(other, model.Arrival, stop3), "arrival with another seed")
+	})
+	t.Run("demand seed", func(t *testing.T) {
+		events := make([]model.Event, 0)
+		dispatcher := NewDispatcher(&mockModel{buses: []model.Bus{bus1}}, func(model.BusPosition) {}, routeService)
+		dispatcher.PublishEvent = func(event model.Event) {
+			events = append(events, event)
+		}
+		dispatcher.DemandSeed = seed(3)
+		dispatcher.RunHeadless(model.MustParseTime("17:00"))
+		assert.Equal(t, model.Event{Type: model.RunStarted, Time: model.MustParseTime("17:00"), DemandSeed: seed(3)}, events[0], "announced demand seed")
+	})
+	t.Run("incidents", func(t *testing.T) {
+		_, events := run(&model.Disturbances{Seed: seed(7), IncidentProbability: 1, IncidentMinDuration: time.Minute, IncidentMaxDuration: time.Minute})
+		counts := make(map[model.EventType]int)
+		for _, event := range events {
+			counts[event.Type] = counts[event.Type] + 1
+		}
+		assert.Equal(t, 2, counts[model.IncidentStarted], "one incident on every way between two stops")
+		assert.Equal(t, 2, counts[model.IncidentFinished], "finished incidents")
+		assert.InDelta(t, 120, find(events, model.Arrival, stop3).Sub(arrival).Seconds(), 2, "delay by the incidents")
+	})
+	t.Run("dwell extensions", func(t *testing.T) {
+		_, events := run(&model.Disturbances{Seed: seed(7), DwellExtensionProbability: 1, DwellExtensionMean: time.Minute})
+		dwell := find(events, model.Departure, stop2).Sub(find(events, model.Arrival, stop2))
+		assert.True(t, dwell > 0, "the bus should dwell at the stop, but dwelled %v", dwell)
+		assert.True(t, arrival.Before(find(events, model.Arrival, stop3)), "the extension should delay the arrival at the next stop")
+	})
+}
@@ -0,0 +1,255 @@
+package bus
+
+import (
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"time"
+)
+
+// CheckpointVersion is the version of the checkpoint format. Checkpoints of other versions cannot be restored.
+//
+// Version 2 added the segment speeds of the route, the passenger exchange at the stop, and the disturbances to BusSnapshot.
+// Version 3 added the occupancy to BusSnapshot.
+const CheckpointVersion = 3
+
+// Checkpoint contains the dynamic state of the buses of a simulation. A simulation restored from a checkpoint produces
+// the same positions and events as the original simulation would have produced, provided that the scenario is the same
+// and the Passengers of the dispatcher are not simulated individually. The passengers waiting at the stops and riding
+// the buses are not part of the checkpoint, thus a restored passenger simulation only contains the passengers appearing
+// after the checkpoint and its buses may dwell shorter at the stops.
+type Checkpoint struct {
+	Version int           `json:"version"`
+	Time    model.Time    `json:"time"`
+	Buses   []BusSnapshot `json:"buses"`
+}
+
+// BusSnapshot contains the dynamic state of a single bus.
+type BusSnapshot struct {
+	Id model.BusId `json:"id"`
+	// Assignment is the index of the assignment the bus is serving or waiting for.
+	Assignment int  `json:"assignment"`
+	Active     bool `json:"active"`
+	Finished   bool `json:"finished"`
+	// NextWayPoint is the index of the way point of the assignment the bus is heading to or waiting at.
+	NextWayPoint int `json:"nextWayPoint"`
+	// AtStop is true if the bus is waiting at the way point NextWayPoint.
+	AtStop bool `json:"atStop"`
+	// Ready is the time at which the passenger exchange at the stop is finished. Boarding and Alighting are
+	// the numbers of passengers of the exchange. All three are only relevant if AtStop is true.
+	Ready     model.Time `json:"ready,omitempty"`
+	Boarding  int        `json:"boarding,omitempty"`
+	Alighting int        `json:"alighting,omitempty"`
+	// Occupancy is the number of passengers on the bus.
+	Occupancy int        `json:"occupancy,omitempty"`
+	Position  [2]float64 `json:"position"`
+	// Route is the remaining route to the next way point.
+	Route [][2]float64 `json:"route"`
+	// SegmentSpeeds contains the speeds on the segments leading to the coordinates of the route in km/h (0 if unknown).
+	// It is omitted if no segment speeds are known.
+	SegmentSpeeds []float64 `json:"segmentSpeeds,omitempty"`
+	// Delay is given in milliseconds.
+	Delay     int64      `json:"delay"`
+	Time      model.Time `json:"time"`
+	Heading   float64    `json:"heading"`
+	Speed     float64    `json:"speed"`
+	Mileage   float64    `json:"mileage"`
+	TripStart float64    `json:"tripStart"`
+	// Pace is the factor by which the travel time to the next way point is stretched by the disturbances.
+	Pace float64 `json:"pace,omitempty"`
+	// Incident is the duration (in milliseconds) of the incident ahead at the mileage IncidentAt, and Blocked is the
+	// remaining time (in seconds) the bus is blocked by an incident.
+	Incident   int64   `json:"incident,omitempty"`
+	IncidentAt float64 `json:"incidentAt,omitempty"`
+	Blocked    float64 `json:"blocked,omitempty"`
+}
+
+type restore struct {
+	checkpoint Checkpoint
+	done       chan error
+}
+
+// Checkpoint returns the current state of the simulation. Since the buses are advanced under the mutex of the
+// dispatcher, the checkpoint always lies between two ticks.
+func (d *Dispatcher) Checkpoint() Checkpoint {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	result := Checkpoint{Version: CheckpointVersion, Time: d.now, Buses: make([]BusSnapshot, 0, len(d.sortedBuses))}
+	for _, bus := range d.sortedBuses {
+		result.Buses = append(result.Buses, bus.snapshot())
+	}
+	return result
+}
+
+// Restore sets the state of the simulation to the given checkpoint. If the simulation has not been started yet,
+// the state is taken over immediately; Run or RunHeadless then continue with the tick following the checkpoint,
+// regardless of the start time given to them.
+// If the simulation is running or paused, the simulation continues from the checkpoint and the positions
+// of all buses in service are published. The checkpoint must have been created for the same scenario. The Passengers
+// of the dispatcher are not restored, thus callers simulating the passengers individually must reset them on their own.
+func (d *Dispatcher) Restore(checkpoint Checkpoint) error {
+	if err := d.validate(checkpoint); err != nil {
+		return err
+	}
+	// the state is checked and a pending simulation is restored under the mutex, since start changes the state under it
+	d.mutex.Lock()
+	state := d.state
+	if state == Pending {
+		d.apply(checkpoint)
+		d.restored = true
+	}
+	d.mutex.Unlock()
+	if state == Finished {
+		return fmt.Errorf("the simulation is %s", state)
+	}
+	if state == Pending {
+		return nil
+	}
+	request := restore{checkpoint: checkpoint, done: make(chan error, 1)}
+	select {
+	case d.restores <- request:
+		return <-request.done
+	case <-d.stopped:
+		return fmt.Errorf("the simulation is %s", Finished)
+	}
+}
+
+// restore applies the checkpoint of the request while the simulation runs and returns the time of the next tick.
+func (d *Dispatcher) restore(request restore) model.Time {
+	defer close(request.done)
+	d.mutex.Lock()
+	d.apply(request.checkpoint)
+	positions := make([]model.BusPosition, 0, len(d.sortedBuses))
+	for _, bus := range d.sortedBuses {
+		if bus.inService() {
+			positions = append(positions, bus.busPosition())
+		}
+	}
+	d.mutex.Unlock()
+	for _, position := range positions {
+		d.publish(position)
+	}
+	d.AfterTick(request.checkpoint.Time)
+	d.PublishStatus(d.Status())
+	return request.checkpoint.Time.Add(d.step())
+}
+
+func (d *Dispatcher) validate(checkpoint Checkpoint) error {
+	if checkpoint.Version != CheckpointVersion {
+		return fmt.Errorf("checkpoint has version %d, but only version %d is supported", checkpoint.Version, CheckpointVersion)
+	}
+	if len(checkpoint.Buses) != len(d.buses) {
+		return fmt.Errorf("checkpoint contains %d buses, but the scenario contains %d buses", len(checkpoint.Buses), len(d.buses))
+	}
+	for _, snapshot := range checkpoint.Buses {
+		bus, ok := d.buses[snapshot.Id]
+		if !ok {
+			return fmt.Errorf("bus \"%s\" of the checkpoint is not part of the scenario", snapshot.Id)
+		}
+		if snapshot.Assignment < 0 || snapshot.Assignment >= len(bus.assignments) {
+			return fmt.Errorf("bus \"%s\" has no assignment with index %d", snapshot.Id, snapshot.Assignment)
+		}
+		wayPoints := bus.assignments[snapshot.Assignment].WayPoints
+		if snapshot.NextWayPoint < 0 || snapshot.NextWayPoint >= len(wayPoints) {
+			return fmt.Errorf("assignment %d of bus \"%s\" has no way point with index %d", snapshot.Assignment, snapshot.Id, snapshot.NextWayPoint)
+		}
+		if len(snapshot.SegmentSpeeds) > 0 && len(snapshot.SegmentSpeeds) != len(snapshot.Route) {
+			return fmt.Errorf("bus \"%s\" has %d segment speeds for %d coordinates of its route", snapshot.Id, len(snapshot.SegmentSpeeds), len(snapshot.Route))
+		}
+		if snapshot.Pace < 0 || snapshot.Incident < 0 || snapshot.Blocked < 0 {
+			return fmt.Errorf("bus \"%s\" has a negative pace, incident, or blocking time", snapshot.Id)
+		}
+		if snapshot.AtStop && wayPoints[snapshot.NextWayPoint].Id == nil {
+			return fmt.Errorf("way point %d of assignment %d of bus \"%s\" is not a stop", snapshot.NextWayPoint, snapshot.Assignment, snapshot.Id)
+		}
+	}
+	return nil
+}
+
+// apply sets the state of the dispatcher and all buses to the checkpoint. The checkpoint must be validated before.
+// It must be called while holding the mutex.
+func (d *Dispatcher) apply(checkpoint Checkpoint) {
+	d.now = checkpoint.Time
+	for _, snapshot := range checkpoint.Buses {
+		d.buses[snapshot.Id].restore(snapshot)
+	}
+}
+
+func (b *bus) snapshot() BusSnapshot {
+	result := BusSnapshot{
+		Id:           b.id,
+		Assignment:   b.currentAssignment,
+		Active:       b.active,
+		Finished:     b.finished,
+		NextWayPoint: b.nextWayPoint,
+		AtStop:       b.currentStop != nil,
+		Ready:        b.ready,
+		Boarding:     b.boarding,
+		Alighting:    b.alighting,
+		Occupancy:    b.occupancy,
+		Position:     [2]float64{b.position.Lat(), b.position.Lon()},
+		Route:        make([][2]float64, 0, len(b.route)),
+		Delay:        int64(b.delay / time.Millisecond),
+		Time:         b.time,
+		Heading:      b.heading,
+		Speed:        b.speed,
+		Mileage:      b.mileage,
+		TripStart:    b.tripStart,
+		Pace:         b.pace,
+		Incident:     int64(b.incident / time.Millisecond),
+		IncidentAt:   b.incidentAt,
+		Blocked:      b.blocked,
+	}
+	speeds := make([]float64, 0, len(b.route))
+	known := false
+	for _, coordinate := range b.route {
+		result.Route = append(result.Route, [2]float64{coordinate.Lat(), coordinate.Lon()})
+		speed := 0.0
+		if annotated, ok := coordinate.(model.SegmentSpeedAnnotated); ok {
+			speed = annotated.SegmentSpeed()
+		}
+		known = known || speed > 0
+		speeds = append(speeds, speed)
+	}
+	if known {
+		result.SegmentSpeeds = speeds
+	}
+	return result
+}
+
+func (b *bus) restore(snapshot BusSnapshot) {
+	b.currentAssignment = snapshot.Assignment
+	b.active = snapshot.Active
+	b.finished = snapshot.Finished
+	b.nextWayPoint = snapshot.NextWayPoint
+	b.currentStop = nil
+	if snapshot.AtStop {
+		b.currentStop = &b.getCurrentAssignment().WayPoints[snapshot.NextWayPoint]
+	}
+	b.ready = snapshot.Ready
+	b.boarding = snapshot.Boarding
+	b.alighting = snapshot.Alighting
+	b.occupancy = snapshot.Occupancy
+	b.position = &coordinate{lat: snapshot.Position[0], lon: snapshot.Position[1]}
+	b.route = make([]model.Coordinate, 0, len(snapshot.Route))
+	for index, point := range snapshot.Route {
+		restored := &coordinate{lat: point[0], lon: point[1]}
+		if index < len(snapshot.SegmentSpeeds) {
+			restored.speed = snapshot.SegmentSpeeds[index]
+		}
+		b.route = append(b.route, restored)
+	}
+	b.delay = time.Duration(snapshot.Delay) * time.Millisecond
+	b.time = snapshot.Time
+	b.heading = snapshot.Heading
+	b.speed = snapshot.Speed
+	b.mileage = snapshot.Mileage
+	b.tripStart = snapshot.TripStart
+	b.pace = snapshot.Pace
+	if b.pace <= 0 {
+		b.pace = 1
+	}
+	b.incident = time.Duration(snapshot.Incident) * time.Millisecond
+	b.incidentAt = snapshot.IncidentAt
+	b.blocked = snapshot.Blocked
+	b.events = nil
+}
@@ -0,0 +1,155 @@
+package bus
+
+import (
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"time"
+)
+
+// SimulationState describes whether the simulation clock of a Dispatcher is running.
+type SimulationState string
+
+const (
+	// Pending means that the simulation has not been started yet.
+	Pending SimulationState = "pending"
+	// Running means that the simulation clock advances.
+	Running SimulationState = "running"
+	// Paused means that the simulation clock is stopped until the simulation is resumed.
+	Paused SimulationState = "paused"
+	// Finished means that all buses have served all their assignments.
+	Finished SimulationState = "finished"
+)
+
+// Status describes the simulation clock of a Dispatcher. It is meant to be sent to
+// subscribers; the Type field allows them to distinguish it from other messages.
+type Status struct {
+	Type  string          `json:"type"`
+	Time  model.Time      `json:"time"`
+	State SimulationState `json:"state"`
+	Warp  float64         `json:"warp"`
+}
+
+type jump struct {
+	target model.Time
+	done   chan error
+}
+
+// Status returns the current status of the simulation clock.
+func (d *Dispatcher) Status() Status {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return Status{Type: "simulation", Time: d.now, State: d.state, Warp: d.warp}
+}
+
+// Pause stops the simulation clock until Resume is called. Pausing a simulation that is
+// not running results in an error.
+func (d *Dispatcher) Pause() error {
+	return d.changeState(Running, Paused)
+}
+
+// Resume continues a paused simulation. Resuming a simulation that is not paused results in an error.
+func (d *Dispatcher) Resume() error {
+	return d.changeState(Paused, Running)
+}
+
+func (d *Dispatcher) changeState(from SimulationState, to SimulationState) error {
+	d.mutex.Lock()
+	if d.state != from {
+		state := d.state
+		d.mutex.Unlock()
+		return fmt.Errorf("the simulation is %s, but must be %s", state, from)
+	}
+	d.state = to
+	d.mutex.Unlock()
+	d.PublishStatus(d.Status())
+	return nil
+}
+
+// SetWarp changes the relation between simulation time and real time. warp=1 is real time, warp=2 lets time pass twice as fast.
+// The change takes effect with the next tick of the simulation clock.
+func (d *Dispatcher) SetWarp(warp float64) error {
+	if warp <= 0 {
+		return fmt.Errorf("warp must be positive, but was %v", warp)
+	}
+	d.mutex.Lock()
+	if d.state == Pending || d.state == Finished {
+		d.mutex.Unlock()
+		return fmt.Errorf("the simulation is %s", d.state)
+	}
+	d.warp = warp
+	d.mutex.Unlock()
+	d.PublishStatus(d.Status())
+	return nil
+}
+
+// JumpTo fast-forwards the simulation to the given time. All ticks between the current time and
+// the target are simulated as fast as possible, i.e. the buses behave exactly as if the time had passed normally.
+// JumpTo blocks until the target is reached. Jumping backwards or jumping in a simulation that is not
+// running or paused results in an error.
+func (d *Dispatcher) JumpTo(target model.Time) error {
+	d.mutex.RLock()
+	state := d.state
+	now := d.now
+	d.mutex.RUnlock()
+	if state == Pending || state == Finished {
+		return fmt.Errorf("the simulation is %s", state)
+	}
+	if target.Before(now) {
+		return fmt.Errorf("cannot jump back from %v to %v", now, target)
+	}
+	if target == now {
+		return nil
+	}
+	request := jump{target: target, done: make(chan error, 1)}
+	select {
+	case d.jumps <- request:
+		return <-request.done
+	case <-d.stopped:
+		return fmt.Errorf("the simulation is %s", Finished)
+	}
+}
+
+// Stop ends a running or paused simulation although not all buses have finished their assignments. Run and
+// RunHeadless return after the current tick; the state of the buses is kept and can still be queried, e.g. with Checkpoint.
+// Stopping a simulation that has already finished has no effect.
+func (d *Dispatcher) Stop() {
+	d.halted.Do(func() { close(d.halt) })
+}
+
+// jump simulates all ticks from next up to the target of the request, the last tick being exactly at the target.
+// It returns the time of the next regular tick and whether all buses have finished.
+func (d *Dispatcher) jump(next model.Time, request jump) (model.Time, bool) {
+	defer close(request.done)
+	step := d.step()
+	for next.Before(request.target) {
+		if d.tick(next) {
+			return next, true
+		}
+		next = next.Add(step)
+	}
+	if d.tick(request.target) {
+		return next, true
+	}
+	d.PublishStatus(d.Status())
+	return request.target.Add(step), false
+}
+
+// step returns the simulation time that passes with every tick.
+func (d *Dispatcher) step() time.Duration {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return time.Duration(float64(d.interval()) * d.warp)
+}
+
+// interval returns the real time between two ticks.
+func (d *Dispatcher) interval() time.Duration {
+	return time.Duration(float64(time.Second) / d.Frequency)
+}
+
+func (d *Dispatcher) finish() {
+	d.mutex.Lock()
+	d.state = Finished
+	d.mutex.Unlock()
+	close(d.stopped)
+	d.PublishStatus(d.Status())
+}
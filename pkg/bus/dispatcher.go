@@ -3,50 +3,256 @@ package bus
 import (
 	"fmt"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"sort"
 	"sync"
+	"time"
 )
 
-// Dispatcher orchestrates all bus movements in the system. A Dispatcher should always
-// be created with NewDispatcher.
+// State is a snapshot of the current state of a bus.
+type State struct {
+	Id       model.BusId
+	Position [2]float64
+	// Assignment is the assignment the bus is currently serving. It is nil if the
+	// bus waits for the departure of its next assignment or has finished all assignments.
+	Assignment *model.Assignment
+	// NextWayPoint is the index of the way point of the assignment the bus is heading to or waiting at.
+	NextWayPoint int
+	// Stop is the stop the bus is currently waiting at, or nil if the bus is driving.
+	Stop *model.WayPoint
+	// Delay is the deviation of the bus from the timetable at the last stop it reached or left. It is negative
+	// if the bus arrived early.
+	Delay time.Duration
+	// Time is the simulation time of the last update of the bus.
+	Time model.Time
+	// InService is false if the bus has not started its first assignment yet or has finished all its assignments.
+	InService bool
+}
+
+// Dispatcher orchestrates all bus movements in the system. The dispatcher owns the simulation clock
+// and advances all buses in lockstep on every tick of the clock. Thus, two runs with the same inputs produce the
+// same positions in the same order. A Dispatcher should always be created with NewDispatcher.
 type Dispatcher struct {
-	busModel    model.BusModel
-	buses       map[model.BusId]*bus
-	gps         model.RouteService
-	publish     model.Publisher
-	Frequency   float64
-	Warp        float64
+	mutex         sync.RWMutex
+	buses         map[model.BusId]*bus
+	sortedBuses   []*bus
+	now           model.Time
+	state         SimulationState
+	warp          float64
+	jumps         chan jump
+	restores      chan restore
+	restored      bool
+	halt          chan struct{}
+	halted        sync.Once
+	stopped       chan struct{}
+	gps           model.RouteService
+	publish       model.Publisher
+	PublishEvent  model.EventPublisher
+	PublishStatus func(Status)
+	AfterTick     func(model.Time)
+	Frequency     float64
+	Warp          float64
+	// BusSpeedKmh is the maximal speed of the buses. On road segments with lower average speeds, the buses drive slower.
 	BusSpeedKmh int
+	// Acceleration of the buses in m/s². If it is not positive, the buses reach their speed instantly.
+	Acceleration float64
+	// Deceleration of the buses in m/s². If it is not positive, the buses stop instantly.
+	Deceleration float64
+	// Dwell determines how long the buses need for the passenger exchange at the stops. A bus leaves a stop at
+	// the scheduled departure, but not before the passenger exchange is finished.
+	Dwell DwellModel
+	// Passengers determines the passengers boarding and alighting at the stops. If it is nil, there are no passengers.
+	Passengers PassengerCounter
+	// Disturbances perturb the travel and dwell times of the buses randomly. If it is nil, the buses are not disturbed.
+	Disturbances *model.Disturbances
+	// DemandSeed is the seed of the passenger demand sampled by Passengers. It is nil if no demand is sampled.
+	DemandSeed *int64
 }
 
 // NewDispatcher creates a dispatcher with the given parameters.
 func NewDispatcher(mdl model.BusModel, publisher model.Publisher, routeService model.RouteService) *Dispatcher {
-	return &Dispatcher{busModel: mdl, publish: publisher, gps: routeService, Frequency: 2, Warp: 1, BusSpeedKmh: 40, buses: make(map[model.BusId]*bus)}
+	dispatcher := &Dispatcher{
+		publish:       publisher,
+		PublishEvent:  func(model.Event) {},
+		PublishStatus: func(Status) {},
+		AfterTick:     func(model.Time) {},
+		gps:           routeService,
+		Frequency:     2,
+		Warp:          1,
+		BusSpeedKmh:   40,
+		Passengers:    StopDemand{},
+		buses:         make(map[model.BusId]*bus),
+		state:         Pending,
+		jumps:         make(chan jump),
+		restores:      make(chan restore),
+		halt:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	for _, modelBus := range mdl.Buses() {
+		if len(modelBus.Assignments) == 0 {
+			continue
+		}
+		bus := bus{id: modelBus.Id, assignments: modelBus.Assignments, dispatcher: dispatcher, position: modelBus.Assignments[0].WayPoints[0], doors: modelBus.Doors, pace: 1}
+		dispatcher.buses[bus.id] = &bus
+		dispatcher.sortedBuses = append(dispatcher.sortedBuses, &bus)
+	}
+	sort.Slice(dispatcher.sortedBuses, func(i, j int) bool {
+		return dispatcher.sortedBuses[i].id < dispatcher.sortedBuses[j].id
+	})
+	return dispatcher
 }
 
-// Run starts all busses the dispatcher is aware of. This method blocks until all buses have finished all their assignments.
+// Run starts the simulation clock at the given start time. On every tick, the clock advances by
+// the interval defined by Frequency multiplied with the warp. The simulation can be controlled while
+// it is running, see Pause, Resume, SetWarp, JumpTo, Restore, and Stop. This method blocks until all buses have finished
+// all their assignments or the simulation is stopped.
 func (d *Dispatcher) Run(start model.Time) {
-	var wg sync.WaitGroup
-	for _, modelBus := range d.busModel.Buses() {
-		bus := bus{id: modelBus.Id, assignments: modelBus.Assignments, gps: d.gps, dispatcher: d, position: modelBus.Assignments[0].WayPoints[0], speed: d.BusSpeedKmh}
-		timer := model.NewTicker(start, d.Frequency, d.Warp)
-		wg.Add(1)
-		d.buses[bus.id] = &bus
-		go func() {
-			defer wg.Done()
-			defer timer.Stop()
-			bus.start(timer)
-		}()
+	next := d.start(start)
+	defer d.finish()
+	ticker := time.NewTicker(d.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case request := <-d.jumps:
+			var finished bool
+			next, finished = d.jump(next, request)
+			if finished {
+				return
+			}
+		case request := <-d.restores:
+			next = d.restore(request)
+		case <-d.halt:
+			return
+		case <-ticker.C:
+			if d.Status().State == Paused {
+				continue
+			}
+			finished := d.tick(next)
+			d.PublishStatus(d.Status())
+			if finished {
+				return
+			}
+			next = next.Add(d.step())
+		}
+	}
+}
+
+// RunHeadless runs the simulation on a virtual clock as fast as possible, i.e. without waiting
+// between two ticks. The simulation time passing with every tick is the same as in Run, thus both
+// methods produce the same positions and events. This method blocks until all buses have finished all their assignments
+// or the simulation is stopped.
+func (d *Dispatcher) RunHeadless(start model.Time) {
+	next := d.start(start)
+	defer d.finish()
+	step := d.step()
+	for ; !d.tick(next); next = next.Add(step) {
+		select {
+		case <-d.halt:
+			return
+		default:
+		}
+	}
+}
+
+// start starts the simulation clock and returns the time of the first tick. If a checkpoint has been restored,
+// the clock starts at the time of the checkpoint instead of the given time. Since the tick at the time of
+// the checkpoint has already been simulated, the first tick is one step later.
+// Before, the routes of all buses are prefetched without holding the mutex, since the route service may be slow.
+// If the run is randomized by disturbances or a sampled demand, an event announces the seeds first.
+func (d *Dispatcher) start(start model.Time) model.Time {
+	for _, bus := range d.sortedBuses {
+		bus.prefetchRoutes(d.gps)
+	}
+	d.mutex.Lock()
+	restored := d.restored
+	if restored {
+		start = d.now
+	} else {
+		d.now = start
+	}
+	d.warp = d.Warp
+	d.state = Running
+	d.mutex.Unlock()
+	d.PublishStatus(d.Status())
+	if d.Disturbances != nil || d.DemandSeed != nil {
+		event := model.Event{Type: model.RunStarted, Time: start, DemandSeed: d.DemandSeed}
+		if d.Disturbances != nil {
+			event.Seed = d.Disturbances.Seed
+		}
+		d.PublishEvent(event)
+	}
+	if restored {
+		return start.Add(d.step())
 	}
-	wg.Wait()
+	return start
+}
+
+// tick advances all buses to the given time and publishes their positions and events afterwards. Finally, AfterTick
+// is called, which allows publishers to handle all updates of a tick at once. The buses are advanced in the order
+// of their ids. The method returns true if all buses have finished their assignments.
+func (d *Dispatcher) tick(now model.Time) bool {
+	d.mutex.Lock()
+	d.now = now
+	positions := make([]model.BusPosition, 0, len(d.sortedBuses))
+	var events []model.Event
+	finished := true
+	for _, bus := range d.sortedBuses {
+		positions = append(positions, bus.tick(now)...)
+		events = append(events, bus.events...)
+		bus.events = nil
+		finished = finished && bus.finished
+	}
+	d.mutex.Unlock()
+	for _, position := range positions {
+		d.publish(position)
+	}
+	for _, event := range events {
+		d.PublishEvent(event)
+	}
+	d.AfterTick(now)
+	return finished
+}
+
+// Now returns the current time of the simulation clock.
+func (d *Dispatcher) Now() model.Time {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.now
 }
 
 func (d *Dispatcher) positionStatement(bus *bus, current model.Coordinate) {
 	d.publish(model.BusPosition{BusId: bus.id, Location: [2]float64{current.Lat(), current.Lon()}})
 }
 
+// QueryBusStates returns the current states of all buses, sorted by their ids.
+func (d *Dispatcher) QueryBusStates() []State {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	result := make([]State, 0, len(d.sortedBuses))
+	for _, bus := range d.sortedBuses {
+		result = append(result, bus.getState())
+	}
+	return result
+}
+
+// QueryBusPositions returns the current positions of all buses in service, sorted by their ids. Buses that have not
+// started their first assignment yet or that have finished all their assignments are omitted.
+func (d *Dispatcher) QueryBusPositions() []model.BusPosition {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	result := make([]model.BusPosition, 0, len(d.sortedBuses))
+	for _, bus := range d.sortedBuses {
+		if bus.inService() {
+			result = append(result, bus.busPosition())
+		}
+	}
+	return result
+}
+
 // QueryCurrentAssignment gets the current assignment with the bus with the given id. If the bus with the
 // id does not exist, this method will panic. Callers of this method should know which buses the dispatcher contains.
 func (d *Dispatcher) QueryCurrentAssignment(id model.BusId) *model.Assignment {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
 	bus, ok := d.buses[id]
 	if !ok {
 		panic(fmt.Sprintf("bus with busId \"%s\"not found", id))
@@ -0,0 +1,81 @@
+package bus
+
+import (
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// minPace is the lowest factor by which the travel time noise may shorten the travel time between two way points.
+const minPace = 0.5
+
+// disturb draws the disturbances of the route to the next way point: the pace, which stretches or shortens the
+// travel time, and possibly an incident somewhere on the route. Only the way between the way points of an assignment is
+// disturbed, the approach to the first way point is not.
+func (b *bus) disturb() {
+	b.pace = 1
+	b.incident = 0
+	disturbances := b.dispatcher.Disturbances
+	if disturbances == nil || b.nextWayPoint == 0 || len(b.route) == 0 {
+		return
+	}
+	random := b.random("route")
+	b.pace = math.Max(minPace, 1+disturbances.TravelTimeNoise*random.NormFloat64())
+	if random.Float64() >= disturbances.IncidentProbability {
+		return
+	}
+	b.incidentAt = b.mileage + random.Float64()*routeLength(b.position, b.route)
+	spread := disturbances.IncidentMaxDuration - disturbances.IncidentMinDuration
+	b.incident = disturbances.IncidentMinDuration + time.Duration(random.Float64()*float64(spread))
+}
+
+// dwellExtension draws the random extension of the dwell time at the current stop.
+func (b *bus) dwellExtension() time.Duration {
+	disturbances := b.dispatcher.Disturbances
+	if disturbances == nil {
+		return 0
+	}
+	random := b.random("dwell")
+	if random.Float64() >= disturbances.DwellExtensionProbability {
+		return 0
+	}
+	return time.Duration(random.ExpFloat64() * float64(disturbances.DwellExtensionMean))
+}
+
+// checkIncident blocks the bus if it has reached the location of the incident on its route. If the bus has
+// reached the end of the route meanwhile, the incident is left out.
+func (b *bus) checkIncident() {
+	if b.incident <= 0 || b.mileage < b.incidentAt || len(b.route) == 0 {
+		return
+	}
+	b.blocked = b.incident.Seconds()
+	b.incident = 0
+	b.speed = 0
+	b.events = append(b.events, b.event(model.IncidentStarted))
+}
+
+// random returns a random generator for the current way point of the bus. The generator is seeded with the seed of the
+// disturbances, the bus, the way point, and the purpose of the numbers. Thus, the disturbances do not depend on
+// the frequency of the simulation or on the order of the buses, and they are the same after restoring a checkpoint.
+func (b *bus) random(purpose string) *rand.Rand {
+	var seed int64
+	if b.dispatcher.Disturbances.Seed != nil {
+		seed = *b.dispatcher.Disturbances.Seed
+	}
+	hash := fnv.New64a()
+	_, _ = fmt.Fprintf(hash, "%d/%s/%d/%d/%s", seed, b.id, b.currentAssignment, b.nextWayPoint, purpose)
+	return rand.New(rand.NewSource(int64(hash.Sum64())))
+}
+
+func routeLength(start model.Coordinate, route []model.Coordinate) float64 {
+	result := 0.0
+	previous := start
+	for _, coordinate := range route {
+		result = result + distanceTo(previous, coordinate)
+		previous = coordinate
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package bus
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"math"
+	"time"
+)
+
+// PassengerCounter determines the passengers boarding and alighting whenever a bus serves a stop.
+type PassengerCounter interface {
+	// Exchange is called when the bus arrives at the stop and returns the number of passengers boarding and
+	// alighting there. The line is empty if the bus does not serve a line. Exchange is called while the dispatcher
+	// advances the buses, thus it must not call the dispatcher.
+	Exchange(bus model.BusId, line model.LineId, stop model.WayPoint, now model.Time) (boarding int, alighting int)
+	// Board is called when the bus is about to depart from the stop and returns the number of passengers who
+	// reached the stop after the arrival of the bus and board in the last moment. The bus departs only if
+	// nobody boards anymore.
+	Board(bus model.BusId, line model.LineId, stop model.WayPoint, now model.Time) int
+	// Finish is called instead of Exchange when the bus arrives at the last stop of its assignment. All passengers
+	// alight there and nobody boards; Finish returns the number of alighting passengers.
+	Finish(bus model.BusId, stop model.WayPoint, now model.Time) int
+}
+
+// StopDemand is a PassengerCounter that takes the static demand of the stops from the scenario, i.e. the same number of
+// passengers boards and alights at every visit of a stop.
+type StopDemand struct{}
+
+// Exchange returns the boarding and alighting passengers of the stop.
+func (s StopDemand) Exchange(_ model.BusId, _ model.LineId, stop model.WayPoint, _ model.Time) (int, int) {
+	return stop.Boarding, stop.Alighting
+}
+
+// Board returns 0 because the static demand of the stop is completely handled on arrival.
+func (s StopDemand) Board(model.BusId, model.LineId, model.WayPoint, model.Time) int {
+	return 0
+}
+
+// Finish returns the alighting passengers of the stop.
+func (s StopDemand) Finish(_ model.BusId, stop model.WayPoint, _ model.Time) int {
+	return stop.Alighting
+}
+
+// DwellModel describes how long a bus needs at a stop to let the passengers board and alight. The passengers are
+// distributed evenly over the doors of the bus; boarding and alighting happen at the same time, thus the slower
+// of both passenger flows determines the dwell time. The zero value means that the buses need no time at all.
+type DwellModel struct {
+	// Overhead is the time for opening and closing the doors. It is only needed if passengers board or alight.
+	Overhead time.Duration
+	// BoardingTime is the time a single passenger needs to board through one door.
+	BoardingTime time.Duration
+	// AlightingTime is the time a single passenger needs to alight through one door.
+	AlightingTime time.Duration
+	// Doors is the number of doors of buses without door configuration in the scenario. Values below 1 mean one door.
+	Doors int
+}
+
+// DwellTime computes how long a bus with the given number of doors needs to let the passengers board and alight.
+// If doors is not positive, the default number of doors of the model is used.
+func (m DwellModel) DwellTime(boarding int, alighting int, doors int) time.Duration {
+	if boarding <= 0 && alighting <= 0 {
+		return 0
+	}
+	if doors <= 0 {
+		doors = m.Doors
+	}
+	if doors <= 0 {
+		doors = 1
+	}
+	perDoor := func(passengers int) int {
+		return int(math.Ceil(float64(passengers) / float64(doors)))
+	}
+	boardingTime := time.Duration(perDoor(boarding)) * m.BoardingTime
+	alightingTime := time.Duration(perDoor(alighting)) * m.AlightingTime
+	if boardingTime > alightingTime {
+		return m.Overhead + boardingTime
+	}
+	return m.Overhead + alightingTime
+}
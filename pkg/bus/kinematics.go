@@ -0,0 +1,109 @@
+package bus
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"math"
+)
+
+// maxStep is the maximal time step (in seconds) of the integration of the motion of a bus. Long ticks
+// are split into several steps, so that the bus notices slower segments and stops in time.
+const maxStep = 1.0
+
+// advance moves the bus along its route for the given number of seconds. The speed of the bus follows the
+// segment speeds of the route, limited by the speed of the dispatcher. The bus accelerates and decelerates
+// according to the settings of the dispatcher; it brakes in time for slower segments ahead and comes to a halt at
+// the end of the route if a stop is there. The method returns as soon as the end of the route is reached.
+// The pace of the bus stretches the time it needs for the route, and an incident blocks the bus for a while.
+func (b *bus) advance(seconds float64) {
+	for seconds > 0 && len(b.route) > 0 {
+		if b.blocked > 0 {
+			wait := math.Min(seconds, b.blocked)
+			seconds = seconds - wait
+			b.blocked = b.blocked - wait
+			if b.blocked <= 0 {
+				b.events = append(b.events, b.event(model.IncidentFinished))
+			}
+			continue
+		}
+		step := math.Min(seconds, maxStep)
+		seconds = seconds - step
+		moving := step / b.pace
+		b.route = b.drive(b.route, b.accelerate(b.targetSpeed(moving), moving))
+		b.checkIncident()
+	}
+}
+
+// accelerate changes the speed of the bus towards the target speed for the given number of seconds and returns the
+// distance driven meanwhile. If the bus is faster than the target speed, it slows down to the target
+// speed; targetSpeed ensures that this matches the deceleration of the bus.
+func (b *bus) accelerate(target float64, seconds float64) float64 {
+	start := b.speed
+	acceleration := b.dispatcher.Acceleration
+	if target > start && acceleration > 0 {
+		b.speed = math.Min(target, start+acceleration*seconds)
+	} else {
+		b.speed = target
+	}
+	if (b.speed > start && acceleration <= 0) || (b.speed < start && b.dispatcher.Deceleration <= 0) {
+		// the speed changes instantly
+		return b.speed * seconds
+	}
+	return (start + b.speed) / 2 * seconds
+}
+
+// targetSpeed computes the highest speed (in m/s) that the bus may reach at the end of the next step. This is the
+// speed of the current segment of the route, unless the bus needs to slow down for a slower
+// segment ahead or for the stop at the end of the route.
+func (b *bus) targetSpeed(seconds float64) float64 {
+	cruise := float64(b.dispatcher.BusSpeedKmh) / 3.6
+	result := segmentSpeed(b.route[0], cruise)
+	deceleration := b.dispatcher.Deceleration
+	if deceleration <= 0 {
+		return result
+	}
+	horizon := cruise*seconds + cruise*cruise/(2*deceleration)
+	distance := distanceTo(b.position, b.route[0])
+	for index := 1; index < len(b.route); index++ {
+		if distance > horizon {
+			return result
+		}
+		limit := segmentSpeed(b.route[index], cruise)
+		result = math.Min(result, b.brakingSpeed(limit, distance, seconds))
+		distance = distance + distanceTo(b.route[index-1], b.route[index])
+	}
+	if b.stopsAtEndOfRoute() {
+		result = math.Min(result, b.brakingSpeed(0, distance, seconds))
+	}
+	return result
+}
+
+// brakingSpeed computes the highest speed (in m/s) that the bus may reach at the end of the next step, so that
+// it can still brake down to the limit within the given distance. During the step, the speed changes linearly,
+// thus the bus drives (v+v')/2*t meters, after which v'² <= limit² + 2*deceleration*(distance - (v+v')/2*t) must hold.
+// If the bus is already too fast to fulfill this, it slows down to the limit immediately.
+func (b *bus) brakingSpeed(limit float64, distance float64, seconds float64) float64 {
+	deceleration := b.dispatcher.Deceleration
+	p := deceleration * seconds
+	q := p*b.speed - limit*limit - 2*deceleration*distance
+	discriminant := p*p - 4*q
+	if discriminant < 0 {
+		return limit
+	}
+	return math.Max(0, (-p+math.Sqrt(discriminant))/2)
+}
+
+// stopsAtEndOfRoute returns true if the route of the bus leads to a stop or to the last way point of the assignment.
+// Way points that are no stops are passed without braking.
+func (b *bus) stopsAtEndOfRoute() bool {
+	wayPoints := b.getCurrentAssignment().WayPoints
+	return wayPoints[b.nextWayPoint].Id != nil || b.nextWayPoint == len(wayPoints)-1
+}
+
+// segmentSpeed returns the speed (in m/s) on the segment leading to the coordinate, but not more than the given maximum.
+func segmentSpeed(c model.Coordinate, max float64) float64 {
+	annotated, ok := c.(model.SegmentSpeedAnnotated)
+	if !ok || annotated.SegmentSpeed() <= 0 {
+		return max
+	}
+	return math.Min(max, annotated.SegmentSpeed()/3.6)
+}
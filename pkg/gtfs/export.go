@@ -0,0 +1,231 @@
+// Package gtfs provides conversions between OTS and the General Transit Feed Specification (GTFS).
+package gtfs
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const serviceId = "always"
+
+// Exporter writes a scenario as GTFS static feed. New exporters should be created with NewExporter.
+type Exporter struct {
+	model      model.Model
+	gps        model.RouteService
+	AgencyName string
+	AgencyUrl  string
+	// Timezone is the time zone of the agency as name of the IANA time zone database, e.g. Europe/Berlin. The times of
+	// the feed are given in this time zone.
+	Timezone string
+}
+
+// NewExporter creates an exporter for the given model. The route service is used to compute the shapes of lines
+// whose shapes have not been resolved (see model.ResolveShapes).
+func NewExporter(mdl model.Model, routeService model.RouteService) *Exporter {
+	return &Exporter{model: mdl, gps: routeService, AgencyName: "Open Traffic Sandbox", AgencyUrl: "https://fafeitsch.github.io/Open-Traffic-Sandbox", Timezone: "Europe/Berlin"}
+}
+
+// Export writes the feed as zip archive to the writer. Every line becomes a route with one trip per tour. Assignments
+// of buses become blocks. If several buses serve the same tour, the tour becomes one trip per bus; the trips of all
+// buses but the first (ordered by their ids) get the bus id as suffix. Assignments with custom waypoints are not part
+// of the feed because they do not serve stops. As a scenario does not know dates, the feed contains a single service
+// which is valid on all days.
+func (e *Exporter) Export(writer io.Writer) error {
+	if _, err := time.LoadLocation(e.Timezone); err != nil || e.Timezone == "" {
+		return fmt.Errorf("unknown time zone \"%s\"", e.Timezone)
+	}
+	archive := zip.NewWriter(writer)
+	lines := e.model.Lines()
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].DefinitionIndex < lines[j].DefinitionIndex
+	})
+	trips := e.collectTrips(lines)
+	files := []struct {
+		name    string
+		records func() ([][]string, error)
+	}{
+		{name: "agency.txt", records: e.agency},
+		{name: "calendar.txt", records: e.calendar},
+		{name: "stops.txt", records: e.stops},
+		{name: "routes.txt", records: func() ([][]string, error) { return e.routes(lines) }},
+		{name: "trips.txt", records: func() ([][]string, error) { return e.trips(trips) }},
+		{name: "stop_times.txt", records: func() ([][]string, error) { return e.stopTimes(trips) }},
+		{name: "shapes.txt", records: func() ([][]string, error) { return e.shapes(lines) }},
+	}
+	for _, file := range files {
+		records, err := file.records()
+		if err != nil {
+			return fmt.Errorf("could not create \"%s\": %v", file.name, err)
+		}
+		entry, err := archive.Create(file.name)
+		if err != nil {
+			return fmt.Errorf("could not create \"%s\": %v", file.name, err)
+		}
+		err = csv.NewWriter(entry).WriteAll(records)
+		if err != nil {
+			return fmt.Errorf("could not write \"%s\": %v", file.name, err)
+		}
+	}
+	err := archive.Close()
+	if err != nil {
+		return fmt.Errorf("could not complete archive: %v", err)
+	}
+	return nil
+}
+
+func (e *Exporter) agency() ([][]string, error) {
+	return [][]string{
+		{"agency_id", "agency_name", "agency_url", "agency_timezone"},
+		{"OTS", e.AgencyName, e.AgencyUrl, e.Timezone},
+	}, nil
+}
+
+func (e *Exporter) calendar() ([][]string, error) {
+	return [][]string{
+		{"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"},
+		{serviceId, "1", "1", "1", "1", "1", "1", "1", "20200101", "20991231"},
+	}, nil
+}
+
+func (e *Exporter) stops() ([][]string, error) {
+	stops := e.model.Stops()
+	sort.Slice(stops, func(i, j int) bool {
+		return *stops[i].Id < *stops[j].Id
+	})
+	result := [][]string{{"stop_id", "stop_name", "stop_lat", "stop_lon"}}
+	for _, stop := range stops {
+		result = append(result, []string{string(*stop.Id), stop.Name, formatFloat(stop.Latitude), formatFloat(stop.Longitude)})
+	}
+	return result, nil
+}
+
+func (e *Exporter) routes(lines []model.Line) ([][]string, error) {
+	result := [][]string{{"route_id", "agency_id", "route_short_name", "route_long_name", "route_type", "route_color"}}
+	for _, line := range lines {
+		// GTFS requires a short or a long name
+		shortName := ""
+		if line.Name == "" {
+			shortName = string(line.Id)
+		}
+		result = append(result, []string{routeId(line.Id), "OTS", shortName, line.Name, "3", strings.TrimPrefix(line.Color, "#")})
+	}
+	return result, nil
+}
+
+// exportedTrip is a trip of the feed, i.e. a tour of a line served by the bus of the block, if any.
+type exportedTrip struct {
+	id    string
+	line  model.Line
+	start model.Time
+	block model.BusId
+}
+
+// collectTrips creates the trips of the lines, one for every bus serving a tour or one without block if no bus
+// serves the tour.
+func (e *Exporter) collectTrips(lines []model.Line) []exportedTrip {
+	buses := e.model.Buses()
+	sort.Slice(buses, func(i, j int) bool {
+		return buses[i].Id < buses[j].Id
+	})
+	blocks := make(map[string][]model.BusId)
+	for _, bus := range buses {
+		for _, assignment := range bus.Assignments {
+			if assignment.Line != nil {
+				id := tripId(assignment.Line.Id, assignment.Departure)
+				blocks[id] = append(blocks[id], bus.Id)
+			}
+		}
+	}
+	result := make([]exportedTrip, 0)
+	for _, line := range lines {
+		for _, start := range line.StartTimes() {
+			id := tripId(line.Id, start)
+			if len(blocks[id]) == 0 {
+				result = append(result, exportedTrip{id: id, line: line, start: start})
+			}
+			for index, block := range blocks[id] {
+				trip := exportedTrip{id: id, line: line, start: start, block: block}
+				if index > 0 {
+					trip.id = fmt.Sprintf("%s_%s", id, block)
+				}
+				result = append(result, trip)
+			}
+		}
+	}
+	return result
+}
+
+func (e *Exporter) trips(trips []exportedTrip) ([][]string, error) {
+	result := [][]string{{"route_id", "service_id", "trip_id", "block_id", "shape_id"}}
+	for _, trip := range trips {
+		result = append(result, []string{routeId(trip.line.Id), serviceId, trip.id, string(trip.block), string(trip.line.Id)})
+	}
+	return result, nil
+}
+
+func (e *Exporter) stopTimes(trips []exportedTrip) ([][]string, error) {
+	result := [][]string{{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence"}}
+	for _, trip := range trips {
+		stops := trip.line.Stops()
+		for index, departure := range trip.line.TourTimes(trip.start) {
+			formatted := formatTime(departure)
+			result = append(result, []string{trip.id, formatted, formatted, string(*stops[index].Id), strconv.Itoa(index + 1)})
+		}
+	}
+	return result, nil
+}
+
+func (e *Exporter) shapes(lines []model.Line) ([][]string, error) {
+	result := [][]string{{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"}}
+	for _, line := range lines {
+		route, err := e.route(line)
+		if err != nil {
+			return nil, fmt.Errorf("could not query route of line \"%s\": %v", line.Id, err)
+		}
+		for index, coordinate := range route {
+			result = append(result, []string{string(line.Id), formatFloat(coordinate.Lat()), formatFloat(coordinate.Lon()), strconv.Itoa(index + 1)})
+		}
+	}
+	return result, nil
+}
+
+// route returns the resolved shape of the line. Only if the shape is not resolved, the route service is queried.
+func (e *Exporter) route(line model.Line) ([]model.Coordinate, error) {
+	if shape := line.Shape(); shape != nil {
+		return shape.Coordinates(), nil
+	}
+	coords := make([]model.Coordinate, 0, len(line.WayPoints()))
+	for _, waypoint := range line.WayPoints() {
+		coords = append(coords, waypoint)
+	}
+	route, _, err := e.gps(coords...)
+	return route, err
+}
+
+// routeId and tripId create the ids of the feed. The GTFS-Realtime feeds use them as well, thus they refer to the
+// routes and trips of the exported feed.
+func routeId(line model.LineId) string {
+	return string(line)
+}
+
+func tripId(line model.LineId, start model.Time) string {
+	return fmt.Sprintf("%s_%s", line, strings.ReplaceAll(formatTime(start), ":", ""))
+}
+
+// formatTime formats the time as hh:mm:ss. In contrast to model.Time.String(), the seconds are included.
+func formatTime(t model.Time) string {
+	hour, minute := t.HourMinute()
+	second := (int(t) / 1000) % 60
+	return fmt.Sprintf("%02d:%02d:%02d", hour, minute, second)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
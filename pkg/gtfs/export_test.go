@@ -0,0 +1,106 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func gps(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+	return coordinates, 250, nil
+}
+
+func export(t *testing.T, path string) map[string][][]string {
+	mdl, err := model.Init(path)
+	require.NoError(t, err)
+	var buffer bytes.Buffer
+	err = NewExporter(mdl, gps).Export(&buffer)
+	require.NoError(t, err)
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	require.NoError(t, err)
+	files := make(map[string][][]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		require.NoError(t, err)
+		records, err := csv.NewReader(reader).ReadAll()
+		require.NoError(t, err)
+		files[file.Name] = records
+	}
+	return files
+}
+
+func TestExporter_Export(t *testing.T) {
+	files := export(t, "../model/testdata/wuerzburg(fictional)")
+	assert.Equal(t, 7, len(files), "number of files")
+	assert.Equal(t, []string{"A-outbound", "OTS", "", "Busbahnhof - Residenz - Sanderau", "3", "801818"}, files["routes.txt"][1], "first route")
+	assert.Equal(t, []string{"A-outbound", serviceId, "A-outbound_061500", "V1", "A-outbound"}, files["trips.txt"][1], "first trip")
+	assert.Equal(t, []string{"A-outbound_061500", "06:18:00", "06:18:00", "node/534317115", "2"}, files["stop_times.txt"][2], "second stop time")
+	assert.Equal(t, []string{"A-outbound", "49.7815846", "9.9356804", "8"}, files["shapes.txt"][8], "some shape point")
+}
+
+func TestExporter_Export_SharedTour(t *testing.T) {
+	files := export(t, "./testdata/shared")
+	assert.Equal(t, []string{"unnamed", "OTS", "unnamed", "", "3", ""}, files["routes.txt"][1], "route of a line without name")
+	assert.Equal(t, [][]string{
+		{"route_id", "service_id", "trip_id", "block_id", "shape_id"},
+		{"unnamed", serviceId, "unnamed_060000", "V1", "unnamed"},
+		{"unnamed", serviceId, "unnamed_060000_V2", "V2", "unnamed"},
+		{"unnamed", serviceId, "unnamed_063000", "V2", "unnamed"},
+	}, files["trips.txt"], "tour served by two buses should become two trips")
+	assert.Equal(t, []string{"unnamed_060000_V2", "06:10:00", "06:10:00", "s2", "2"}, files["stop_times.txt"][4], "stop time of the second trip of the tour")
+	assert.Equal(t, 7, len(files["stop_times.txt"]), "number of stop times")
+}
+
+func TestExporter_Export_Timezone(t *testing.T) {
+	mdl, err := model.Init("../model/testdata/wuerzburg(fictional)")
+	require.NoError(t, err)
+	exporter := NewExporter(mdl, gps)
+	exporter.Timezone = "America/New_York"
+	var buffer bytes.Buffer
+	require.NoError(t, exporter.Export(&buffer))
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	require.NoError(t, err)
+	reader, err := archive.Open("agency.txt")
+	require.NoError(t, err)
+	records, err := csv.NewReader(reader).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "America/New_York", records[1][3], "time zone of the agency")
+
+	exporter.Timezone = "Middle/Earth"
+	assert.EqualError(t, exporter.Export(&bytes.Buffer{}), "unknown time zone \"Middle/Earth\"")
+	exporter.Timezone = ""
+	assert.EqualError(t, exporter.Export(&bytes.Buffer{}), "unknown time zone \"\"")
+}
+
+func TestExporter_Export_RoundTrip(t *testing.T) {
+	mdl, err := model.Init("../model/testdata/gtfs")
+	require.NoError(t, err)
+	directory, err := ioutil.TempDir("", "gtfs")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(directory) }()
+	path := filepath.Join(directory, "feed.zip")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	err = NewExporter(mdl, gps).Export(file)
+	_ = file.Close()
+	require.NoError(t, err)
+
+	imported, err := model.Init(path)
+	require.NoError(t, err, "exported feed should be importable")
+	assert.Equal(t, len(mdl.Lines()), len(imported.Lines()), "number of lines after round trip")
+	original, _ := mdl.Line("A-1")
+	reimported, _ := imported.Line("A-1")
+	assert.Equal(t, original.StartTimes(), reimported.StartTimes(), "start times after round trip")
+	assert.Equal(t, original.TourTimes(model.MustParseTime("6:15")), reimported.TourTimes(model.MustParseTime("6:15")), "tour after round trip")
+	bus, ok := imported.Bus("B1")
+	require.True(t, ok, "block should be imported as bus")
+	assert.Equal(t, 2, len(bus.Assignments), "number of assignments of the block")
+}
@@ -0,0 +1,173 @@
+package gtfs
+
+import (
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/internal/wire"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"time"
+)
+
+// The GTFS-Realtime messages are encoded by hand with the internal wire package because the feed consists only of a
+// few simple messages. Field numbers and enum values are taken from gtfs-realtime.proto
+// (https://gtfs.org/reference/realtime/v2/).
+const (
+	stoppedAt   = 1
+	inTransitTo = 2
+)
+
+// VehiclePositions encodes the states of the buses as GTFS-Realtime FeedMessage containing VehiclePosition entities.
+// Only buses in service are part of the feed. The trips refer to the trips of the feed written by Exporter. The day is
+// the service day of the simulation (see ServiceDay); it is used as start date of the trips and to convert the
+// simulation times into POSIX timestamps.
+func VehiclePositions(states []bus.State, day time.Time) []byte {
+	entities := make([][]byte, 0, len(states))
+	for _, state := range states {
+		if !state.InService {
+			continue
+		}
+		var vehicle []byte
+		if trip := tripDescriptor(state, day); trip != nil {
+			vehicle = wire.AppendMessage(vehicle, 1, trip)
+		}
+		var position []byte
+		position = wire.AppendFloat(position, 1, state.Position[0])
+		position = wire.AppendFloat(position, 2, state.Position[1])
+		vehicle = wire.AppendMessage(vehicle, 2, position)
+		if state.Assignment != nil && state.Assignment.Line != nil {
+			sequence, stop := currentStop(state)
+			if stop != nil {
+				vehicle = wire.AppendVarint(vehicle, 3, uint64(sequence))
+				status := inTransitTo
+				if state.Stop != nil {
+					status = stoppedAt
+				}
+				vehicle = wire.AppendVarint(vehicle, 4, uint64(status))
+				vehicle = wire.AppendString(vehicle, 7, string(*stop.Id))
+			}
+		}
+		vehicle = wire.AppendVarint(vehicle, 5, uint64(posix(state.Time, day)))
+		vehicle = wire.AppendMessage(vehicle, 8, vehicleDescriptor(state))
+		var entity []byte
+		entity = wire.AppendString(entity, 1, string(state.Id))
+		entity = wire.AppendMessage(entity, 4, vehicle)
+		entities = append(entities, entity)
+	}
+	return feedMessage(states, day, entities)
+}
+
+// TripUpdates encodes the states of the buses as GTFS-Realtime FeedMessage containing TripUpdate entities.
+// Only buses serving a line have a trip. The current delay of a bus is propagated to all remaining stops of the trip;
+// buses ahead of schedule wait at the stops, thus they are reported as being on time.
+// The trips and the day are handled like in VehiclePositions.
+func TripUpdates(states []bus.State, day time.Time) []byte {
+	entities := make([][]byte, 0, len(states))
+	for _, state := range states {
+		trip := tripDescriptor(state, day)
+		if trip == nil {
+			continue
+		}
+		var update []byte
+		update = wire.AppendMessage(update, 1, trip)
+		delay := int64(0)
+		if state.Delay > 0 {
+			delay = int64(state.Delay / time.Second)
+		}
+		sequence, _ := currentStop(state)
+		for _, waypoint := range state.Assignment.WayPoints[state.NextWayPoint:] {
+			if waypoint.Id == nil {
+				continue
+			}
+			var event []byte
+			event = wire.AppendVarint(event, 1, uint64(delay))
+			event = wire.AppendVarint(event, 2, uint64(posix(waypoint.Departure, day)+delay))
+			var stopTimeUpdate []byte
+			stopTimeUpdate = wire.AppendVarint(stopTimeUpdate, 1, uint64(sequence))
+			stopTimeUpdate = wire.AppendMessage(stopTimeUpdate, 2, event)
+			stopTimeUpdate = wire.AppendMessage(stopTimeUpdate, 3, event)
+			stopTimeUpdate = wire.AppendString(stopTimeUpdate, 4, string(*waypoint.Id))
+			update = wire.AppendMessage(update, 2, stopTimeUpdate)
+			sequence = sequence + 1
+		}
+		update = wire.AppendMessage(update, 3, vehicleDescriptor(state))
+		update = wire.AppendVarint(update, 4, uint64(posix(state.Time, day)))
+		update = wire.AppendVarint(update, 5, uint64(delay))
+		var entity []byte
+		entity = wire.AppendString(entity, 1, string(state.Id))
+		entity = wire.AppendMessage(entity, 3, update)
+		entities = append(entities, entity)
+	}
+	return feedMessage(states, day, entities)
+}
+
+func feedMessage(states []bus.State, day time.Time, entities [][]byte) []byte {
+	var timestamp model.Time
+	for _, state := range states {
+		if timestamp.Before(state.Time) {
+			timestamp = state.Time
+		}
+	}
+	var header []byte
+	header = wire.AppendString(header, 1, "2.0")
+	header = wire.AppendVarint(header, 2, 0)
+	header = wire.AppendVarint(header, 3, uint64(posix(timestamp, day)))
+	var result []byte
+	result = wire.AppendMessage(result, 1, header)
+	for _, entity := range entities {
+		result = wire.AppendMessage(result, 2, entity)
+	}
+	return result
+}
+
+func tripDescriptor(state bus.State, day time.Time) []byte {
+	if state.Assignment == nil || state.Assignment.Line == nil {
+		return nil
+	}
+	line := state.Assignment.Line
+	var result []byte
+	result = wire.AppendString(result, 1, tripId(line.Id, state.Assignment.Departure))
+	result = wire.AppendString(result, 2, formatTime(state.Assignment.Departure))
+	// the service day may start on the previous evening if the daylight saving time begins, but noon is always on the date
+	result = wire.AppendString(result, 3, day.Add(12*time.Hour).Format("20060102"))
+	result = wire.AppendString(result, 5, routeId(line.Id))
+	return result
+}
+
+func vehicleDescriptor(state bus.State) []byte {
+	var result []byte
+	result = wire.AppendString(result, 1, string(state.Id))
+	result = wire.AppendString(result, 2, string(state.Id))
+	return result
+}
+
+// currentStop returns the next stop of the bus (or the stop the bus is waiting at) together with its
+// one-based stop sequence within the trip.
+func currentStop(state bus.State) (int, *model.WayPoint) {
+	sequence := 0
+	for index, waypoint := range state.Assignment.WayPoints {
+		if waypoint.Id == nil {
+			continue
+		}
+		sequence = sequence + 1
+		if index >= state.NextWayPoint {
+			return sequence, &state.Assignment.WayPoints[index]
+		}
+	}
+	return sequence, nil
+}
+
+// ServiceDay returns the service day of the given date in the given time zone, i.e. the time from which the times of the
+// exported feed count. Like in GTFS, this is noon minus twelve hours, which differs from midnight on days on which the
+// daylight saving time begins or ends. The time zone should be the time zone of the agency of the feed (see Exporter).
+func ServiceDay(date time.Time, timezone string) (time.Time, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" {
+		return time.Time{}, fmt.Errorf("unknown time zone \"%s\"", timezone)
+	}
+	date = date.In(location)
+	return time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, location).Add(-12 * time.Hour), nil
+}
+
+func posix(t model.Time, day time.Time) int64 {
+	return day.Add(t.Sub(0)).Unix()
+}
@@ -0,0 +1,142 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/internal/wire"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"testing"
+	"time"
+)
+
+func decode(t *testing.T, message []byte) map[protowire.Number][]interface{} {
+	result, err := wire.Decode(message)
+	require.NoError(t, err)
+	return result
+}
+
+func createStates(t *testing.T) []bus.State {
+	mdl, err := model.Init("../model/testdata/gtfs")
+	require.NoError(t, err)
+	block, _ := mdl.Bus("B1")
+	assignment := block.Assignments[0]
+	return []bus.State{
+		{Id: "B1", Position: [2]float64{49.5, 9.5}, Assignment: &assignment, NextWayPoint: 2, Stop: &assignment.WayPoints[2], Delay: 90 * time.Second, Time: model.MustParseTime("6:22"), InService: true},
+		{Id: "A-out-0635", Position: [2]float64{49.8, 9.9}, Time: model.MustParseTime("6:21"), InService: true},
+		{Id: "A-out-0700", Position: [2]float64{49.8, 9.9}, Time: model.MustParseTime("6:21")},
+	}
+}
+
+func TestVehiclePositions(t *testing.T) {
+	day := time.Date(2020, 12, 24, 0, 0, 0, 0, time.UTC)
+	feed := decode(t, VehiclePositions(createStates(t), day))
+	header := decode(t, feed[1][0].([]byte))
+	assert.Equal(t, "2.0", string(header[1][0].([]byte)), "version")
+	assert.Equal(t, uint64(day.Add(6*time.Hour+22*time.Minute).Unix()), header[3][0], "timestamp of the feed")
+	require.Equal(t, 2, len(feed[2]), "number of entities")
+
+	entity := decode(t, feed[2][0].([]byte))
+	assert.Equal(t, "B1", string(entity[1][0].([]byte)), "entity id")
+	vehicle := decode(t, entity[4][0].([]byte))
+	trip := decode(t, vehicle[1][0].([]byte))
+	assert.Equal(t, "A-1_061500", string(trip[1][0].([]byte)), "trip id")
+	assert.Equal(t, "06:15:00", string(trip[2][0].([]byte)), "start time")
+	assert.Equal(t, "20201224", string(trip[3][0].([]byte)), "start date")
+	assert.Equal(t, "A-1", string(trip[5][0].([]byte)), "route id")
+	position := decode(t, vehicle[2][0].([]byte))
+	assert.Equal(t, float32(49.5), math.Float32frombits(uint32(position[1][0].(uint64))), "latitude")
+	assert.Equal(t, uint64(3), vehicle[3][0], "current stop sequence")
+	assert.Equal(t, uint64(stoppedAt), vehicle[4][0], "current status")
+	assert.Equal(t, "node/248513451", string(vehicle[7][0].([]byte)), "stop id")
+
+	entity = decode(t, feed[2][1].([]byte))
+	vehicle = decode(t, entity[4][0].([]byte))
+	assert.Nil(t, vehicle[1], "bus without assignment should not have a trip")
+}
+
+func TestVehiclePositions_Timezone(t *testing.T) {
+	day, err := ServiceDay(time.Date(2020, 12, 24, 3, 0, 0, 0, time.UTC), "America/New_York")
+	require.NoError(t, err)
+	feed := decode(t, VehiclePositions(createStates(t), day))
+	header := decode(t, feed[1][0].([]byte))
+	assert.Equal(t, uint64(time.Date(2020, 12, 23, 11, 22, 0, 0, time.UTC).Unix()), header[3][0], "timestamp of the feed")
+	entity := decode(t, feed[2][0].([]byte))
+	vehicle := decode(t, entity[4][0].([]byte))
+	trip := decode(t, vehicle[1][0].([]byte))
+	assert.Equal(t, "20201223", string(trip[3][0].([]byte)), "start date")
+}
+
+func TestServiceDay(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	day, err := ServiceDay(time.Date(2020, 12, 24, 3, 0, 0, 0, time.UTC), "America/New_York")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 12, 23, 0, 0, 0, 0, newYork), day, "midnight of the date in the time zone")
+	day, err = ServiceDay(time.Date(2020, 3, 8, 15, 0, 0, 0, newYork), "America/New_York")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 3, 7, 23, 0, 0, 0, newYork), day, "noon minus twelve hours if the daylight saving time begins")
+	_, err = ServiceDay(time.Now(), "Mars/Olympus_Mons")
+	assert.EqualError(t, err, "unknown time zone \"Mars/Olympus_Mons\"")
+}
+
+func TestTripUpdates(t *testing.T) {
+	day := time.Date(2020, 12, 24, 0, 0, 0, 0, time.UTC)
+	feed := decode(t, TripUpdates(createStates(t), day))
+	require.Equal(t, 1, len(feed[2]), "only buses with trips should have trip updates")
+	entity := decode(t, feed[2][0].([]byte))
+	update := decode(t, entity[3][0].([]byte))
+	assert.Equal(t, uint64(90), update[5][0], "delay of the trip")
+	require.Equal(t, 2, len(update[2]), "number of stop time updates")
+	stopTimeUpdate := decode(t, update[2][1].([]byte))
+	assert.Equal(t, uint64(4), stopTimeUpdate[1][0], "stop sequence")
+	assert.Equal(t, "node/535359494", string(stopTimeUpdate[4][0].([]byte)), "stop id")
+	departure := decode(t, stopTimeUpdate[3][0].([]byte))
+	assert.Equal(t, uint64(90), departure[1][0], "delay at stop")
+	assert.Equal(t, uint64(day.Add(6*time.Hour+22*time.Minute+30*time.Second).Unix()), departure[2][0], "predicted departure")
+}
+
+func TestTripUpdates_EarlyBus(t *testing.T) {
+	day := time.Date(2020, 12, 24, 0, 0, 0, 0, time.UTC)
+	states := createStates(t)
+	states[0].Delay = -45 * time.Second
+	feed := decode(t, TripUpdates(states, day))
+	entity := decode(t, feed[2][0].([]byte))
+	update := decode(t, entity[3][0].([]byte))
+	assert.Equal(t, uint64(0), update[5][0], "early bus should be on time")
+	stopTimeUpdate := decode(t, update[2][1].([]byte))
+	departure := decode(t, stopTimeUpdate[3][0].([]byte))
+	assert.Equal(t, uint64(0), departure[1][0], "delay at stop")
+	assert.Equal(t, uint64(day.Add(6*time.Hour+21*time.Minute).Unix()), departure[2][0], "predicted departure")
+}
+
+func TestTripUpdates_MatchExport(t *testing.T) {
+	mdl, err := model.Init("../model/testdata/gtfs")
+	require.NoError(t, err)
+	var buffer bytes.Buffer
+	require.NoError(t, NewExporter(mdl, gps).Export(&buffer))
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	require.NoError(t, err)
+	reader, err := archive.Open("trips.txt")
+	require.NoError(t, err)
+	records, err := csv.NewReader(reader).ReadAll()
+	require.NoError(t, err)
+	exported := make(map[string]string)
+	for _, record := range records[1:] {
+		exported[record[2]] = record[0]
+	}
+
+	day := time.Date(2020, 12, 24, 0, 0, 0, 0, time.UTC)
+	feed := decode(t, TripUpdates(createStates(t), day))
+	entity := decode(t, feed[2][0].([]byte))
+	update := decode(t, entity[3][0].([]byte))
+	trip := decode(t, update[1][0].([]byte))
+	route, ok := exported[string(trip[1][0].([]byte))]
+	require.True(t, ok, "trip %s should be part of the exported feed", trip[1][0])
+	assert.Equal(t, route, string(trip[5][0].([]byte)), "route of the trip in the exported feed")
+}
@@ -0,0 +1,66 @@
+// Package wire contains helpers for encoding protobuf messages by hand with protowire. The messages of OTS are few and
+// simple, thus no generated code is needed.
+package wire
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+)
+
+// AppendMessage appends the encoded message as length delimited field.
+func AppendMessage(buffer []byte, field protowire.Number, message []byte) []byte {
+	buffer = protowire.AppendTag(buffer, field, protowire.BytesType)
+	return protowire.AppendBytes(buffer, message)
+}
+
+// AppendString appends the string as length delimited field.
+func AppendString(buffer []byte, field protowire.Number, value string) []byte {
+	buffer = protowire.AppendTag(buffer, field, protowire.BytesType)
+	return protowire.AppendString(buffer, value)
+}
+
+// AppendVarint appends the value as varint field. Signed values must be converted before, e.g. with
+// protowire.EncodeZigZag for sint fields.
+func AppendVarint(buffer []byte, field protowire.Number, value uint64) []byte {
+	buffer = protowire.AppendTag(buffer, field, protowire.VarintType)
+	return protowire.AppendVarint(buffer, value)
+}
+
+// AppendFloat appends the value as float field, i.e. with single precision.
+func AppendFloat(buffer []byte, field protowire.Number, value float64) []byte {
+	buffer = protowire.AppendTag(buffer, field, protowire.Fixed32Type)
+	return protowire.AppendFixed32(buffer, math.Float32bits(float32(value)))
+}
+
+// Decode splits a protobuf message into its fields, which is mainly useful for testing the encoded messages. Varints
+// and fixed32 values are returned as uint64, length delimited fields as []byte. Other wire types are not supported.
+func Decode(message []byte) (map[protowire.Number][]interface{}, error) {
+	result := make(map[protowire.Number][]interface{})
+	for len(message) > 0 {
+		number, wireType, length := protowire.ConsumeTag(message)
+		if length < 0 {
+			return nil, fmt.Errorf("invalid tag: %v", protowire.ParseError(length))
+		}
+		message = message[length:]
+		var value interface{}
+		switch wireType {
+		case protowire.VarintType:
+			value, length = protowire.ConsumeVarint(message)
+		case protowire.Fixed32Type:
+			var fixed uint32
+			fixed, length = protowire.ConsumeFixed32(message)
+			value = uint64(fixed)
+		case protowire.BytesType:
+			value, length = protowire.ConsumeBytes(message)
+		default:
+			return nil, fmt.Errorf("field %d has the unsupported wire type %v", number, wireType)
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("invalid value of field %d: %v", number, protowire.ParseError(length))
+		}
+		result[number] = append(result[number], value)
+		message = message[length:]
+	}
+	return result, nil
+}
@@ -0,0 +1,32 @@
+package wire
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	var inner []byte
+	inner = AppendString(inner, 1, "V1")
+	var message []byte
+	message = AppendMessage(message, 1, inner)
+	message = AppendVarint(message, 2, 300)
+	message = AppendVarint(message, 2, protowire.EncodeZigZag(-30))
+	message = AppendFloat(message, 3, 49.5)
+
+	fields, err := Decode(message)
+	require.NoError(t, err)
+	decoded, err := Decode(fields[1][0].([]byte))
+	require.NoError(t, err)
+	assert.Equal(t, "V1", string(decoded[1][0].([]byte)), "string of the inner message")
+	assert.Equal(t, []interface{}{uint64(300), protowire.EncodeZigZag(-30)}, fields[2], "repeated varints")
+	assert.Equal(t, float32(49.5), math.Float32frombits(uint32(fields[3][0].(uint64))), "float")
+
+	_, err = Decode(message[:len(message)-1])
+	assert.Error(t, err, "truncated message")
+	_, err = Decode(protowire.AppendTag(nil, 4, protowire.Fixed64Type))
+	assert.EqualError(t, err, "field 4 has the unsupported wire type 1")
+}
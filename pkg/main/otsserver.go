@@ -2,30 +2,60 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/adherence"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/beeline"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/gtfs"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/osrm"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/pax"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/replay"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/rest"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/server"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/sink"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/stream"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/tile"
 	"github.com/gorilla/mux"
 	"github.com/urfave/cli/v2"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type options struct {
+	scenario     string
 	bindAddress  string
 	otrsServer   string
+	routing      string
 	tileServer   string
 	tileRedirect bool
 	frequency    float64
 	warp         float64
 	busSpeedKmh  int
+	acceleration float64
+	deceleration float64
+	doorOverhead float64
+	boarding     float64
+	alighting    float64
+	doors        int
+	seed         int64
+	seedSet      bool
+	headless     bool
+	output       string
+	report       string
+	journeys     string
+	checkpoint   string
+	restore      string
+	sinks        cli.StringSlice
+	timezone     string
 }
 
 func main() {
@@ -41,27 +71,162 @@ func main() {
 	options := options{}
 
 	app.Flags = []cli.Flag{
+		&cli.StringFlag{Name: "scenario", Usage: "The scenario directory (or GTFS feed) to simulate", Value: "samples/wuerzburg(fictional)", Destination: &options.scenario},
 		&cli.StringFlag{Name: "bindAddress", Usage: "Sets the bind address and port for the app", Value: "127.0.0.1:9551", Destination: &options.bindAddress},
 		&cli.StringFlag{Name: "otrsServer", Usage: "The OTRS base URL for fetching route information", Value: "http://127.0.0.1:5000/", Destination: &options.otrsServer},
+		&cli.StringFlag{Name: "routing", Usage: "The route service: \"osrm\" queries the OSRM server given by otrsServer, \"beeline\" connects the waypoints by great-circle arcs and works offline", Value: "osrm", Destination: &options.routing},
 		&cli.StringFlag{Name: "tileServer", Usage: "The OSM tile server being used for querying tile images", Value: "http://127.0.0.1:8080/tile/{z}/{x}/{y}.png", Destination: &options.tileServer},
 		&cli.BoolFlag{Name: "tileRedirect", Usage: "If false, the OTS backend behaves as reverse proxy for the OSM tiles. If true, OTS backend sends 301 redirects pointing to the real tile (saves bandwidth on the OTS backend)", Value: false, Destination: &options.tileRedirect},
 		&cli.Float64Flag{Name: "frequency", Usage: "The number of simulation cycles in one second.", Value: 1, Destination: &options.frequency},
 		&cli.Float64Flag{Name: "warp", Usage: "Defines the relation between frequency and real time. warp=1 is real time, warp=2 lets time pass twice as fast.", Value: 1, Destination: &options.warp},
-		&cli.IntFlag{Name: "busSpeed", Usage: "The constant speed of the busses (in kmh).", Value: 40, Destination: &options.busSpeedKmh},
+		&cli.IntFlag{Name: "busSpeed", Usage: "The maximal speed of the busses (in kmh). On road segments with lower average speeds (known from OSRM), the busses drive slower.", Value: 40, Destination: &options.busSpeedKmh},
+		&cli.Float64Flag{Name: "acceleration", Usage: "The acceleration of the busses (in m/s²). 0 lets the busses reach their speed instantly.", Value: 1.0, Destination: &options.acceleration},
+		&cli.Float64Flag{Name: "deceleration", Usage: "The deceleration of the busses when braking for stops and slower road segments (in m/s²). 0 lets the busses stop instantly.", Value: 1.2, Destination: &options.deceleration},
+		&cli.Float64Flag{Name: "doorOverhead", Usage: "The time for opening and closing the doors at stops with boarding or alighting passengers (in seconds).", Value: 4, Destination: &options.doorOverhead},
+		&cli.Float64Flag{Name: "boardingTime", Usage: "The time one passenger needs to board the bus through one door (in seconds).", Value: 2.5, Destination: &options.boarding},
+		&cli.Float64Flag{Name: "alightingTime", Usage: "The time one passenger needs to alight from the bus through one door (in seconds).", Value: 1.5, Destination: &options.alighting},
+		&cli.IntFlag{Name: "doors", Usage: "The number of doors of busses whose door configuration is not given in the scenario.", Value: 2, Destination: &options.doors},
+		&cli.StringFlag{Name: "timezone", Usage: "The time zone of the scenario, e.g. America/New_York. It is the time zone of the agency of exported feeds and the GTFS-Realtime feeds convert the simulation times in this time zone.", Value: "Europe/Berlin", Destination: &options.timezone},
+		&cli.StringSliceFlag{Name: "sink", Usage: "Additionally delivers all positions and events to a file (file:<path>), an MQTT broker (mqtt://<host>:<port>/<topic prefix>) or a webhook (http(s) URL). Can be given several times.", Destination: &options.sinks},
 	}
 
+	app.Before = checkOptions(&options)
 	app.Action = runWithOptions(&options)
+	app.Commands = []*cli.Command{
+		{
+			Name:  "run",
+			Usage: "Runs the simulation. This is the default if no command is given.",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "headless", Usage: "Runs the simulation as fast as possible without server and writes all positions and events to the output file", Destination: &options.headless},
+				&cli.StringFlag{Name: "output", Usage: "The output file of a headless run. Files ending with .csv are written as CSV, all others as JSONL.", Value: "simulation.jsonl", Destination: &options.output},
+				&cli.StringFlag{Name: "report", Usage: "If set, the punctuality report per line and stop is written to this file (JSON) at the end of a headless run", Destination: &options.report},
+				&cli.StringFlag{Name: "journeys", Usage: "If set, the journeys of the passengers of the scenario are written to this file (JSON) at the end of a headless run", Destination: &options.journeys},
+				&cli.StringFlag{Name: "checkpoint", Usage: "If set, the state of the simulation is written to this file (JSON) at the end of the run, also if the run is interrupted (Ctrl+C).", Destination: &options.checkpoint},
+				&cli.Int64Flag{Name: "seed", Usage: "The seed of the random disturbances and of the passenger demand of the scenario. Overrides the seeds given in the scenario; if neither is given, a random seed is chosen and logged.", Destination: &options.seed},
+				&cli.StringFlag{Name: "restore", Usage: "Continues the simulation from the given checkpoint file instead of starting at the beginning of the scenario", Destination: &options.restore},
+			},
+			Action: runWithOptions(&options),
+		},
+		{
+			Name:      "export",
+			Usage:     "Exports a scenario as GTFS static feed (zip archive). Defaults to the directory given by --scenario.",
+			ArgsUsage: "[scenario directory] <output file>",
+			Action:    exportWithOptions(&options),
+		},
+		{
+			Name:      "replay",
+			Usage:     "Serves a recording (the JSONL output of a headless run or a file sink) through the websocket and the REST api. Neither a route service nor the scenario is needed.",
+			ArgsUsage: "<recording>",
+			Action:    replayWithOptions(&options),
+		},
+		{
+			Name:      "validate",
+			Usage:     "Loads a scenario and reports all problems found in it. Defaults to the directory given by --scenario.",
+			ArgsUsage: "[scenario directory]",
+			Action:    validateWithOptions(&options),
+		},
+	}
 	err := app.Run(os.Args)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
 }
 
+// checkOptions rejects the options that would stop the simulation clock or make it fail.
+func checkOptions(options *options) cli.BeforeFunc {
+	return func(*cli.Context) error {
+		if options.frequency <= 0 {
+			return fmt.Errorf("the frequency must be positive, but was %v", options.frequency)
+		}
+		if options.warp <= 0 {
+			return fmt.Errorf("the warp must be positive, but was %v", options.warp)
+		}
+		return nil
+	}
+}
+
+func exportWithOptions(options *options) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 || ctx.NArg() > 2 {
+			return fmt.Errorf("expected an optional scenario directory and an output file, but got %d arguments", ctx.NArg())
+		}
+		directory, output := options.scenario, ctx.Args().Get(0)
+		if ctx.NArg() == 2 {
+			directory, output = ctx.Args().Get(0), ctx.Args().Get(1)
+		}
+		mdl, err := model.Init(directory)
+		if err != nil {
+			return fmt.Errorf("could not understand scenario directory: %v", err)
+		}
+		gps, err := routeService(options)
+		if err != nil {
+			return err
+		}
+		mdl, err = resolveShapes(mdl, gps)
+		if err != nil {
+			return err
+		}
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("could not create output file: %v", err)
+		}
+		exporter := gtfs.NewExporter(mdl, gps)
+		exporter.Timezone = options.timezone
+		err = exporter.Export(file)
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("could not write output file: %v", closeErr)
+		}
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+		return err
+	}
+}
+
+// resolveShapes queries the routes of all lines and assignments of the scenario once, see model.ResolveShapes.
+func resolveShapes(mdl model.Model, gps model.RouteService) (model.Model, error) {
+	resolved, problems := model.ResolveShapes(mdl, gps)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("could not resolve the routes of the scenario: %v", problems)
+	}
+	return resolved, nil
+}
+
+func validateWithOptions(options *options) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		directory := options.scenario
+		if ctx.NArg() > 0 {
+			directory = ctx.Args().First()
+		}
+		problems := model.Validate(directory)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("scenario \"%s\" has %d problem(s)", directory, len(problems))
+		}
+		fmt.Printf("scenario \"%s\" is valid\n", directory)
+		return nil
+	}
+}
+
+func routeService(options *options) (model.RouteService, error) {
+	switch options.routing {
+	case "osrm":
+		return osrm.NewRouteService(options.otrsServer), nil
+	case "beeline":
+		return beeline.NewRouteService(25), nil
+	default:
+		return nil, fmt.Errorf("unknown route service \"%s\", expected \"osrm\" or \"beeline\"", options.routing)
+	}
+}
+
 func runWithOptions(options *options) cli.ActionFunc {
 	return func(ctx *cli.Context) error {
 		logger := log.New(os.Stdout, "", log.LstdFlags)
+		options.seedSet = ctx.IsSet("seed")
 		logger.Printf("Loading scenario file …\n")
-		mdl, err := model.Init("samples/wuerzburg(fictional)")
+		mdl, err := model.Init(options.scenario)
 		if err != nil {
 			return fmt.Errorf("could not understand scenario directory: %v", err)
 		}
@@ -69,48 +234,487 @@ func runWithOptions(options *options) cli.ActionFunc {
 		logger.Println()
 		logger.Printf("%v", mdl)
 		logger.Println()
-		tileUrl, err := url.Parse(options.tileServer)
+		gps, err := routeService(options)
+		if err != nil {
+			return err
+		}
+		logger.Printf("Resolving routes of the scenario …\n")
+		mdl, err = resolveShapes(mdl, gps)
 		if err != nil {
-			logger.Fatalf("the provided tile server URL \"%v\" is not a valid URL: %v", options.tileServer, err)
+			return err
+		}
+		if options.headless {
+			return runHeadless(options, mdl, gps, logger)
+		}
+		serviceDay, err := gtfs.ServiceDay(time.Now(), options.timezone)
+		if err != nil {
+			return err
 		}
 		logger.Printf("Starting simulation.")
 
-		clientContainer := server.NewClientContainer()
-		gps := osrm.NewRouteService(options.otrsServer)
-		publisher := func(position model.BusPosition) {
-			clientContainer.BroadcastJson(position)
+		clientContainer := newClientContainer()
+		var dispatcher *bus.Dispatcher
+		sinks, err := openSinks(options, func() model.Time { return dispatcher.Now() })
+		if err != nil {
+			return err
+		}
+		websocket := newWebsocketPublisher(clientContainer)
+		dispatcher = bus.NewDispatcher(mdl, func(position model.BusPosition) {
+			sinks.Position(position)
+			websocket.position(position)
+		}, gps)
+		tracker := adherence.NewTracker()
+		dispatcher.PublishEvent = func(event model.Event) {
+			sinks.Event(event)
+			tracker.Event(event)
+			websocket.event(event)
 		}
-		dispatcher := bus.NewDispatcher(mdl, publisher, gps)
 		dispatcher.Frequency = options.frequency
 		dispatcher.Warp = options.warp
+		dispatcher.BusSpeedKmh = options.busSpeedKmh
+		dispatcher.Acceleration = options.acceleration
+		dispatcher.Deceleration = options.deceleration
+		dispatcher.Dwell = dwellModel(options)
+		dispatcher.Disturbances = disturbances(options, mdl, logger)
+		dispatcher.PublishStatus = func(status bus.Status) {
+			clientContainer.BroadcastJson(status)
+		}
+		clientContainer.Snapshot = snapshot(dispatcher.Status, dispatcher.QueryBusPositions)
+		start, err := restoreCheckpoint(options, dispatcher, mdl.Start())
+		if err != nil {
+			_ = sinks.Close()
+			return err
+		}
+		passengers := passengerSimulation(options, mdl, dispatcher, start, logger)
+		dispatcher.AfterTick = func(now model.Time) {
+			if passengers != nil {
+				passengers.Update(now)
+			}
+			clientContainer.Flush()
+		}
 
-		handler := mux.NewRouter()
-		handler.PathPrefix("/sockets").Handler(clientContainer)
 		routerConfig := rest.RouterConfig{
 			LineModel:  mdl,
 			BusModel:   mdl,
+			StopModel:  mdl,
 			Dispatcher: dispatcher,
+			Tracker:    tracker,
+			Passengers: passengers,
+			ServiceDay: serviceDay,
 			Gps:        gps,
 		}
-		handler.PathPrefix("/api").Handler(rest.NewRouter(routerConfig))
-		handler.PathPrefix("/tile").Handler(tile.NewProxy(tileUrl, options.tileRedirect))
-		handler.PathPrefix("/").Handler(http.FileServer(http.Dir("webfrontend/dist/webfrontend")))
-
-		var wg sync.WaitGroup
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			dispatcher.Run(mdl.Start())
-		}()
-		srv := http.Server{Addr: options.bindAddress, Handler: handler}
-		go func() {
-			logger.Printf("Listening on %s … ", options.bindAddress)
-			err := srv.ListenAndServe()
+		return serve(options, logger, clientContainer, rest.NewRouter(routerConfig), dispatcher.Stop, func() {
+			dispatcher.Run(start)
+			if err := sinks.Close(); err != nil {
+				logger.Printf("Not all results could be delivered: %v", err)
+			}
+			if err := writeCheckpoint(options, dispatcher, logger); err != nil {
+				logger.Printf("%v", err)
+			}
+			report := tracker.Report()
+			logPunctuality(logger, report)
+			clientContainer.BroadcastJson(report)
+			if passengers != nil {
+				logJourneys(logger, passengers.Report())
+			}
+		})
+	}
+}
+
+func replayWithOptions(options *options) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if ctx.NArg() != 1 {
+			return fmt.Errorf("expected a recording, but got %d arguments", ctx.NArg())
+		}
+		logger := log.New(os.Stdout, "", log.LstdFlags)
+		file, err := os.Open(ctx.Args().First())
+		if err != nil {
+			return fmt.Errorf("could not open recording: %v", err)
+		}
+		player, err := replay.Load(file)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("could not read recording \"%s\": %v", ctx.Args().First(), err)
+		}
+		logger.Printf("Replaying the recording from %v to %v.", player.Start(), player.End())
+		routerConfig := rest.RouterConfig{Simulation: player}
+		if ctx.IsSet("scenario") {
+			mdl, err := model.Init(options.scenario)
 			if err != nil {
-				log.Fatalf("could not start srv: %v", err)
+				return fmt.Errorf("could not understand scenario directory: %v", err)
 			}
-		}()
-		wg.Wait()
-		return srv.Shutdown(context.Background())
+			player.Buses = mdl
+			routerConfig.LineModel = mdl
+			routerConfig.BusModel = mdl
+			routerConfig.StopModel = mdl
+			routerConfig.Assignments = player
+		}
+
+		clientContainer := newClientContainer()
+		sinks, err := openSinks(options, func() model.Time { return player.Status().Time })
+		if err != nil {
+			return err
+		}
+		websocket := newWebsocketPublisher(clientContainer)
+		player.Publish = func(position model.BusPosition) {
+			sinks.Position(position)
+			websocket.position(position)
+		}
+		tracker := adherence.NewTracker()
+		player.PublishEvent = func(event model.Event) {
+			sinks.Event(event)
+			tracker.Event(event)
+			websocket.event(event)
+		}
+		player.PublishRemoval = websocket.removal
+		player.Frequency = options.frequency
+		player.Warp = options.warp
+		player.PublishStatus = func(status bus.Status) {
+			clientContainer.BroadcastJson(status)
+		}
+		player.AfterTick = func(model.Time) {
+			clientContainer.Flush()
+		}
+		clientContainer.Snapshot = snapshot(player.Status, player.QueryBusPositions)
+
+		routerConfig.Tracker = tracker
+		return serve(options, logger, clientContainer, rest.NewRouter(routerConfig), player.Stop, func() {
+			player.Run()
+			if err := sinks.Close(); err != nil {
+				logger.Printf("Not all results could be delivered: %v", err)
+			}
+			logger.Printf("Replay finished.")
+		})
+	}
+}
+
+func newClientContainer() *server.ClientContainer {
+	result := server.NewClientContainer()
+	result.Codecs[stream.Subprotocol] = server.Codec{Binary: true, Encode: stream.Encode}
+	return result
+}
+
+// websocketPublisher publishes positions and events to the websocket clients. Events are published with
+// the latest location of their bus, so that clients subscribing to an area receive them. Positions and events
+// must be published one after another, thus no synchronization is needed.
+type websocketPublisher struct {
+	clientContainer *server.ClientContainer
+	locations       map[model.BusId][2]float64
+}
+
+func newWebsocketPublisher(clientContainer *server.ClientContainer) *websocketPublisher {
+	return &websocketPublisher{clientContainer: clientContainer, locations: make(map[model.BusId][2]float64)}
+}
+
+func (w *websocketPublisher) position(position model.BusPosition) {
+	w.locations[position.BusId] = position.Location
+	topic := server.Topic{Bus: string(position.BusId), Line: string(position.LineId), Location: &position.Location}
+	w.clientContainer.Publish(&topic, position)
+}
+
+func (w *websocketPublisher) removal(id model.BusId) {
+	delete(w.locations, id)
+	w.clientContainer.Remove(string(id))
+}
+
+func (w *websocketPublisher) event(event model.Event) {
+	topic := server.Topic{Bus: string(event.BusId), Line: string(event.LineId), Discrete: true}
+	if location, ok := w.locations[event.BusId]; ok {
+		topic.Location = &location
+	}
+	w.clientContainer.Publish(&topic, event)
+}
+
+// snapshot creates the messages a new websocket client receives first: the schema, the status of the
+// simulation and the current positions of all buses.
+func snapshot(status func() bus.Status, positions func() []model.BusPosition) func() []interface{} {
+	return func() []interface{} {
+		result := []interface{}{model.Schema{Type: "schema", Version: model.SchemaVersion}, status()}
+		for _, position := range positions() {
+			result = append(result, position)
+		}
+		return result
+	}
+}
+
+// serve serves the api, the websocket, the tiles, and the frontend while run is running. If the process is interrupted
+// (Ctrl+C) or the server cannot be started, stop is called; run must then return and clean up, e.g. close the sinks.
+func serve(options *options, logger *log.Logger, clientContainer *server.ClientContainer, api http.Handler, stop func(), run func()) error {
+	tileUrl, err := url.Parse(options.tileServer)
+	if err != nil {
+		return fmt.Errorf("the provided tile server URL \"%v\" is not a valid URL: %v", options.tileServer, err)
+	}
+	handler := mux.NewRouter()
+	handler.PathPrefix("/sockets").Handler(clientContainer)
+	handler.PathPrefix("/api").Handler(api)
+	handler.PathPrefix("/tile").Handler(tile.NewProxy(tileUrl, options.tileRedirect))
+	handler.PathPrefix("/").Handler(http.FileServer(http.Dir("webfrontend/dist/webfrontend")))
+
+	defer interruptions(logger, stop)()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run()
+	}()
+	srv := http.Server{Addr: options.bindAddress, Handler: handler}
+	failure := make(chan error, 1)
+	go func() {
+		logger.Printf("Listening on %s … ", options.bindAddress)
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			failure <- err
+		}
+	}()
+	var result error
+	select {
+	case <-done:
+	case err := <-failure:
+		result = fmt.Errorf("could not start server: %v", err)
+		stop()
+		<-done
+	}
+	err = srv.Shutdown(context.Background())
+	if result != nil {
+		return result
+	}
+	return err
+}
+
+// interruptions calls stop as soon as the process is interrupted (Ctrl+C) or terminated. The returned function
+// restores the default handling of the signals and must be called once the simulation has finished.
+func interruptions(logger *log.Logger, stop func()) func() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-interrupts; ok {
+			logger.Printf("Stopping simulation …")
+			stop()
+		}
+	}()
+	return func() {
+		signal.Stop(interrupts)
+		close(interrupts)
+	}
+}
+
+func runHeadless(options *options, mdl model.Model, gps model.RouteService, logger *log.Logger) error {
+	if options.busSpeedKmh <= 0 {
+		return fmt.Errorf("the bus speed must be positive in a headless run, but was %d", options.busSpeedKmh)
+	}
+	var dispatcher *bus.Dispatcher
+	clock := func() model.Time { return dispatcher.Now() }
+	output, err := sink.NewFile(options.output, clock)
+	if err != nil {
+		return err
+	}
+	sinks, err := openSinks(options, clock)
+	if err != nil {
+		_ = output.Close()
+		return err
+	}
+	sinks = append(sink.FanOut{output}, sinks...)
+	dispatcher = bus.NewDispatcher(mdl, sinks.Position, gps)
+	tracker := adherence.NewTracker()
+	dispatcher.PublishEvent = func(event model.Event) {
+		sinks.Event(event)
+		tracker.Event(event)
+	}
+	dispatcher.Frequency = options.frequency
+	dispatcher.Warp = options.warp
+	dispatcher.BusSpeedKmh = options.busSpeedKmh
+	dispatcher.Acceleration = options.acceleration
+	dispatcher.Deceleration = options.deceleration
+	dispatcher.Dwell = dwellModel(options)
+	dispatcher.Disturbances = disturbances(options, mdl, logger)
+	start, err := restoreCheckpoint(options, dispatcher, mdl.Start())
+	if err != nil {
+		_ = sinks.Close()
+		return err
+	}
+	passengers := passengerSimulation(options, mdl, dispatcher, start, logger)
+	if passengers != nil {
+		dispatcher.AfterTick = passengers.Update
+	}
+	logger.Printf("Starting headless simulation.")
+	release := interruptions(logger, dispatcher.Stop)
+	dispatcher.RunHeadless(start)
+	release()
+	// the checkpoint is written even if not all results could be delivered, and both failures are reported
+	err = errors.Join(sinks.Close(), writeCheckpoint(options, dispatcher, logger))
+	if err != nil {
+		return err
+	}
+	logger.Printf("Simulation finished at %v, results written to \"%s\".", dispatcher.Now(), options.output)
+	report := tracker.Report()
+	logPunctuality(logger, report)
+	if options.report != "" {
+		err = writeJson(options.report, report)
+		if err != nil {
+			return fmt.Errorf("could not write punctuality report: %v", err)
+		}
+		logger.Printf("Punctuality report written to \"%s\".", options.report)
+	}
+	if passengers == nil {
+		if options.journeys != "" {
+			logger.Printf("The scenario has no passengers, no journeys are written.")
+		}
+		return nil
+	}
+	journeys := passengers.Report()
+	logJourneys(logger, journeys)
+	if options.journeys == "" {
+		return nil
+	}
+	err = writeJson(options.journeys, journeys)
+	if err != nil {
+		return fmt.Errorf("could not write journeys: %v", err)
+	}
+	logger.Printf("Journeys written to \"%s\".", options.journeys)
+	return nil
+}
+
+func writeJson(file string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// dwellModel converts the dwell time settings of the options, which are given in seconds.
+func dwellModel(options *options) bus.DwellModel {
+	seconds := func(value float64) time.Duration {
+		return time.Duration(value * float64(time.Second))
+	}
+	return bus.DwellModel{
+		Overhead:      seconds(options.doorOverhead),
+		BoardingTime:  seconds(options.boarding),
+		AlightingTime: seconds(options.alighting),
+		Doors:         options.doors,
+	}
+}
+
+// seed returns the seed given by the command line, if any, and otherwise the given seed of the scenario. If neither
+// the command line nor the scenario specifies a seed, a random seed is chosen.
+func seed(options *options, scenario *int64) *int64 {
+	if options.seedSet {
+		return &options.seed
+	}
+	if scenario != nil {
+		return scenario
+	}
+	result := time.Now().UnixNano()
+	return &result
+}
+
+// disturbances returns the disturbances of the scenario with the seed chosen by seed. The seed is logged.
+func disturbances(options *options, mdl model.Model, logger *log.Logger) *model.Disturbances {
+	if mdl.Disturbances() == nil {
+		if options.seedSet && mdl.Demand() == nil {
+			logger.Printf("The scenario has neither disturbances nor demand, the seed is ignored.")
+		}
+		return nil
+	}
+	result := *mdl.Disturbances()
+	result.Seed = seed(options, result.Seed)
+	logger.Printf("Disturbing the simulation with seed %d.", *result.Seed)
+	return &result
+}
+
+// passengerSimulation lets the passengers of the scenario travel with the buses of the dispatcher. It returns nil if the
+// scenario has neither passengers nor demand; then, the static demand of the stops is used. The passengers are not part
+// of checkpoints, thus after restoring a checkpoint only the passengers appearing after the checkpoint are simulated.
+func passengerSimulation(options *options, mdl model.Model, dispatcher *bus.Dispatcher, start model.Time, logger *log.Logger) *pax.Simulation {
+	if len(mdl.Passengers()) == 0 && mdl.Demand() == nil {
+		return nil
+	}
+	sampled := demand(options, mdl, logger)
+	generator := pax.NewGenerator(mdl.Passengers(), sampled, mdl.Start())
+	if options.restore != "" {
+		generator.Skip(start)
+	}
+	result := pax.NewSimulation(mdl.Buses(), generator)
+	dispatcher.Passengers = result
+	if sampled != nil {
+		dispatcher.DemandSeed = sampled.Seed
+	}
+	if len(mdl.Passengers()) > 0 {
+		logger.Printf("Simulating %d passengers.", len(mdl.Passengers()))
+	}
+	return result
+}
+
+// demand returns the origin-destination demand of the scenario with the seed chosen by seed. The seed is logged.
+func demand(options *options, mdl model.Model, logger *log.Logger) *model.Demand {
+	if mdl.Demand() == nil {
+		return nil
+	}
+	result := *mdl.Demand()
+	result.Seed = seed(options, result.Seed)
+	logger.Printf("Sampling %d passenger flows with seed %d.", len(result.Flows), *result.Seed)
+	return &result
+}
+
+// restoreCheckpoint restores the checkpoint given by the command line, if any, and returns the time at which the
+// simulation must be started.
+func restoreCheckpoint(options *options, dispatcher *bus.Dispatcher, start model.Time) (model.Time, error) {
+	if options.restore == "" {
+		return start, nil
+	}
+	data, err := ioutil.ReadFile(options.restore)
+	if err != nil {
+		return 0, fmt.Errorf("could not read checkpoint: %v", err)
+	}
+	checkpoint := bus.Checkpoint{}
+	err = json.Unmarshal(data, &checkpoint)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse checkpoint \"%s\": %v", options.restore, err)
+	}
+	err = dispatcher.Restore(checkpoint)
+	if err != nil {
+		return 0, fmt.Errorf("could not restore checkpoint \"%s\": %v", options.restore, err)
+	}
+	return checkpoint.Time, nil
+}
+
+func writeCheckpoint(options *options, dispatcher *bus.Dispatcher, logger *log.Logger) error {
+	if options.checkpoint == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(dispatcher.Checkpoint(), "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(options.checkpoint, data, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write checkpoint: %v", err)
+	}
+	logger.Printf("Checkpoint at %v written to \"%s\".", dispatcher.Now(), options.checkpoint)
+	return nil
+}
+
+// openSinks opens the sinks given by the command line. If one of them cannot be opened,
+// the already opened sinks are closed again. In headless runs, the sinks slow the simulation down instead of
+// dropping output.
+func openSinks(options *options, clock func() model.Time) (sink.FanOut, error) {
+	result := make(sink.FanOut, 0, len(options.sinks.Value()))
+	for _, specification := range options.sinks.Value() {
+		opened, err := sink.Open(specification, options.headless, clock)
+		if err != nil {
+			_ = result.Close()
+			return nil, err
+		}
+		result = append(result, opened)
+	}
+	return result, nil
+}
+
+func logJourneys(logger *log.Logger, report pax.Report) {
+	logger.Printf("Passengers: %d appeared, %d arrived, %d without connection, mean travel time %.0fs, mean waiting time %.0fs, mean transfer time %.0fs", report.Passengers, report.Arrived, report.Unroutable, report.MeanTravelTime, report.MeanWaitingTime, report.MeanTransferTime)
+}
+
+func logPunctuality(logger *log.Logger, report adherence.Report) {
+	for _, line := range report.Lines {
+		logger.Printf("Line %s: %d departures, %.1f%% on time, mean delay %.0fs, 95th percentile %.0fs", line.LineId, line.Departures, line.OnTime, line.MeanDelay, line.P95Delay)
 	}
 }
@@ -7,8 +7,10 @@ import (
 	"github.com/goccy/go-yaml"
 	geojson "github.com/paulmach/go.geojson"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 // BusModel is a model designed for all bus stuff.
@@ -23,48 +25,117 @@ type LineModel interface {
 	Line(LineId) (Line, bool)
 }
 
+// StopModel is a model designed for stop management.
+type StopModel interface {
+	Stops() []WayPoint
+	Stop(StopId) (WayPoint, bool)
+}
+
 // Model represent the static data of a scenario. The model does not change over time, i.e. bus positions etc. are
 // not stored in the model.
 type Model interface {
 	BusModel
 	LineModel
+	StopModel
 	Start() Time
+	// Disturbances returns the random disturbances of the scenario, or nil if the scenario has none.
+	Disturbances() *Disturbances
+	// Passengers returns the passengers of the scenario, sorted by the time of their appearance.
+	Passengers() []Passenger
+	// Demand returns the origin-destination demand of the scenario, or nil if the scenario has none.
+	Demand() *Demand
 }
 
-// Init loads the scenario from the provided directory and parses it.
+// Init loads the scenario from the provided directory and parses it. Instead of a scenario directory,
+// the path may also point to a GTFS static feed, either as zip archive or as extracted directory. In this
+// case, stops.txt defines the stops, every distinct stop sequence of a route in trips.txt and stop_times.txt
+// becomes a line, and every block (block_id) becomes a bus serving the trips of the block. Trips without a block
+// are served by a bus of their own. Calendars are not evaluated, i.e. all trips of the feed are part of the scenario;
+// a block serving trips of several services becomes one bus per service. Stops without coordinates and trips visiting a
+// stop twice are not supported; they are left out of the scenario and reported by Validate.
 func Init(directory string) (Model, error) {
+	model, problems, _ := loadScenarioOrFeed(directory)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return model, nil
+}
+
+// loadScenarioOrFeed loads the scenario directory or GTFS feed at the provided path with load or loadGtfs, respectively.
+// The parts of a feed that are left out are returned separately, see loadGtfs.
+func loadScenarioOrFeed(path string) (*model, Problems, Problems) {
+	if isGtfsFeed(path) {
+		return loadGtfs(path)
+	}
+	model, problems := load(path)
+	return model, problems, nil
+}
+
+// load loads the scenario from the provided directory. In contrast to Init, load does not stop at the first problem
+// but collects all problems it encounters. Parts of the scenario that cannot be loaded are left out of the returned model.
+// If the scenario file itself cannot be read, then the returned model is nil.
+func load(directory string) (*model, Problems) {
 	path := filepath.Join(directory, "scenario.yaml")
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("could not open scenario file: %v", err)
+		return nil, Problems{fmt.Errorf("could not open scenario file: %v", err)}
 	}
+	defer file.Close()
 	scenario := scenario{}
 	err = yaml.NewDecoder(file).Decode(&scenario)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse scenario file \"%s\": %v", path, err)
+		return nil, Problems{fmt.Errorf("could not parse scenario file \"%s\": %v", path, err)}
 	}
+	problems := Problems{}
 	model := model{}
 	model.start, err = ParseTime(scenario.Start)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse start time \"%s\"", model.start)
+		problems = append(problems, fmt.Errorf("could not parse start time \"%s\"", scenario.Start))
 	}
 	stops := make(map[StopId]WayPoint)
 	for _, stopFile := range scenario.StopDefinitions {
 		err := loadStops(filepath.Join(directory, stopFile), stops)
 		if err != nil {
-			return nil, fmt.Errorf("loading the waypoints from the referenced file \"%s\" failed: %v", stopFile, err)
+			problems = append(problems, fmt.Errorf("loading the waypoints from the referenced file \"%s\" failed: %v", stopFile, err))
 		}
 	}
 	model.stops = stops
-	model.lines, err = loadLines(scenario, directory, stops)
-	if err != nil {
-		return nil, fmt.Errorf("could not load lines: %v", err)
+	var lineProblems, busProblems Problems
+	model.lines, lineProblems = loadLines(scenario, directory, stops)
+	problems = append(problems, lineProblems...)
+	nameStops(stops, model.lines)
+	model.buses, busProblems = loadBuses(scenario, model.lines)
+	problems = append(problems, busProblems...)
+	var disturbanceProblems Problems
+	model.disturbances, disturbanceProblems = loadDisturbances(scenario)
+	problems = append(problems, disturbanceProblems...)
+	var passengerProblems Problems
+	model.passengers, passengerProblems = loadPassengers(scenario, directory, stops)
+	problems = append(problems, passengerProblems...)
+	var demandProblems Problems
+	model.demand, demandProblems = loadDemand(scenario, directory, stops)
+	problems = append(problems, demandProblems...)
+	return &model, problems
+}
+
+// nameStops names the stops without name after the first line (in definition order) that serves them,
+// since the stop definitions do not need to contain names.
+func nameStops(stops map[StopId]WayPoint, lines map[LineId]Line) {
+	sorted := make([]Line, 0, len(lines))
+	for _, line := range lines {
+		sorted = append(sorted, line)
 	}
-	model.buses, err = loadBuses(scenario, model.lines)
-	if err != nil {
-		return nil, fmt.Errorf("could not load lines: %v", err)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].DefinitionIndex < sorted[j].DefinitionIndex
+	})
+	for _, line := range sorted {
+		for _, waypoint := range line.Stops() {
+			if stop, ok := stops[*waypoint.Id]; ok && stop.Name == "" {
+				stop.Name = waypoint.Name
+				stops[*waypoint.Id] = stop
+			}
+		}
 	}
-	return &model, err
 }
 
 func loadStops(path string, stops map[StopId]WayPoint) error {
@@ -82,11 +153,31 @@ func loadStops(path string, stops map[StopId]WayPoint) error {
 		if name, ok := feature.Properties["name"]; ok {
 			stop.Name = fmt.Sprintf("%v", name)
 		}
+		if stop.Boarding, err = demand(feature.Properties, "boarding"); err != nil {
+			return fmt.Errorf("stop \"%s\": %v", id, err)
+		}
+		if stop.Alighting, err = demand(feature.Properties, "alighting"); err != nil {
+			return fmt.Errorf("stop \"%s\": %v", id, err)
+		}
 		stops[id] = stop
 	}
 	return nil
 }
 
+// demand reads the number of passengers from the given property of a stop. Missing properties mean that
+// there are no passengers.
+func demand(properties map[string]interface{}, key string) (int, error) {
+	value, ok := properties[key]
+	if !ok {
+		return 0, nil
+	}
+	number, ok := value.(float64)
+	if !ok || number < 0 || number != math.Trunc(number) {
+		return 0, fmt.Errorf("property \"%s\" must be a non-negative integer, but was \"%v\"", key, value)
+	}
+	return int(number), nil
+}
+
 type scenario struct {
 	Start           string
 	StopDefinitions []string `json:"stopDefinitions"`
@@ -98,12 +189,16 @@ type scenario struct {
 	}
 	Buses []struct {
 		Id          string
+		Doors       int
 		Assignments []struct {
 			Start       string
 			Line        string
 			Coordinates [][2]float64
 		}
 	}
+	Disturbances *scenarioDisturbances
+	Passengers   string
+	Demand       *scenarioDemand
 }
 
 type model struct {
@@ -111,6 +206,11 @@ type model struct {
 	stops map[StopId]WayPoint
 	lines map[LineId]Line
 	buses map[BusId]Bus
+	// disturbances is nil if the scenario has no disturbances.
+	disturbances *Disturbances
+	passengers   []Passenger
+	// demand is nil if the scenario has no origin-destination demand.
+	demand *Demand
 }
 
 // Buses returns a slice of all busses in this model.
@@ -142,6 +242,21 @@ func (m *model) Line(s LineId) (Line, bool) {
 	return line, ok
 }
 
+// Stops returns a slice of all stops in this model.
+func (m *model) Stops() []WayPoint {
+	result := make([]WayPoint, 0, len(m.stops))
+	for _, stop := range m.stops {
+		result = append(result, stop)
+	}
+	return result
+}
+
+// Stop returns the stop with the given id. If the stop does not exist, then the second return variable is false.
+func (m *model) Stop(id StopId) (WayPoint, bool) {
+	stop, ok := m.stops[id]
+	return stop, ok
+}
+
 func (m *model) String() string {
 	result := fmt.Sprintf("Run Time: %v\n", m.start)
 	result = result + fmt.Sprintf("waypoints: %d\n", len(m.stops))
@@ -153,3 +268,15 @@ func (m *model) String() string {
 func (m *model) Start() Time {
 	return m.start
 }
+
+func (m *model) Disturbances() *Disturbances {
+	return m.disturbances
+}
+
+func (m *model) Passengers() []Passenger {
+	return m.passengers
+}
+
+func (m *model) Demand() *Demand {
+	return m.demand
+}
@@ -2,22 +2,42 @@ package model
 
 import "fmt"
 
-func loadBuses(scenario scenario, lines map[LineId]Line) (map[BusId]Bus, error) {
+func loadBuses(scenario scenario, lines map[LineId]Line) (map[BusId]Bus, Problems) {
 	result := make(map[BusId]Bus)
+	problems := Problems{}
+	declaredLines := make(map[LineId]bool)
+	for _, line := range scenario.Lines {
+		declaredLines[LineId(line.Id)] = true
+	}
 	for _, scenBus := range scenario.Buses {
-		bus := Bus{Id: BusId(scenBus.Id)}
+		bus := Bus{Id: BusId(scenBus.Id), Doors: scenBus.Doors}
 		assignments := make([]Assignment, 0, len(scenBus.Assignments))
 		for _, asmgt := range scenBus.Assignments {
+			_, loaded := lines[LineId(asmgt.Line)]
+			if asmgt.Line != "" && !loaded && declaredLines[LineId(asmgt.Line)] {
+				// the line is declared, but could not be loaded; this problem has already been reported
+				continue
+			}
 			assignment, err := initAssignments(asmgt.Start, asmgt.Line, asmgt.Coordinates, lines)
 			if err != nil {
-				return nil, fmt.Errorf("could not load bus \"%s\": %v", bus.Id, err)
+				problems = append(problems, fmt.Errorf("could not load bus \"%s\": %v", bus.Id, err))
+				continue
 			}
 			assignments = append(assignments, *assignment)
 		}
+		if scenBus.Doors < 0 {
+			problems = append(problems, fmt.Errorf("bus \"%s\" has a negative number of doors", bus.Id))
+		}
+		if len(scenBus.Assignments) == 0 {
+			problems = append(problems, fmt.Errorf("bus \"%s\" has no assignments", bus.Id))
+		}
+		if len(assignments) == 0 {
+			continue
+		}
 		bus.Assignments = assignments
 		result[bus.Id] = bus
 	}
-	return result, nil
+	return result, problems
 }
 
 func initAssignments(rawStart string, line string, coordinates [][2]float64, lineMap map[LineId]Line) (*Assignment, error) {
@@ -36,7 +56,7 @@ func createLineAssignment(lineMap map[LineId]Line, rawLine string, start Time) (
 	assignment := Assignment{Departure: start}
 	line, ok := lineMap[LineId(rawLine)]
 	if !ok {
-		return nil, fmt.Errorf("line \"%s\" not found", line.Id)
+		return nil, fmt.Errorf("line \"%s\" not found", rawLine)
 	}
 	assignment.Line = &line
 	assignment.Name = line.Name
@@ -47,7 +67,7 @@ func createLineAssignment(lineMap map[LineId]Line, rawLine string, start Time) (
 	}
 	index := 0
 	for _, wp := range line.waypoints {
-		point := WayPoint{Id: wp.Id, Name: wp.Name, Latitude: wp.Latitude, Longitude: wp.Longitude}
+		point := WayPoint{Id: wp.Id, Name: wp.Name, Latitude: wp.Latitude, Longitude: wp.Longitude, Boarding: wp.Boarding, Alighting: wp.Alighting}
 		if wp.Id != nil {
 			point.Departure = departures[index]
 			index = index + 1
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type scenarioDemand struct {
+	Seed    *int64
+	Matrix  string
+	Zones   map[string][]string
+	Profile []float64
+}
+
+// loadDemand loads the origin-destination matrix referenced by the scenario. The first row of the matrix contains the
+// destinations, the first column the origins; the cells contain the passengers per hour. Origins and destinations are
+// either zones of the scenario or stop ids. Empty cells mean that nobody travels between the two.
+func loadDemand(scenario scenario, directory string, stops map[StopId]WayPoint) (*Demand, Problems) {
+	if scenario.Demand == nil {
+		return nil, nil
+	}
+	raw := scenario.Demand
+	problems := Problems{}
+	zones, zoneProblems := loadZones(raw.Zones, stops)
+	problems = append(problems, zoneProblems...)
+	result := &Demand{Seed: raw.Seed}
+	if raw.Profile == nil {
+		for hour := range result.Profile {
+			result.Profile[hour] = 1
+		}
+	} else if len(raw.Profile) != len(result.Profile) {
+		problems = append(problems, fmt.Errorf("the demand profile must contain %d hourly factors, but contained %d", len(result.Profile), len(raw.Profile)))
+	} else {
+		for hour, factor := range raw.Profile {
+			if factor < 0 {
+				problems = append(problems, fmt.Errorf("the factor of hour %d of the demand profile must not be negative, but was %v", hour, factor))
+			}
+			result.Profile[hour] = factor
+		}
+	}
+	if raw.Matrix == "" {
+		return nil, append(problems, fmt.Errorf("the demand does not reference an origin-destination matrix"))
+	}
+	file, err := os.Open(filepath.Join(directory, raw.Matrix))
+	if err != nil {
+		return nil, append(problems, fmt.Errorf("loading the demand from the referenced file \"%s\" failed: %v", raw.Matrix, err))
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, append(problems, fmt.Errorf("could not parse demand file \"%s\": %v", raw.Matrix, err))
+	}
+	if len(records) == 0 {
+		return nil, append(problems, fmt.Errorf("demand file \"%s\" is empty", raw.Matrix))
+	}
+	resolve := func(name string) ([]StopId, bool) {
+		if zone, ok := zones[name]; ok {
+			return zone, true
+		}
+		if _, ok := stops[StopId(name)]; ok {
+			return []StopId{StopId(name)}, true
+		}
+		return nil, false
+	}
+	destinations := make([][]StopId, len(records[0]))
+	for column, name := range records[0][1:] {
+		destination, ok := resolve(name)
+		if !ok {
+			problems = append(problems, fmt.Errorf("demand file \"%s\", column %d: could not find zone or stop \"%s\"", raw.Matrix, column+2, name))
+		}
+		destinations[column+1] = destination
+	}
+	for index, record := range records[1:] {
+		row := index + 2
+		origin, ok := resolve(record[0])
+		if !ok {
+			problems = append(problems, fmt.Errorf("demand file \"%s\", row %d: could not find zone or stop \"%s\"", raw.Matrix, row, record[0]))
+		}
+		for column := 1; column < len(record); column++ {
+			cell := strings.TrimSpace(record[column])
+			if cell == "" {
+				continue
+			}
+			rate, err := strconv.ParseFloat(cell, 64)
+			if err != nil || rate < 0 {
+				problems = append(problems, fmt.Errorf("demand file \"%s\", row %d, column %d: the passengers per hour must be a non-negative number, but were \"%s\"", raw.Matrix, row, column+1, cell))
+				continue
+			}
+			destination := destinations[column]
+			if rate == 0 || origin == nil || destination == nil {
+				continue
+			}
+			// the stops of the zones are distinct, thus only a single common stop leaves no one to travel
+			if len(origin) == 1 && len(destination) == 1 && origin[0] == destination[0] {
+				problems = append(problems, fmt.Errorf("demand file \"%s\", row %d, column %d: passengers cannot travel from stop \"%s\" to itself", raw.Matrix, row, column+1, origin[0]))
+				continue
+			}
+			result.Flows = append(result.Flows, Flow{Origin: origin, Destination: destination, Rate: rate})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return result, nil
+}
+
+// loadZones resolves the stops of the zones. Stops listed several times are only kept once. The problems are reported
+// in the order of the zone names.
+func loadZones(raw map[string][]string, stops map[StopId]WayPoint) (map[string][]StopId, Problems) {
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	problems := Problems{}
+	result := make(map[string][]StopId)
+	for _, name := range names {
+		if len(raw[name]) == 0 {
+			problems = append(problems, fmt.Errorf("the demand zone \"%s\" does not contain any stops", name))
+			continue
+		}
+		zone := make([]StopId, 0, len(raw[name]))
+		contained := make(map[StopId]bool)
+		for _, stop := range raw[name] {
+			if _, ok := stops[StopId(stop)]; !ok {
+				problems = append(problems, fmt.Errorf("the demand zone \"%s\" contains the unknown stop \"%s\"", name, stop))
+				continue
+			}
+			if !contained[StopId(stop)] {
+				contained[StopId(stop)] = true
+				zone = append(zone, StopId(stop))
+			}
+		}
+		result[name] = zone
+	}
+	return result, problems
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+type scenarioDisturbances struct {
+	Seed            *int64  `json:"seed"`
+	TravelTimeNoise float64 `json:"travelTimeNoise"`
+	DwellExtensions struct {
+		Probability float64 `json:"probability"`
+		Mean        float64 `json:"mean"`
+	} `json:"dwellExtensions"`
+	Incidents struct {
+		Probability float64 `json:"probability"`
+		MinDuration float64 `json:"minDuration"`
+		MaxDuration float64 `json:"maxDuration"`
+	} `json:"incidents"`
+}
+
+func loadDisturbances(scenario scenario) (*Disturbances, Problems) {
+	if scenario.Disturbances == nil {
+		return nil, nil
+	}
+	raw := scenario.Disturbances
+	problems := Problems{}
+	if raw.TravelTimeNoise < 0 {
+		problems = append(problems, fmt.Errorf("the travel time noise must not be negative, but was %v", raw.TravelTimeNoise))
+	}
+	probabilities := []struct {
+		name  string
+		value float64
+	}{{"dwell extensions", raw.DwellExtensions.Probability}, {"incidents", raw.Incidents.Probability}}
+	for _, probability := range probabilities {
+		if probability.value < 0 || probability.value > 1 {
+			problems = append(problems, fmt.Errorf("the probability of %s must be between 0 and 1, but was %v", probability.name, probability.value))
+		}
+	}
+	if raw.DwellExtensions.Mean < 0 {
+		problems = append(problems, fmt.Errorf("the mean dwell extension must not be negative, but was %v", raw.DwellExtensions.Mean))
+	}
+	if raw.Incidents.MinDuration < 0 || raw.Incidents.MaxDuration < raw.Incidents.MinDuration {
+		problems = append(problems, fmt.Errorf("the incident durations must satisfy 0 <= minDuration <= maxDuration, but were %v and %v", raw.Incidents.MinDuration, raw.Incidents.MaxDuration))
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	seconds := func(value float64) time.Duration {
+		return time.Duration(value * float64(time.Second))
+	}
+	return &Disturbances{
+		Seed:                      raw.Seed,
+		TravelTimeNoise:           raw.TravelTimeNoise,
+		DwellExtensionProbability: raw.DwellExtensions.Probability,
+		DwellExtensionMean:        seconds(raw.DwellExtensions.Mean),
+		IncidentProbability:       raw.Incidents.Probability,
+		IncidentMinDuration:       seconds(raw.Incidents.MinDuration),
+		IncidentMaxDuration:       seconds(raw.Incidents.MaxDuration),
+	}, nil
+}
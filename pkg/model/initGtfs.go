@@ -0,0 +1,412 @@
+package model
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// isGtfsFeed returns true if the path points to a GTFS zip archive or to a directory
+// containing a GTFS feed instead of a scenario.yaml.
+func isGtfsFeed(path string) bool {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(path, "scenario.yaml")); err == nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(path, "stops.txt"))
+	return err == nil
+}
+
+// gtfsOpener opens a single file of a GTFS feed, e.g. "stops.txt".
+type gtfsOpener func(name string) (io.ReadCloser, error)
+
+// loadGtfs loads the GTFS feed from the provided path. Like load, it collects all problems it encounters and leaves
+// the stops, trips, and routes that cannot be loaded out of the returned model. If the feed cannot be opened or
+// contains no usable trips, then the returned model is nil.
+// Besides the problems, loadGtfs returns the valid parts of the feed that the model does not support and that are
+// left out: stops without coordinates and the trips serving them, as well as trips visiting a stop twice.
+func loadGtfs(path string) (*model, Problems, Problems) {
+	open, closeFeed, err := openGtfsFeed(path)
+	if err != nil {
+		return nil, Problems{fmt.Errorf("could not open GTFS feed: %v", err)}, nil
+	}
+	defer closeFeed()
+	problems := Problems{}
+	stops, unlocated, skipped, stopProblems := loadGtfsStops(open)
+	for _, problem := range stopProblems {
+		problems = append(problems, fmt.Errorf("could not load stops.txt: %v", problem))
+	}
+	trips, skippedTrips, tripProblems := loadGtfsTrips(open, stops, unlocated)
+	skipped = append(skipped, skippedTrips...)
+	for _, problem := range tripProblems {
+		problems = append(problems, fmt.Errorf("could not load trips: %v", problem))
+	}
+	if len(trips) == 0 {
+		return nil, append(problems, fmt.Errorf("the feed does not contain any trips")), skipped
+	}
+	routes, err := readGtfsTable(open, "routes.txt")
+	if err != nil {
+		return nil, append(problems, fmt.Errorf("could not load routes.txt: %v", err)), skipped
+	}
+	lines, tripLines, lineProblems := createGtfsLines(routes, trips, stops)
+	for _, problem := range lineProblems {
+		problems = append(problems, fmt.Errorf("could not create lines: %v", problem))
+	}
+	buses, busProblems := createGtfsBuses(trips, tripLines, lines)
+	for _, problem := range busProblems {
+		problems = append(problems, fmt.Errorf("could not create buses: %v", problem))
+	}
+	start := trips[0].departures[0]
+	for _, trip := range trips {
+		if trip.departures[0].Before(start) {
+			start = trip.departures[0]
+		}
+	}
+	return &model{start: start, stops: stops, lines: lines, buses: buses}, problems, skipped
+}
+
+func openGtfsFeed(path string) (gtfsOpener, func(), error) {
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		opener := func(name string) (io.ReadCloser, error) {
+			return os.Open(filepath.Join(path, name))
+		}
+		return opener, func() {}, nil
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	opener := func(name string) (io.ReadCloser, error) {
+		for _, file := range archive.File {
+			if filepath.Base(file.Name) == name {
+				return file.Open()
+			}
+		}
+		return nil, fmt.Errorf("file \"%s\" not found in archive", name)
+	}
+	return opener, func() { _ = archive.Close() }, nil
+}
+
+// gtfsTable holds the records of a GTFS file and allows accessing columns by their header names.
+type gtfsTable struct {
+	columns map[string]int
+	records [][]string
+}
+
+func (g *gtfsTable) value(record []string, column string) string {
+	index, ok := g.columns[column]
+	if !ok || index >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[index])
+}
+
+func readGtfsTable(open gtfsOpener, name string) (*gtfsTable, error) {
+	file, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("file \"%s\" has no header", name)
+	}
+	columns := make(map[string]int)
+	for index, column := range records[0] {
+		columns[strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))] = index
+	}
+	return &gtfsTable{columns: columns, records: records[1:]}, nil
+}
+
+// loadGtfsStops loads the stops of the feed. Stops without coordinates are returned separately. Only generic nodes and
+// boarding areas (location types 3 and 4) may lack coordinates, thus other stops without coordinates are reported
+// as skipped.
+func loadGtfsStops(open gtfsOpener) (map[StopId]WayPoint, map[StopId]bool, Problems, Problems) {
+	result := make(map[StopId]WayPoint)
+	unlocated := make(map[StopId]bool)
+	table, err := readGtfsTable(open, "stops.txt")
+	if err != nil {
+		return result, unlocated, nil, Problems{err}
+	}
+	skipped := Problems{}
+	problems := Problems{}
+	for _, record := range table.records {
+		id := StopId(table.value(record, "stop_id"))
+		if table.value(record, "stop_lat") == "" && table.value(record, "stop_lon") == "" {
+			unlocated[id] = true
+			if locationType := table.value(record, "location_type"); locationType != "3" && locationType != "4" {
+				skipped = append(skipped, fmt.Errorf("stop \"%s\" has no coordinates and is left out", id))
+			}
+			continue
+		}
+		latitude, err := strconv.ParseFloat(table.value(record, "stop_lat"), 64)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("could not parse latitude of stop \"%s\": %v", id, err))
+			continue
+		}
+		longitude, err := strconv.ParseFloat(table.value(record, "stop_lon"), 64)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("could not parse longitude of stop \"%s\": %v", id, err))
+			continue
+		}
+		result[id] = WayPoint{Id: &id, Name: table.value(record, "stop_name"), Latitude: latitude, Longitude: longitude}
+	}
+	return result, unlocated, skipped, problems
+}
+
+type gtfsTrip struct {
+	id         string
+	routeId    string
+	headsign   string
+	direction  string
+	serviceId  string
+	blockId    string
+	stops      []StopId
+	departures []Time
+}
+
+func (g *gtfsTrip) pattern() string {
+	ids := make([]string, 0, len(g.stops))
+	for _, stop := range g.stops {
+		ids = append(ids, string(stop))
+	}
+	return g.routeId + "\x00" + g.direction + "\x00" + strings.Join(ids, "\x00")
+}
+
+type gtfsStopTime struct {
+	sequence  int
+	stop      StopId
+	departure Time
+}
+
+// loadGtfsTrips loads the trips of the feed together with their stop times. Trips with invalid stop times are left out
+// and reported as problems. Trips serving unlocated stops or visiting a stop twice are left out and reported as skipped.
+func loadGtfsTrips(open gtfsOpener, stops map[StopId]WayPoint, unlocated map[StopId]bool) ([]*gtfsTrip, Problems, Problems) {
+	tripTable, err := readGtfsTable(open, "trips.txt")
+	if err != nil {
+		return nil, nil, Problems{err}
+	}
+	stopTimeTable, err := readGtfsTable(open, "stop_times.txt")
+	if err != nil {
+		return nil, nil, Problems{err}
+	}
+	skipped := Problems{}
+	problems := Problems{}
+	stopTimes := make(map[string][]gtfsStopTime)
+	invalid := make(map[string]bool)
+	for _, record := range stopTimeTable.records {
+		tripId := stopTimeTable.value(record, "trip_id")
+		sequence, err := strconv.Atoi(stopTimeTable.value(record, "stop_sequence"))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("invalid stop_sequence in trip \"%s\": %v", tripId, err))
+			invalid[tripId] = true
+			continue
+		}
+		stopId := StopId(stopTimeTable.value(record, "stop_id"))
+		if unlocated[stopId] {
+			if !invalid[tripId] {
+				skipped = append(skipped, fmt.Errorf("trip \"%s\" serves stop \"%s\" without coordinates and is left out", tripId, stopId))
+			}
+			invalid[tripId] = true
+			continue
+		}
+		if _, ok := stops[stopId]; !ok {
+			problems = append(problems, fmt.Errorf("trip \"%s\" references unknown stop \"%s\"", tripId, stopId))
+			invalid[tripId] = true
+			continue
+		}
+		rawTime := stopTimeTable.value(record, "departure_time")
+		if rawTime == "" {
+			rawTime = stopTimeTable.value(record, "arrival_time")
+		}
+		departure, err := parseGtfsTime(rawTime)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("trip \"%s\" has an invalid time at stop \"%s\": %v", tripId, stopId, err))
+			invalid[tripId] = true
+			continue
+		}
+		stopTimes[tripId] = append(stopTimes[tripId], gtfsStopTime{sequence: sequence, stop: stopId, departure: departure})
+	}
+	result := make([]*gtfsTrip, 0, len(tripTable.records))
+trips:
+	for _, record := range tripTable.records {
+		trip := gtfsTrip{
+			id:        tripTable.value(record, "trip_id"),
+			routeId:   tripTable.value(record, "route_id"),
+			headsign:  tripTable.value(record, "trip_headsign"),
+			direction: tripTable.value(record, "direction_id"),
+			serviceId: tripTable.value(record, "service_id"),
+			blockId:   tripTable.value(record, "block_id"),
+		}
+		if invalid[trip.id] {
+			continue
+		}
+		times := stopTimes[trip.id]
+		if len(times) < 2 {
+			problems = append(problems, fmt.Errorf("trip \"%s\" must have at least two stop times", trip.id))
+			continue
+		}
+		sort.Slice(times, func(i, j int) bool {
+			return times[i].sequence < times[j].sequence
+		})
+		visited := make(map[StopId]bool)
+		for _, stopTime := range times {
+			if visited[stopTime.stop] {
+				skipped = append(skipped, fmt.Errorf("trip \"%s\" visits stop \"%s\" twice, which is not supported, and is left out", trip.id, stopTime.stop))
+				continue trips
+			}
+			visited[stopTime.stop] = true
+			trip.stops = append(trip.stops, stopTime.stop)
+			trip.departures = append(trip.departures, stopTime.departure)
+		}
+		result = append(result, &trip)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].departures[0].Before(result[j].departures[0])
+	})
+	return result, skipped, problems
+}
+
+var gtfsTimeRegex = regexp.MustCompile("^([0-9]+):([0-5][0-9]):([0-5][0-9])$")
+
+func parseGtfsTime(timeString string) (Time, error) {
+	subMatch := gtfsTimeRegex.FindStringSubmatch(timeString)
+	if subMatch == nil {
+		return 0, fmt.Errorf("the string \"%s\" does not match the format hh:mm:ss", timeString)
+	}
+	hour, _ := strconv.Atoi(subMatch[1])
+	minute, _ := strconv.Atoi(subMatch[2])
+	second, _ := strconv.Atoi(subMatch[3])
+	return Time(((hour*60+minute)*60 + second) * 1000), nil
+}
+
+// createGtfsLines creates one line for every distinct stop pattern of a route. If a route has
+// more than one pattern (e.g. inbound and outbound trips), then the line ids are suffixed with a counter.
+// Trips of routes that are not defined get no line.
+func createGtfsLines(routes *gtfsTable, trips []*gtfsTrip, stops map[StopId]WayPoint) (map[LineId]Line, map[string]LineId, Problems) {
+	patterns := make(map[string][]*gtfsTrip)
+	patternsOfRoute := make(map[string][]string)
+	for _, trip := range trips {
+		pattern := trip.pattern()
+		if _, ok := patterns[pattern]; !ok {
+			patternsOfRoute[trip.routeId] = append(patternsOfRoute[trip.routeId], pattern)
+		}
+		patterns[pattern] = append(patterns[pattern], trip)
+	}
+	result := make(map[LineId]Line)
+	tripLines := make(map[string]LineId)
+	index := 0
+	for _, record := range routes.records {
+		routeId := routes.value(record, "route_id")
+		shortName := routes.value(record, "route_short_name")
+		longName := routes.value(record, "route_long_name")
+		color := ""
+		if rawColor := routes.value(record, "route_color"); rawColor != "" {
+			color = "#" + rawColor
+		}
+		for patternIndex, pattern := range patternsOfRoute[routeId] {
+			patternTrips := patterns[pattern]
+			id := LineId(routeId)
+			if len(patternsOfRoute[routeId]) > 1 {
+				id = LineId(fmt.Sprintf("%s-%d", routeId, patternIndex+1))
+			}
+			name := longName
+			if shortName != "" && patternTrips[0].headsign != "" {
+				name = shortName + " " + patternTrips[0].headsign
+			} else if name == "" {
+				name = shortName
+			}
+			line := Line{Id: id, Name: name, Color: color, DefinitionIndex: index, departures: make(map[StopId][]Time)}
+			for _, stopId := range patternTrips[0].stops {
+				stop := stops[stopId]
+				line.waypoints = append(line.waypoints, &stop)
+			}
+			for _, trip := range patternTrips {
+				for stopIndex, stop := range trip.stops {
+					line.departures[stop] = append(line.departures[stop], trip.departures[stopIndex])
+				}
+				tripLines[trip.id] = id
+			}
+			result[id] = line
+			index = index + 1
+		}
+		delete(patternsOfRoute, routeId)
+	}
+	undefined := make([]string, 0, len(patternsOfRoute))
+	for routeId := range patternsOfRoute {
+		undefined = append(undefined, routeId)
+	}
+	sort.Strings(undefined)
+	problems := Problems{}
+	for _, routeId := range undefined {
+		problems = append(problems, fmt.Errorf("route \"%s\" is used by trips but not defined in routes.txt", routeId))
+	}
+	return result, tripLines, problems
+}
+
+// createGtfsBuses creates one bus for each block. Trips without a block id are served by a bus of their own.
+// Since calendars are not evaluated, a block whose trips belong to several services becomes one bus per service, whose
+// id is suffixed with the service id. Buses whose assignments still overlap are reported and left out.
+// Trips without line are skipped, since their routes have already been reported by createGtfsLines.
+func createGtfsBuses(trips []*gtfsTrip, tripLines map[string]LineId, lines map[LineId]Line) (map[BusId]Bus, Problems) {
+	services := make(map[string]map[string]bool)
+	for _, trip := range trips {
+		if services[trip.blockId] == nil {
+			services[trip.blockId] = make(map[string]bool)
+		}
+		services[trip.blockId][trip.serviceId] = true
+	}
+	result := make(map[BusId]Bus)
+	problems := Problems{}
+	for _, trip := range trips {
+		line, ok := tripLines[trip.id]
+		if !ok {
+			continue
+		}
+		id := BusId(trip.blockId)
+		if id == "" {
+			id = BusId(trip.id)
+		} else if len(services[trip.blockId]) > 1 {
+			id = BusId(trip.blockId + "-" + trip.serviceId)
+		}
+		assignment, err := createLineAssignment(lines, string(line), trip.departures[0])
+		if err != nil {
+			problems = append(problems, fmt.Errorf("could not assign trip \"%s\" to bus \"%s\": %v", trip.id, id, err))
+			continue
+		}
+		bus := result[id]
+		bus.Id = id
+		bus.Assignments = append(bus.Assignments, *assignment)
+		result[id] = bus
+	}
+	ids := make([]string, 0, len(result))
+	for id := range result {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		overlaps := validateBus(result[BusId(id)])
+		for _, problem := range overlaps {
+			problems = append(problems, fmt.Errorf("bus \"%s\": %v", id, problem))
+		}
+		if len(overlaps) > 0 {
+			delete(result, BusId(id))
+		}
+	}
+	return result, problems
+}
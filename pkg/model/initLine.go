@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -10,12 +11,16 @@ import (
 	"time"
 )
 
-func loadLines(scenario scenario, directory string, stops map[StopId]WayPoint) (map[LineId]Line, error) {
+func loadLines(scenario scenario, directory string, stops map[StopId]WayPoint) (map[LineId]Line, Problems) {
 	result := make(map[LineId]Line)
+	problems := Problems{}
 	for index, line := range scenario.Lines {
-		loadedLine, err := loadLineFromFile(filepath.Join(directory, line.File), stops)
-		if err != nil {
-			return nil, fmt.Errorf("could not parse line \"%s\": %v", line.Id, err)
+		loadedLine, lineProblems := loadLineFromFile(filepath.Join(directory, line.File), stops)
+		for _, problem := range lineProblems {
+			problems = append(problems, fmt.Errorf("could not parse line \"%s\": %v", line.Id, problem))
+		}
+		if len(lineProblems) > 0 {
+			continue
 		}
 		loadedLine.Id = LineId(line.Id)
 		loadedLine.Name = line.Name
@@ -23,13 +28,13 @@ func loadLines(scenario scenario, directory string, stops map[StopId]WayPoint) (
 		loadedLine.DefinitionIndex = index
 		result[loadedLine.Id] = *loadedLine
 	}
-	return result, nil
+	return result, problems
 }
 
-func loadLineFromFile(filePath string, stops map[StopId]WayPoint) (*Line, error) {
+func loadLineFromFile(filePath string, stops map[StopId]WayPoint) (*Line, Problems) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("loading line file failed: %v", err)
+		return nil, Problems{fmt.Errorf("loading line file failed: %v", err)}
 	}
 	defer file.Close()
 	reader := csv.NewReader(file)
@@ -37,7 +42,26 @@ func loadLineFromFile(filePath string, stops map[StopId]WayPoint) (*Line, error)
 	reader.LazyQuotes = true
 	stopList := make([]*WayPoint, 0, 0)
 	departureMap := make(map[StopId][]Time)
-	for data, err := reader.Read(); err == nil; data, err = reader.Read() {
+	problems := Problems{}
+	row := 0
+	for {
+		data, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row = row + 1
+		if _, ok := err.(*csv.ParseError); ok {
+			problems = append(problems, fmt.Errorf("row %d: %v", row, err))
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("row %d: could not read line file: %v", row, err))
+			break
+		}
+		if len(data) < 3 {
+			problems = append(problems, fmt.Errorf("row %d: expected at least three columns, but got %d", row, len(data)))
+			continue
+		}
 		if ok, wayPointOnly := isEntryWaypointOnly(data); ok {
 			stopList = append(stopList, wayPointOnly)
 			continue
@@ -45,25 +69,29 @@ func loadLineFromFile(filePath string, stops map[StopId]WayPoint) (*Line, error)
 		stopId := StopId(data[1])
 		stop, ok := stops[stopId]
 		if !ok {
-			return nil, fmt.Errorf("could not find stop \"%s\"", stopId)
+			problems = append(problems, fmt.Errorf("row %d: could not find stop \"%s\"", row, stopId))
 		}
 		if stop.Name == "" {
 			stop.Name = data[0]
 		}
 		stopList = append(stopList, &stop)
-		departures, err := createDepartures(data)
-		if err != nil {
-			return nil, fmt.Errorf("could not parse departures: %v", err)
+		departures, departureProblems := createDepartures(data)
+		for _, problem := range departureProblems {
+			problems = append(problems, fmt.Errorf("row %d: could not parse departures: %v", row, problem))
 		}
 		departureMap[stopId] = departures
 	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
 	return &Line{waypoints: stopList, departures: departureMap}, nil
 }
 
-func createDepartures(csvLine []string) ([]Time, error) {
+func createDepartures(csvLine []string) ([]Time, Problems) {
+	problems := Problems{}
 	firstTime, err := ParseTime(csvLine[2])
 	if err != nil {
-		return nil, fmt.Errorf("third column must be in time format hh:mm, but was \"%s\"", csvLine[2])
+		problems = append(problems, fmt.Errorf("third column must be in time format hh:mm, but was \"%s\"", csvLine[2]))
 	}
 	result := make([]Time, 0, len(csvLine))
 	result = append(result, firstTime)
@@ -76,11 +104,13 @@ func createDepartures(csvLine []string) ([]Time, error) {
 			interval := time.Duration(minutes) * time.Minute
 			currentInterval = &interval
 			if index+4 > len(csvLine)-1 {
-				return nil, fmt.Errorf("an interval column must be followed by an absolute time colum")
+				problems = append(problems, fmt.Errorf("an interval column must be followed by an absolute time colum"))
+				continue
 			}
 			nextAbsoluteTime, err := ParseTime(csvLine[index+4])
 			if err != nil {
-				return nil, fmt.Errorf("column %d is a interval column, but is not succeeded by a valid absolute time column", index+3)
+				problems = append(problems, fmt.Errorf("column %d is a interval column, but is not succeeded by a valid absolute time column", index+3))
+				continue
 			}
 			nextTime := result[len(result)-1].Add(*currentInterval)
 			for ok := true; ok; ok = nextTime.Before(nextAbsoluteTime) {
@@ -90,12 +120,13 @@ func createDepartures(csvLine []string) ([]Time, error) {
 		} else {
 			parsed, err := ParseTime(departureTime)
 			if err != nil {
-				return nil, fmt.Errorf("column %d with content \"%s\" is neither a valid time column nor an interval column", index+3, departureTime)
+				problems = append(problems, fmt.Errorf("column %d with content \"%s\" is neither a valid time column nor an interval column", index+3, departureTime))
+				continue
 			}
 			result = append(result, parsed)
 		}
 	}
-	return result, nil
+	return result, problems
 }
 
 func isEntryWaypointOnly(csv []string) (bool, *WayPoint) {
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// loadPassengers loads the passengers from the CSV file referenced by the scenario. The file has a header row and the
+// columns time, origin, and destination, where origin and destination are stop ids.
+func loadPassengers(scenario scenario, directory string, stops map[StopId]WayPoint) ([]Passenger, Problems) {
+	if scenario.Passengers == "" {
+		return nil, nil
+	}
+	file, err := os.Open(filepath.Join(directory, scenario.Passengers))
+	if err != nil {
+		return nil, Problems{fmt.Errorf("loading the passengers from the referenced file \"%s\" failed: %v", scenario.Passengers, err)}
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, Problems{fmt.Errorf("could not parse passenger file \"%s\": %v", scenario.Passengers, err)}
+	}
+	problems := Problems{}
+	result := make([]Passenger, 0, len(records))
+	for index, record := range records {
+		if index == 0 {
+			continue
+		}
+		appearance, err := ParseTime(record[0])
+		if err != nil {
+			problems = append(problems, fmt.Errorf("passenger file \"%s\", row %d: %v", scenario.Passengers, index+1, err))
+			continue
+		}
+		passenger := Passenger{Appearance: appearance, Origin: StopId(record[1]), Destination: StopId(record[2])}
+		for _, stop := range []StopId{passenger.Origin, passenger.Destination} {
+			if _, ok := stops[stop]; !ok {
+				problems = append(problems, fmt.Errorf("passenger file \"%s\", row %d: could not find stop \"%s\"", scenario.Passengers, index+1, stop))
+			}
+		}
+		result = append(result, passenger)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Appearance.Before(result[j].Appearance)
+	})
+	return result, problems
+}
@@ -1,9 +1,13 @@
 package model
 
 import (
+	"archive/zip"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestInit(t *testing.T) {
@@ -14,13 +18,14 @@ func TestInit(t *testing.T) {
 	bus2, _ := mdl.Bus(BusId("V2"))
 	assert.Equal(t, BusId("V2"), bus2.Id, "Id of the first bus")
 	assert.Equal(t, "", bus2.Name, "Name of the first bus")
+	assert.Equal(t, 2, bus2.Doors, "doors of the first bus")
 	require.Equal(t, 2, len(bus2.Assignments), "number of assignments of the first bus")
 	assignment := bus2.Assignments[1]
 	assert.Equal(t, "Busbahnhof - Residenz - Sanderau", assignment.Name, "Name of assignment")
 	line := assignment.Line
 	assert.Equal(t, 11, len(assignment.WayPoints), "number of waypoints in the line")
 	id := StopId("node/248513451")
-	assert.Equal(t, WayPoint{Departure: 24000000, Id: &id, Name: "Mainfranken Theater", Latitude: 49.7947734, Longitude: 9.9360743}, assignment.WayPoints[3], "sample waypoint")
+	assert.Equal(t, WayPoint{Departure: 24000000, Id: &id, Name: "Mainfranken Theater", Latitude: 49.7947734, Longitude: 9.9360743, Boarding: 12, Alighting: 3}, assignment.WayPoints[3], "sample waypoint")
 
 	assert.Equal(t, assignment.Name, line.Name, "name of the line")
 	assert.Equal(t, 11, len(line.waypoints), "number of stops in the line")
@@ -30,4 +35,128 @@ func TestInit(t *testing.T) {
 	assert.Equal(t, "custom waypoint assignment", assignment.Name, "name of assignment")
 	assert.Equal(t, 2, len(assignment.WayPoints), "number of waypoints")
 	assert.Equal(t, WayPoint{Departure: 0, Id: nil, Name: "custom waypoint", Latitude: 49.8012835, Longitude: 9.9340999}, assignment.WayPoints[1], "second waypoint")
+
+	seed := int64(42)
+	expected := Disturbances{
+		Seed:                      &seed,
+		TravelTimeNoise:           0.1,
+		DwellExtensionProbability: 0.2,
+		DwellExtensionMean:        15 * time.Second,
+		IncidentProbability:       0.01,
+		IncidentMinDuration:       2 * time.Minute,
+		IncidentMaxDuration:       10 * time.Minute,
+	}
+	assert.Equal(t, &expected, mdl.Disturbances(), "disturbances of the scenario")
+
+	vogelVerlag := StopId("node/600918135")
+	assert.Equal(t, []Passenger{
+		{Appearance: MustParseTime("6:16"), Origin: id, Destination: vogelVerlag},
+		{Appearance: MustParseTime("6:20"), Origin: id, Destination: vogelVerlag},
+	}, mdl.Passengers(), "passengers sorted by their appearance")
+
+	require.NotNil(t, mdl.Demand(), "demand of the scenario")
+	demandSeed := int64(7)
+	assert.Equal(t, &demandSeed, mdl.Demand().Seed, "seed of the demand")
+	// the scenario lists the Mainfranken Theater twice
+	city := []StopId{id, "node/535359494"}
+	assert.Equal(t, []Flow{
+		{Origin: city, Destination: city, Rate: 5},
+		{Origin: city, Destination: []StopId{vogelVerlag}, Rate: 10},
+		{Origin: []StopId{vogelVerlag}, Destination: city, Rate: 2.5},
+	}, mdl.Demand().Flows, "flows of the demand")
+	assert.Equal(t, 1.5, mdl.Demand().Profile[8], "factor of the hour from 8:00 to 9:00")
+	assert.Equal(t, 0.0, mdl.Demand().Profile[23], "factor of the last hour")
+}
+
+func TestInit_Gtfs(t *testing.T) {
+	mdl, err := Init("./testdata/gtfs")
+	require.NoError(t, err, "no error expected")
+	assert.Equal(t, MustParseTime("6:15"), mdl.Start(), "start of the scenario")
+	require.Equal(t, 2, len(mdl.Lines()), "number of lines")
+
+	outbound, ok := mdl.Line("A-1")
+	require.True(t, ok, "outbound line should exist")
+	assert.Equal(t, "A Residenz", outbound.Name, "name of the line")
+	assert.Equal(t, "#801818", outbound.Color, "color of the line")
+	assert.Equal(t, 4, len(outbound.Stops()), "number of stops")
+	assert.Equal(t, []Time{MustParseTime("6:15"), MustParseTime("6:35")}, outbound.StartTimes(), "start times")
+	assert.Equal(t, MustParseTime("6:20").Add(30*time.Second), outbound.TourTimes(MustParseTime("6:15"))[2], "departure at the third stop")
+
+	inbound, ok := mdl.Line("A-2")
+	require.True(t, ok, "inbound line should exist")
+	assert.Equal(t, "A Busbahnhof", inbound.Name, "name of the line")
+
+	require.Equal(t, 2, len(mdl.Buses()), "number of buses")
+	block, ok := mdl.Bus("B1")
+	require.True(t, ok, "bus of block should exist")
+	require.Equal(t, 2, len(block.Assignments), "number of assignments of the block")
+	assert.Equal(t, LineId("A-1"), block.Assignments[0].Line.Id, "line of the first assignment")
+	assert.Equal(t, LineId("A-2"), block.Assignments[1].Line.Id, "line of the second assignment")
+	assert.Equal(t, MustParseTime("6:37"), block.Assignments[1].WayPoints[3].Departure, "departure at last stop")
+
+	single, ok := mdl.Bus("A-out-0635")
+	require.True(t, ok, "trip without block should get an own bus")
+	assert.Equal(t, MustParseTime("6:35"), single.Assignments[0].Departure, "departure of the assignment")
+	assert.Nil(t, mdl.Disturbances(), "GTFS feeds have no disturbances")
+	assert.Nil(t, mdl.Demand(), "GTFS feeds have no demand")
+}
+
+func TestInit_GtfsZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feed.zip")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	archive := zip.NewWriter(file)
+	names, err := filepath.Glob("./testdata/gtfs/*.txt")
+	require.NoError(t, err)
+	for _, name := range names {
+		content, err := os.ReadFile(name)
+		require.NoError(t, err)
+		writer, err := archive.Create(filepath.Base(name))
+		require.NoError(t, err)
+		_, err = writer.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	require.NoError(t, file.Close())
+
+	mdl, err := Init(path)
+	require.NoError(t, err, "no error expected")
+	expected, err := Init("./testdata/gtfs")
+	require.NoError(t, err)
+	assert.Equal(t, expected.Start(), mdl.Start(), "start of the scenario")
+	assert.ElementsMatch(t, expected.Lines(), mdl.Lines(), "lines of the zipped feed")
+	assert.ElementsMatch(t, expected.Buses(), mdl.Buses(), "buses of the zipped feed")
+
+	_, err = Init(filepath.Join(t.TempDir(), "missing.zip"))
+	assert.Error(t, err, "missing archive")
+}
+
+func TestInit_GtfsUnsupported(t *testing.T) {
+	mdl, err := Init("./testdata/gtfsUnsupported")
+	require.NoError(t, err, "unsupported trips and stops should be left out")
+	_, ok := mdl.Stop("node/1")
+	assert.False(t, ok, "stop without coordinates should be left out")
+	_, ok = mdl.Bus("A-loop-0700")
+	assert.False(t, ok, "trip visiting a stop twice should be left out")
+	_, ok = mdl.Bus("A-nowhere-0800")
+	assert.False(t, ok, "trip serving a stop without coordinates should be left out")
+
+	_, ok = mdl.Bus("B1")
+	assert.False(t, ok, "block of several services should be split")
+	daily, ok := mdl.Bus("B1-daily")
+	require.True(t, ok, "bus of the daily service")
+	assert.Equal(t, 2, len(daily.Assignments), "assignments of the daily service")
+	sunday, ok := mdl.Bus("B1-sunday")
+	require.True(t, ok, "bus of the sunday service")
+	require.Equal(t, 1, len(sunday.Assignments), "assignments of the sunday service")
+	assert.Equal(t, MustParseTime("6:20"), sunday.Assignments[0].Departure, "departure of the sunday trip")
+}
+
+func TestInit_Invalid(t *testing.T) {
+	mdl, err := Init("./testdata/invalid")
+	assert.Nil(t, mdl, "model should be nil")
+	require.Error(t, err, "error expected")
+	problems, ok := err.(Problems)
+	require.True(t, ok, "error should contain all problems")
+	assert.Equal(t, 15, len(problems), "number of problems")
 }
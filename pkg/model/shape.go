@@ -0,0 +1,148 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Shape is the geometry of a sequence of way points, e.g. of a line. Shapes are resolved once with a RouteService
+// (see ResolveShapes), thus the route service does not need to be queried while simulating.
+type Shape struct {
+	// Segments contains the routes between consecutive way points, i.e. Segments[i] leads from way point i to way point i+1.
+	Segments [][]Coordinate
+	// Distance is the length of the shape in meters, as reported by the route service.
+	Distance float64
+}
+
+// Coordinates returns the whole geometry of the shape. Coordinates that are shared by two consecutive segments are
+// contained only once.
+func (s *Shape) Coordinates() []Coordinate {
+	result := make([]Coordinate, 0)
+	for _, segment := range s.Segments {
+		for index, coordinate := range segment {
+			if index == 0 && len(result) > 0 && sameCoordinate(result[len(result)-1], coordinate) {
+				continue
+			}
+			result = append(result, coordinate)
+		}
+	}
+	return result
+}
+
+func sameCoordinate(c Coordinate, other Coordinate) bool {
+	return c.Lat() == other.Lat() && c.Lon() == other.Lon()
+}
+
+type segment struct {
+	route    []Coordinate
+	distance float64
+}
+
+// shapeResolver queries the routes of shapes segment by segment and caches them. Since lines
+// often share stops, many segments are only queried once.
+type shapeResolver struct {
+	gps      RouteService
+	segments map[[4]float64]segment
+}
+
+func (s *shapeResolver) resolve(waypoints []WayPoint) (*Shape, error) {
+	shape := Shape{Segments: make([][]Coordinate, 0, len(waypoints))}
+	for index := 1; index < len(waypoints); index++ {
+		from := waypoints[index-1]
+		to := waypoints[index]
+		key := [4]float64{from.Latitude, from.Longitude, to.Latitude, to.Longitude}
+		cached, ok := s.segments[key]
+		if !ok {
+			route, distance, err := s.gps(from, to)
+			if err != nil {
+				return nil, fmt.Errorf("could not query route from \"%s\" to \"%s\": %v", from.Name, to.Name, err)
+			}
+			cached = segment{route: route, distance: distance}
+			s.segments[key] = cached
+		}
+		shape.Segments = append(shape.Segments, cached.route)
+		shape.Distance = shape.Distance + cached.distance
+	}
+	return &shape, nil
+}
+
+// ResolveShapes returns a copy of the model whose lines and assignments contain their shapes, which are queried once
+// with the given route service, such that they need not be queried while simulating. Additionally, the approach of
+// every assignment, i.e. the route from the end of the previous assignment to the first way point, is resolved. All
+// problems are reported at once; lines and assignments whose shape could not be resolved keep no shape. The given
+// model is not changed. Only models created by Init can be resolved.
+func ResolveShapes(mdl Model, gps RouteService) (Model, Problems) {
+	original, ok := mdl.(*model)
+	if !ok {
+		return mdl, Problems{fmt.Errorf("could not resolve shapes of model of type %T", mdl)}
+	}
+	result := *original
+	result.lines = make(map[LineId]Line, len(original.lines))
+	for id, line := range original.lines {
+		result.lines[id] = line
+	}
+	result.buses = make(map[BusId]Bus, len(original.buses))
+	for id, bus := range original.buses {
+		bus.Assignments = append([]Assignment{}, bus.Assignments...)
+		result.buses[id] = bus
+	}
+	return &result, result.resolveShapes(gps)
+}
+
+func (m *model) resolveShapes(gps RouteService) Problems {
+	resolver := shapeResolver{gps: gps, segments: make(map[[4]float64]segment)}
+	problems := Problems{}
+	lines := m.Lines()
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].DefinitionIndex < lines[j].DefinitionIndex
+	})
+	for _, line := range lines {
+		waypoints := make([]WayPoint, 0, len(line.waypoints))
+		for _, waypoint := range line.waypoints {
+			waypoints = append(waypoints, *waypoint)
+		}
+		shape, err := resolver.resolve(waypoints)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line \"%s\": %v", line.Id, err))
+			continue
+		}
+		line.shape = shape
+		m.lines[line.Id] = line
+	}
+	buses := m.Buses()
+	sort.Slice(buses, func(i, j int) bool {
+		return buses[i].Id < buses[j].Id
+	})
+	for _, bus := range buses {
+		for index := range bus.Assignments {
+			// the assignments share their backing array with the buses of the model
+			assignment := &bus.Assignments[index]
+			if len(assignment.WayPoints) == 0 {
+				continue
+			}
+			if assignment.Line != nil {
+				line := m.lines[assignment.Line.Id]
+				assignment.Line = &line
+				assignment.Shape = line.shape
+			} else {
+				shape, err := resolver.resolve(assignment.WayPoints)
+				if err != nil {
+					problems = append(problems, fmt.Errorf("bus \"%s\", assignment %d: %v", bus.Id, index+1, err))
+				}
+				assignment.Shape = shape
+			}
+			from := assignment.WayPoints[0]
+			if index > 0 && len(bus.Assignments[index-1].WayPoints) > 0 {
+				previous := bus.Assignments[index-1].WayPoints
+				from = previous[len(previous)-1]
+			}
+			approach, err := resolver.resolve([]WayPoint{from, assignment.WayPoints[0]})
+			if err != nil {
+				problems = append(problems, fmt.Errorf("bus \"%s\", approach of assignment %d: %v", bus.Id, index+1, err))
+				continue
+			}
+			assignment.Approach = approach.Segments[0]
+		}
+	}
+	return problems
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestResolveShapes(t *testing.T) {
+	loaded, err := Init("testdata/wuerzburg(fictional)")
+	require.NoError(t, err)
+	gps := func(coordinates ...Coordinate) ([]Coordinate, float64, error) {
+		return coordinates, 100, nil
+	}
+	mdl, problems := ResolveShapes(loaded, gps)
+	require.Empty(t, problems, "no problems expected")
+	unresolved, _ := loaded.Line("A-inbound")
+	assert.Nil(t, unresolved.Shape(), "the given model should not be changed")
+	unresolvedBus, _ := loaded.Bus("V2")
+	assert.Nil(t, unresolvedBus.Assignments[1].Shape, "the assignments of the given model should not be changed")
+
+	line, _ := mdl.Line("A-inbound")
+	shape := line.Shape()
+	require.NotNil(t, shape, "shape of the line")
+	assert.Equal(t, len(line.WayPoints())-1, len(shape.Segments), "number of segments")
+	assert.Equal(t, float64(100*len(shape.Segments)), shape.Distance, "distance of the shape")
+	assert.Equal(t, len(line.WayPoints()), len(shape.Coordinates()), "shared coordinates should be contained once")
+	assert.Equal(t, line.WayPoints()[3].Lat(), shape.Segments[3][0].Lat(), "start of a segment")
+
+	bus, _ := mdl.Bus("V2")
+	assignment := bus.Assignments[1]
+	assert.Equal(t, assignment.Line.Shape(), assignment.Shape, "assignment should reuse the shape of its line")
+	previous := bus.Assignments[0].WayPoints
+	require.Equal(t, 2, len(assignment.Approach), "length of the approach")
+	assert.Equal(t, previous[len(previous)-1].Lat(), assignment.Approach[0].Lat(), "approach should start at the end of the previous assignment")
+	assert.Equal(t, assignment.WayPoints[0].Lat(), assignment.Approach[1].Lat(), "approach should end at the first way point")
+}
+
+func TestResolveShapes_Problems(t *testing.T) {
+	loaded, err := Init("testdata/wuerzburg(fictional)")
+	require.NoError(t, err)
+	gps := func(coordinates ...Coordinate) ([]Coordinate, float64, error) {
+		return nil, 0, fmt.Errorf("service unavailable")
+	}
+	mdl, problems := ResolveShapes(loaded, gps)
+	require.NotEmpty(t, problems, "problems expected")
+	assert.Contains(t, problems[0].Error(), "service unavailable", "first problem")
+	line, _ := mdl.Line("A-inbound")
+	assert.Nil(t, line.Shape(), "shape of the line should not be resolved")
+}
+
+func TestShapeResolver_Cache(t *testing.T) {
+	queries := 0
+	resolver := shapeResolver{gps: func(coordinates ...Coordinate) ([]Coordinate, float64, error) {
+		queries = queries + 1
+		return coordinates, 100, nil
+	}, segments: make(map[[4]float64]segment)}
+	a := WayPoint{Latitude: 49.1, Longitude: 9.1}
+	b := WayPoint{Latitude: 49.2, Longitude: 9.2}
+	c := WayPoint{Latitude: 49.3, Longitude: 9.3}
+	_, err := resolver.resolve([]WayPoint{a, b, c})
+	require.NoError(t, err)
+	shape, err := resolver.resolve([]WayPoint{b, c, b})
+	require.NoError(t, err)
+	assert.Equal(t, 3, queries, "shared segments should be queried only once")
+	assert.Equal(t, 200.0, shape.Distance, "distance of the second shape")
+	assert.Equal(t, []Coordinate{b, c, b}, shape.Coordinates(), "coordinates of the second shape")
+}
@@ -15,6 +15,9 @@ type Bus struct {
 	Id          BusId
 	Name        string
 	Assignments []Assignment
+	// Doors is the number of doors the passengers use for boarding and alighting. It is 0 if the scenario
+	// does not specify the door configuration of the bus.
+	Doors int
 }
 
 // Assignment is a task for a Bus to do.
@@ -23,6 +26,11 @@ type Assignment struct {
 	Line      *Line
 	Departure Time
 	WayPoints []WayPoint
+	// Shape is the geometry of the way points of the assignment. It is nil if the shapes of the model have not been resolved.
+	Shape *Shape
+	// Approach is the route from the end of the previous assignment (or from the first way point if there is no
+	// previous assignment) to the first way point. It is nil if the shapes of the model have not been resolved.
+	Approach []Coordinate
 }
 
 // WayPoint is a part of an assignment.
@@ -32,6 +40,10 @@ type WayPoint struct {
 	Name      string
 	Latitude  float64
 	Longitude float64
+	// Boarding is the number of passengers boarding every bus that serves the stop.
+	Boarding int
+	// Alighting is the number of passengers alighting from every bus that serves the stop.
+	Alighting int
 }
 
 func (w WayPoint) Lat() float64 {
@@ -48,21 +60,159 @@ type Coordinate interface {
 	Lon() float64
 }
 
+// SegmentSpeedAnnotated is implemented by coordinates of routes that know the typical speed on the segment leading to
+// them, such as the routes of OSRM. This is an average speed derived from the road, not a legal speed limit.
+type SegmentSpeedAnnotated interface {
+	// SegmentSpeed returns the average speed on the segment in km/h, or 0 if the speed is unknown.
+	SegmentSpeed() float64
+}
+
 // RouteService is a function capable of computing detailed waypoints between the provided waypoints.
 type RouteService func(...Coordinate) ([]Coordinate, float64, error)
 
 // BusPosition describes the position of a certain bus at the current moment. BusPosition is meant
-// to be sent to subscribers, possible over network. Thus, we keep this struct small.
+// to be sent to subscribers, possible over network. Thus, we keep this struct small. Changes of the fields
+// must be reflected in the SchemaVersion.
 type BusPosition struct {
 	BusId     BusId      `json:"id"`
+	LineId    LineId     `json:"lineId,omitempty"`
 	Location  [2]float64 `json:"loc"`
 	StopId    *StopId    `json:"stopId,omitempty"`
 	Departure Time       `json:"departure,omitempty"`
+	// Heading is the direction of travel in degrees, measured clockwise from north.
+	Heading float64 `json:"heading"`
+	// Speed is the current speed in km/h.
+	Speed float64 `json:"speed"`
+	// Assignment is the index of the assignment the bus is serving or heading for.
+	Assignment int `json:"assignment"`
+	// NextStopId is the next stop the bus will arrive at within the assignment.
+	NextStopId *StopId `json:"nextStopId,omitempty"`
+	// Delay is the deviation from the timetable in seconds, negative if the bus is early.
+	Delay int `json:"delay"`
+	// Distance is the distance in meters the bus has driven since the first way point of the assignment.
+	Distance float64 `json:"distance"`
+	// Occupancy is the number of passengers on the bus.
+	Occupancy int `json:"occupancy"`
+}
+
+// SchemaVersion is the version of the messages streamed to clients, e.g. BusPosition and Event. It is increased
+// whenever fields are added to, changed in, or removed from the messages. Clients are informed about the version
+// with a Schema message when connecting.
+//
+// Version 2 added heading, speed, assignment, next stop, delay, and distance to BusPosition.
+// Version 3 added the line to Event and allows negative delays in BusPosition.
+// Version 4 added the assignment to Event as well as the assignment and deadhead events.
+// Version 5 added the boarding and alighting passengers to Event.
+// Version 6 added the seed to Event as well as the run and incident events. The run event has no bus id.
+// Version 7 added the demand seed to Event.
+// Version 8 added the occupancy to BusPosition.
+// Version 9 added the removal message, which tells clients to stop showing a bus.
+const SchemaVersion = 9
+
+// Schema announces the SchemaVersion to clients.
+type Schema struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
 }
 
 // Publisher is a function taking care to broadcast BusPosition updates.
 type Publisher func(position BusPosition)
 
+// Passenger describes a passenger who appears at the stop Origin and wants to travel to the stop Destination.
+type Passenger struct {
+	Appearance  Time
+	Origin      StopId
+	Destination StopId
+}
+
+// Demand describes the passengers of a scenario as origin-destination matrix. The passengers of every flow appear as
+// Poisson process, whose rate is scaled by the profile depending on the hour of the day.
+type Demand struct {
+	// Seed is the seed of the random generator sampling the passengers; it is nil if the scenario does not specify one.
+	Seed  *int64
+	Flows []Flow
+	// Profile contains the factor of the rates for every hour of the day, starting at midnight.
+	Profile [24]float64
+}
+
+// Flow is an entry of an origin-destination matrix. Every passenger appears at a random stop of the origin and
+// travels to a random stop of the destination; the stop lists contain a single stop if the matrix is given per stop.
+type Flow struct {
+	Origin      []StopId
+	Destination []StopId
+	// Rate is the mean number of passengers per hour, before the profile is applied.
+	Rate float64
+}
+
+// Disturbances describes random perturbations of the bus operation, which are drawn from a seeded random generator.
+// Thus, two runs with the same seed are disturbed in the same way.
+type Disturbances struct {
+	// Seed of the random generator. It is nil if the scenario does not specify a seed.
+	Seed *int64
+	// TravelTimeNoise is the standard deviation of the travel time between two way points relative to the
+	// undisturbed travel time, e.g. 0.1 for 10 %.
+	TravelTimeNoise float64
+	// DwellExtensionProbability is the probability that the dwell time at a stop is extended, e.g. by a
+	// passenger buying a ticket. The extensions are exponentially distributed with the mean DwellExtensionMean.
+	DwellExtensionProbability float64
+	DwellExtensionMean        time.Duration
+	// IncidentProbability is the probability that a bus is blocked somewhere between two way points, e.g. by an
+	// accident. The duration of an incident is uniformly distributed between IncidentMinDuration and IncidentMaxDuration.
+	IncidentProbability float64
+	IncidentMinDuration time.Duration
+	IncidentMaxDuration time.Duration
+}
+
+// EventType distinguishes the kinds of events that happen during a simulation.
+type EventType string
+
+const (
+	// Arrival means that a bus arrived at a stop.
+	Arrival EventType = "arrival"
+	// Departure means that a bus left a stop.
+	Departure EventType = "departure"
+	// AssignmentStarted means that the departure time of an assignment has come and the bus starts serving it.
+	AssignmentStarted EventType = "assignmentStarted"
+	// AssignmentFinished means that a bus has passed the last way point of an assignment.
+	AssignmentFinished EventType = "assignmentFinished"
+	// DeadheadStarted means that a bus starts driving without passengers to the first way point of an assignment.
+	DeadheadStarted EventType = "deadheadStarted"
+	// RunStarted means that a simulation run has started. It carries the seeds of the random disturbances and of the
+	// passenger demand.
+	RunStarted EventType = "runStarted"
+	// IncidentStarted means that a bus is blocked by an incident between two way points.
+	IncidentStarted EventType = "incidentStarted"
+	// IncidentFinished means that a bus can continue after an incident.
+	IncidentFinished EventType = "incidentFinished"
+)
+
+// Event describes something that happened to a bus, or to the whole run, at a certain moment of the simulation. In contrast to
+// BusPosition, events are discrete, i.e. each event is published exactly once.
+type Event struct {
+	Type EventType `json:"type"`
+	// BusId is empty for events concerning the whole run, such as RunStarted.
+	BusId  BusId   `json:"id,omitempty"`
+	LineId LineId  `json:"lineId,omitempty"`
+	Time   Time    `json:"time"`
+	StopId *StopId `json:"stopId,omitempty"`
+	// Assignment is the index of the assignment the event belongs to.
+	Assignment int `json:"assignment"`
+	// Scheduled is the departure time of the stop according to the timetable. For events without
+	// stop, it is the departure time of the assignment.
+	Scheduled Time `json:"scheduled,omitempty"`
+	// Boarding and Alighting are the numbers of passengers that boarded and alighted at the stop. They are only
+	// set for departures.
+	Boarding  int `json:"boarding,omitempty"`
+	Alighting int `json:"alighting,omitempty"`
+	// Seed is the seed of the random disturbances and DemandSeed the seed of the sampled passenger demand. They are only
+	// set for the start of a run.
+	Seed       *int64 `json:"seed,omitempty"`
+	DemandSeed *int64 `json:"demandSeed,omitempty"`
+}
+
+// EventPublisher is a function taking care to broadcast events.
+type EventPublisher func(event Event)
+
 // Time specifies the time of the day in milliseconds. The difference to time.Time is
 // that Time does not specify the date. A Time can be parsed from a kitchen clock string such as "15:04"
 // with ParseTime.
@@ -120,39 +270,6 @@ func (t Time) String() string {
 	return fmt.Sprintf("%02d:%02d", hour, minute)
 }
 
-// Ticker is similar to time.Ticker as it contains a channel that produces
-// a Time in specified intervals. The difference to time.Ticker is that this Ticker
-// has a specified start time and operates on Time, rather than on time.Time. New tickers
-// should be created with NewTicker.
-type Ticker struct {
-	HeartBeat      <-chan Time
-	heartBeat      chan Time
-	originalTicker *time.Ticker
-}
-
-// Stop prevents the ticker from emitting more events and closes the writing channel.
-func (t *Ticker) Stop() {
-	t.originalTicker.Stop()
-	close(t.heartBeat)
-}
-
-// NewTicker creates and starts a new ticker. The start parameter specifies the first Time to be emitted
-// by the ticker. The frequency denotes the frequency of consecutive emits by the ticker.
-// The duration between two ticks is multiplied by the warp argument. This third interval may not be accurate (see time.NewTicker).
-func NewTicker(start Time, frequency float64, warp float64) Ticker {
-	interval := time.Duration(float64(1.0*time.Second) / frequency)
-	originalTicker := time.NewTicker(interval)
-	channel := make(chan Time)
-	go func() {
-		last := start
-		for range originalTicker.C {
-			channel <- last
-			last = last.Add(time.Duration(float64(interval) * warp))
-		}
-	}()
-	return Ticker{heartBeat: channel, HeartBeat: channel, originalTicker: originalTicker}
-}
-
 // StopId is used to identify a Stop.
 type StopId string
 
@@ -167,6 +284,7 @@ type Line struct {
 	departures      map[StopId][]Time
 	DefinitionIndex int
 	Color           string
+	shape           *Shape
 }
 
 func (l *Line) String() string {
@@ -178,6 +296,11 @@ func (l *Line) WayPoints() []*WayPoint {
 	return l.waypoints
 }
 
+// Shape returns the geometry of the line. It is nil if the shapes of the model have not been resolved (see ResolveShapes).
+func (l *Line) Shape() *Shape {
+	return l.shape
+}
+
 // Stops returns all way points of the lines that are real stops.
 func (l *Line) Stops() []*WayPoint {
 	result := make([]*WayPoint, 0, len(l.waypoints))
@@ -196,6 +319,18 @@ func (l *Line) StartTimes() []Time {
 	return result
 }
 
+// Departures returns all departure times of the line at the given stop. The i-th departure belongs to the
+// tour starting with the i-th start time (see StartTimes). If the line does not serve the stop, then nil is returned.
+func (l *Line) Departures(stop StopId) []Time {
+	departures, ok := l.departures[stop]
+	if !ok {
+		return nil
+	}
+	result := make([]Time, len(departures))
+	copy(result, departures)
+	return result
+}
+
 // TourTimes returns all departure times of the tour starting at start.
 // If no tour of this line starts at the given time, then nil is returned.
 // If the line is not well defined (e.g. no waypoints, no adequate departures) then the
@@ -5,18 +5,6 @@ import (
 	"time"
 )
 
-func ExampleNewTicker() {
-	start, _ := ParseTime("7:34")
-	ticker := NewTicker(start, 1000, 60*1000)
-	time1 := <-ticker.HeartBeat
-	time2 := <-ticker.HeartBeat
-	time3 := <-ticker.HeartBeat
-	ticker.Stop()
-	_ = <-ticker.HeartBeat
-	fmt.Printf("%v - %v - %v", time1, time2, time3)
-	// Output: 07:34 - 07:35 - 07:36
-}
-
 func ExampleParseTime() {
 	moment, _ := ParseTime("15:04")
 	fmt.Printf("Milliseconds since midnight: %d\n", moment)
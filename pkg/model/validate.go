@@ -0,0 +1,110 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Problems is a list of problems found while loading or validating a scenario.
+type Problems []error
+
+func (p Problems) Error() string {
+	messages := make([]string, 0, len(p))
+	for _, problem := range p {
+		messages = append(messages, problem.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate loads the scenario from the provided path (see Init) and reports all problems at once. Besides the problems
+// that make Init fail, Validate also reports problems that Init tolerates but that lead to an unexpected simulation,
+// such as departures that are not in ascending order, stops that are served twice by the same line,
+// assignments of a bus that overlap, or the stops and trips of a GTFS feed that are left out.
+// If the scenario is valid, then the returned slice is empty.
+func Validate(path string) Problems {
+	mdl, problems, skipped := loadScenarioOrFeed(path)
+	problems = append(problems, skipped...)
+	if mdl == nil {
+		return problems
+	}
+	lines := mdl.Lines()
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].DefinitionIndex < lines[j].DefinitionIndex
+	})
+	for _, line := range lines {
+		for _, problem := range validateLine(line) {
+			problems = append(problems, fmt.Errorf("line \"%s\": %v", line.Id, problem))
+		}
+	}
+	buses := mdl.Buses()
+	sort.Slice(buses, func(i, j int) bool {
+		return buses[i].Id < buses[j].Id
+	})
+	for _, bus := range buses {
+		for _, problem := range validateBus(bus) {
+			problems = append(problems, fmt.Errorf("bus \"%s\": %v", bus.Id, problem))
+		}
+	}
+	return problems
+}
+
+func validateLine(line Line) Problems {
+	problems := Problems{}
+	stops := line.Stops()
+	if len(stops) < 2 {
+		return append(problems, fmt.Errorf("a line must have at least two stops"))
+	}
+	served := make(map[StopId]bool)
+	for _, stop := range stops {
+		if served[*stop.Id] {
+			problems = append(problems, fmt.Errorf("stop \"%s\" is served twice, which is not supported", *stop.Id))
+		}
+		served[*stop.Id] = true
+	}
+	tours := len(line.departures[*stops[0].Id])
+	consistent := true
+	for _, stop := range stops {
+		departures := line.departures[*stop.Id]
+		if len(departures) != tours {
+			problems = append(problems, fmt.Errorf("stop \"%s\" has %d departures, but the first stop has %d", *stop.Id, len(departures), tours))
+			consistent = false
+		}
+		for index := 1; index < len(departures); index++ {
+			if !departures[index-1].Before(departures[index]) {
+				problems = append(problems, fmt.Errorf("departures at stop \"%s\" are not ascending: %v follows %v", *stop.Id, departures[index], departures[index-1]))
+			}
+		}
+	}
+	if !consistent {
+		return problems
+	}
+	for tour := 0; tour < tours; tour++ {
+		for index := 1; index < len(stops); index++ {
+			previous := line.departures[*stops[index-1].Id][tour]
+			current := line.departures[*stops[index].Id][tour]
+			if current.Before(previous) {
+				problems = append(problems, fmt.Errorf("tour %d departs at stop \"%s\" at %v, which is before the departure at the previous stop (%v)", tour+1, *stops[index].Id, current, previous))
+			}
+		}
+	}
+	return problems
+}
+
+func validateBus(bus Bus) Problems {
+	problems := Problems{}
+	for index := 1; index < len(bus.Assignments); index++ {
+		previous := bus.Assignments[index-1]
+		current := bus.Assignments[index]
+		end := previous.Departure
+		for _, waypoint := range previous.WayPoints {
+			if waypoint.Id != nil && end.Before(waypoint.Departure) {
+				end = waypoint.Departure
+			}
+		}
+		if current.Departure.Before(end) {
+			problems = append(problems, fmt.Errorf("assignment %d (\"%s\" at %v) starts before assignment %d (\"%s\" at %v) ends at %v", index+1, current.Name, current.Departure, index, previous.Name, previous.Departure, end))
+		}
+	}
+	return problems
+}
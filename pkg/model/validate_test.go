@@ -0,0 +1,64 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		problems := Validate("./testdata/invalid")
+		messages := make([]string, 0, len(problems))
+		for _, problem := range problems {
+			messages = append(messages, problem.Error())
+		}
+		assert.Equal(t, []string{
+			"could not parse start time \"6:61\"",
+			"could not parse line \"broken\": row 1: could not parse departures: column 3 with content \"6:7x\" is neither a valid time column nor an interval column",
+			"could not parse line \"broken\": row 2: could not find stop \"s4\"",
+			"could not parse line \"broken\": row 3: record on line 3: wrong number of fields",
+			"could not parse line \"lonely\": row 1: expected at least three columns, but got 1",
+			"could not load bus \"B1\": line assignment \"first\" with start time \"06:05\" has no equivalent in time table",
+			"could not load bus \"B1\": could not parse time \"6:3x\" of bus: the string \"6:3x\" does not match the required format",
+			"the probability of incidents must be between 0 and 1, but was 1.5",
+			"passenger file \"passengers.csv\", row 2: could not find stop \"s9\"",
+			"the demand zone \"center\" contains the unknown stop \"s5\"",
+			"the demand profile must contain 24 hourly factors, but contained 3",
+			"demand file \"demand.csv\", column 4: could not find zone or stop \"s7\"",
+			"demand file \"demand.csv\", row 3, column 3: passengers cannot travel from stop \"s2\" to itself",
+			"demand file \"demand.csv\", row 3, column 4: the passengers per hour must be a non-negative number, but were \"-3\"",
+			"demand file \"demand.csv\", row 4, column 5: passengers cannot travel from stop \"s1\" to itself",
+			"line \"first\": departures at stop \"s1\" are not ascending: 06:20 follows 06:30",
+			"line \"first\": departures at stop \"s2\" are not ascending: 06:30 follows 06:40",
+			"bus \"B2\": assignment 2 (\"Second line\" at 06:05) starts before assignment 1 (\"First line\" at 06:00) ends at 06:10",
+		}, messages, "problems")
+	})
+	t.Run("invalid gtfs", func(t *testing.T) {
+		problems := Validate("./testdata/invalidGtfs")
+		messages := make([]string, 0, len(problems))
+		for _, problem := range problems {
+			messages = append(messages, problem.Error())
+		}
+		assert.Equal(t, []string{
+			"could not load stops.txt: could not parse latitude of stop \"node/1\": strconv.ParseFloat: parsing \"north\": invalid syntax",
+			"could not load trips: trip \"A-in-0630\" references unknown stop \"node/1\"",
+			"could not create lines: route \"X\" is used by trips but not defined in routes.txt",
+			"could not create buses: bus \"B1\": assignment 2 (\"A Residenz\" at 06:16) starts before assignment 1 (\"A Residenz\" at 06:15) ends at 06:21",
+		}, messages, "problems")
+	})
+	t.Run("unsupported gtfs", func(t *testing.T) {
+		problems := Validate("./testdata/gtfsUnsupported")
+		messages := make([]string, 0, len(problems))
+		for _, problem := range problems {
+			messages = append(messages, problem.Error())
+		}
+		assert.Equal(t, []string{
+			"stop \"node/1\" has no coordinates and is left out",
+			"trip \"A-nowhere-0800\" serves stop \"node/1\" without coordinates and is left out",
+			"trip \"A-loop-0700\" visits stop \"node/119865114\" twice, which is not supported, and is left out",
+		}, messages, "parts of the feed that are left out")
+	})
+	t.Run("valid", func(t *testing.T) {
+		assert.Empty(t, Validate("./testdata/gtfs"), "gtfs feed should be valid")
+	})
+}
@@ -35,7 +35,8 @@ func NewRouteService(connection string) model.RouteService {
 // QueryRoute sends a request to the OSRM server, asking for the shortest path
 // to connect the given waypoints the the defined order (no, this is not a TSP here :)).
 // When the request is responded successfully, the shortest path is returned as well as the length
-// of the shortest path. Otherwise, a non-nil error is returned.
+// of the shortest path. Otherwise, a non-nil error is returned. The coordinates of the path know the average speed
+// on the segment leading to them (see model.SegmentSpeedAnnotated), which OSRM annotates from its speed profile.
 func (r *RouteService) QueryRoute(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
 	pointSet := geo.NewPointSet()
 	for i, m := range coordinates {
@@ -43,9 +44,11 @@ func (r *RouteService) QueryRoute(coordinates ...model.Coordinate) ([]model.Coor
 		pointSet.InsertAt(i, point)
 	}
 	overview := "full"
+	annotations := "speed"
 	routeRequest := &gosrm.RouteRequest{
 		Coordinates: *pointSet,
 		Overview:    &overview,
+		Annotations: &annotations,
 	}
 	response, err := r.client.Route(routeRequest)
 	if err != nil {
@@ -57,13 +60,26 @@ func (r *RouteService) QueryRoute(coordinates ...model.Coordinate) ([]model.Coor
 	if err != nil {
 		return nil, 0, fmt.Errorf("Could not decode polyline geometry: %v", err)
 	}
+	speeds := make([]float64, 0, len(coords))
+	for _, leg := range route.Legs {
+		speeds = append(speeds, leg.Annotation.Speed...)
+	}
+	// the annotations describe the segments between the coordinates of the full overview; if they do not match,
+	// the segment speeds are unknown
+	if len(speeds) != len(coords)-1 {
+		speeds = nil
+	}
 	result := make([]model.Coordinate, 0, len(coords))
-	for _, coord := range coords {
+	for index, coord := range coords {
+		if index > 0 && speeds != nil {
+			coord = append(coord, speeds[index-1]*3.6)
+		}
 		result = append(result, coordinate(coord))
 	}
 	return result, route.Distance, nil
 }
 
+// coordinate contains latitude and longitude, optionally followed by the segment speed in km/h.
 type coordinate []float64
 
 func (c coordinate) Lat() float64 {
@@ -73,3 +89,10 @@ func (c coordinate) Lat() float64 {
 func (c coordinate) Lon() float64 {
 	return c[1]
 }
+
+func (c coordinate) SegmentSpeed() float64 {
+	if len(c) < 3 {
+		return 0
+	}
+	return c[2]
+}
@@ -2,6 +2,7 @@ package osrm
 
 import (
 	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
@@ -16,6 +17,7 @@ func TestRouteService_QueryRoute(t *testing.T) {
 		successOsrm := func(writer http.ResponseWriter, request *http.Request) {
 			assert.Equal(t, "full", request.URL.Query()["overview"][0], "overview query param should be full")
 			assert.Equal(t, "false", request.URL.Query()["generate_hints"][0], "generate hints should be disabled")
+			assert.Equal(t, "speed", request.URL.Query()["annotations"][0], "speed annotations should be requested")
 			fmt.Printf("\n%v\n", request.URL.Query())
 			assert.Equal(t, "/route/v1/driving/polyline(_qo%5D_%7Brc@_seK_seK)", request.URL.Path, "path must be corrected")
 			response, err := os.Open("testdata/osrmresult.json")
@@ -30,6 +32,23 @@ func TestRouteService_QueryRoute(t *testing.T) {
 		assert.Equal(t, 7634.4, length, "length was not computed correctly")
 		assert.Equal(t, 396, len(route), "number of waypoints wrong")
 	})
+	t.Run("segment speeds", func(t *testing.T) {
+		annotatedOsrm := func(writer http.ResponseWriter, request *http.Request) {
+			response, err := os.Open("testdata/osrmannotated.json")
+			require.NoError(t, err)
+			_, _ = io.Copy(writer, response)
+		}
+		server := httptest.NewServer(http.HandlerFunc(annotatedOsrm))
+		defer server.Close()
+		service := NewRouteService(server.URL + "/")
+		route, _, err := service(&coordinate{5, 6}, &coordinate{7, 8})
+		require.NoError(t, err)
+		require.Equal(t, 3, len(route), "number of waypoints")
+		assert.Equal(t, 0.0, route[0].(model.SegmentSpeedAnnotated).SegmentSpeed(), "no segment speed at the start")
+		assert.Equal(t, 36.0, route[1].(model.SegmentSpeedAnnotated).SegmentSpeed(), "speed of the first segment")
+		assert.Equal(t, 18.0, route[2].(model.SegmentSpeedAnnotated).SegmentSpeed(), "speed of the second segment")
+		assert.InDelta(t, 49.799, route[1].Lat(), 1e-9, "latitude")
+	})
 	t.Run("failure", func(t *testing.T) {
 		successOsrm := func(writer http.ResponseWriter, request *http.Request) {
 			_, _ = writer.Write([]byte("{\n  \"code\": \"TooBig\"}"))
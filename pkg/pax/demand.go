@@ -0,0 +1,111 @@
+package pax
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"math/rand"
+	"time"
+)
+
+// demandSampler samples the passengers of an origin-destination demand. All flows together form a Poisson process
+// whose rate changes every hour according to the profile; every arrival is assigned to a flow with a probability
+// proportional to the rate of the flow. The passengers are drawn one after another from a single random generator,
+// thus they only depend on the seed and the start of the sampling.
+type demandSampler struct {
+	profile [24]float64
+	flows   []pairs
+	random  *rand.Rand
+	// rate is the sum of the rates of all flows in passengers per hour.
+	rate float64
+	// clock is the appearance of the last sampled passenger in milliseconds.
+	clock float64
+	// pending is the next passenger, which has been sampled but not yet returned.
+	pending *model.Passenger
+}
+
+// pairs contains all combinations of origin and destination of a flow, except for those travelling from a stop to itself.
+type pairs struct {
+	rate  float64
+	pairs [][2]model.StopId
+}
+
+// newDemandSampler returns a sampler starting at the given time, or nil if no passengers can be sampled from the
+// demand. Flows whose origin and destination consist of the same stop are left out. If the demand has no seed, 0 is used.
+func newDemandSampler(demand *model.Demand, start model.Time) *demandSampler {
+	if demand == nil {
+		return nil
+	}
+	result := &demandSampler{profile: demand.Profile, clock: float64(start)}
+	for _, flow := range demand.Flows {
+		candidates := pairs{rate: flow.Rate}
+		for _, origin := range flow.Origin {
+			for _, destination := range flow.Destination {
+				if origin != destination {
+					candidates.pairs = append(candidates.pairs, [2]model.StopId{origin, destination})
+				}
+			}
+		}
+		if flow.Rate <= 0 || len(candidates.pairs) == 0 {
+			continue
+		}
+		result.flows = append(result.flows, candidates)
+		result.rate = result.rate + flow.Rate
+	}
+	profile := 0.0
+	for _, factor := range demand.Profile {
+		profile = profile + factor
+	}
+	if result.rate <= 0 || profile <= 0 {
+		return nil
+	}
+	var seed int64
+	if demand.Seed != nil {
+		seed = *demand.Seed
+	}
+	result.random = rand.New(rand.NewSource(seed))
+	return result
+}
+
+// until returns the sampled passengers in the order of their appearance as long as they fulfill the condition.
+func (d *demandSampler) until(condition func(model.Passenger) bool) []model.Passenger {
+	result := make([]model.Passenger, 0)
+	for {
+		if d.pending == nil {
+			passenger := d.sample()
+			d.pending = &passenger
+		}
+		if !condition(*d.pending) {
+			return result
+		}
+		result = append(result, *d.pending)
+		d.pending = nil
+	}
+}
+
+// sample draws the next passenger. The time until the appearance is drawn as exponentially distributed number of
+// expected passengers, which is consumed hour by hour according to the rate of the hours.
+func (d *demandSampler) sample() model.Passenger {
+	hour := float64(time.Hour / time.Millisecond)
+	remaining := d.random.ExpFloat64()
+	for {
+		index := int(d.clock/hour) % len(d.profile)
+		perMillisecond := d.rate * d.profile[index] / hour
+		end := (float64(int(d.clock/hour)) + 1) * hour
+		if perMillisecond > 0 && remaining <= (end-d.clock)*perMillisecond {
+			d.clock = d.clock + remaining/perMillisecond
+			break
+		}
+		remaining = remaining - (end-d.clock)*perMillisecond
+		d.clock = end
+	}
+	flow := d.flows[len(d.flows)-1]
+	choice := d.random.Float64() * d.rate
+	for _, candidate := range d.flows {
+		if choice < candidate.rate {
+			flow = candidate
+			break
+		}
+		choice = choice - candidate.rate
+	}
+	pair := flow.pairs[d.random.Intn(len(flow.pairs))]
+	return model.Passenger{Appearance: model.Time(d.clock), Origin: pair[0], Destination: pair[1]}
+}
@@ -1,53 +1,100 @@
+// Package pax simulates the passengers of a scenario. Passengers appear at stops, plan their journeys with the
+// timetable, wait for the buses of the simulation, board and transfer between them, and finally alight at their destination.
 package pax
 
 import (
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
 	routing "github.com/fafeitsch/simple-timetable-routing"
+	"sort"
 )
 
+// Generator creates the passengers of a simulation in the order of their appearance. The passengers are either given
+// explicitly or sampled from the origin-destination demand of the scenario. New generators should be created
+// with NewGenerator.
 type Generator struct {
-	Lines []model.Line
+	passengers []model.Passenger
+	next       int
+	demand     *demandSampler
 }
 
-func (g *Generator) Start(frequency float64, warp float64) {
+// NewGenerator creates a generator for the given passengers, which must be sorted by their appearance. If demand
+// is not nil, the generator additionally samples passengers from the demand, beginning at start.
+func NewGenerator(passengers []model.Passenger, demand *model.Demand, start model.Time) *Generator {
+	return &Generator{passengers: passengers, demand: newDemandSampler(demand, start)}
+}
 
+// Generate returns all passengers that appear up to the given time and have not been generated before.
+func (g *Generator) Generate(until model.Time) []model.Passenger {
+	start := g.next
+	for g.next < len(g.passengers) && !until.Before(g.passengers[g.next].Appearance) {
+		g.next = g.next + 1
+	}
+	listed := g.passengers[start:g.next]
+	if g.demand == nil {
+		return listed
+	}
+	sampled := g.demand.until(func(passenger model.Passenger) bool { return !until.Before(passenger.Appearance) })
+	result := make([]model.Passenger, 0, len(listed)+len(sampled))
+	result = append(append(result, listed...), sampled...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Appearance.Before(result[j].Appearance)
+	})
+	return result
 }
 
-func convertModel(lines []model.Line) stopMapping {
-	stops, routeLines := createRoutingStops(lines)
-	for _, line := range lines {
-		startTimes := line.StartTimes()
-		lineStops := line.Stops()
-		for _, time := range startTimes {
-			departures := line.TourTimes(time)
-			for index, departure := range departures[0 : len(departures)-1] {
-				travelTime := departures[index+1].Sub(departure)
-				stop := lineStops[index+1]
-				event := routing.Event{Line: routeLines[line.Id], Departure: routing.CreateTime(departure.HourMinute()), NextStop: stops[*stop.Id], TravelTime: travelTime}
-				currentStop := lineStops[index]
-				stops[*currentStop.Id].Events = append(stops[*currentStop.Id].Events, event)
-			}
-		}
+// Skip drops all passengers that appear before the given time. This is needed if the simulation does not start at
+// the beginning of the scenario, e.g. after restoring a checkpoint. The sampled passengers after the given time are
+// the same as without skipping.
+func (g *Generator) Skip(until model.Time) {
+	for g.next < len(g.passengers) && g.passengers[g.next].Appearance.Before(until) {
+		g.next = g.next + 1
+	}
+	if g.demand != nil {
+		g.demand.until(func(passenger model.Passenger) bool { return passenger.Appearance.Before(until) })
 	}
-	return stops
 }
 
-func createRoutingStops(lines []model.Line) (map[model.StopId]*routing.Stop, map[model.LineId]*routing.Line) {
-	resStops := make(map[model.StopId]*routing.Stop)
-	resLines := make(map[model.LineId]*routing.Line)
-	for _, line := range lines {
-		for _, stop := range line.Stops() {
-			if stop.Id != nil {
-				resStops[*stop.Id] = routing.NewStop(string(*stop.Id), stop.Name)
+// convertModel creates the routing stops of the timetable from the assignments of the buses. Thus, the passengers
+// only plan with trips that are actually operated. Assignments without line cannot be used by passengers.
+func convertModel(buses []model.Bus) stopMapping {
+	stops := make(stopMapping)
+	lines := make(map[model.LineId]*routing.Line)
+	for _, bus := range buses {
+		for _, assignment := range bus.Assignments {
+			if assignment.Line == nil {
+				continue
+			}
+			line, ok := lines[assignment.Line.Id]
+			if !ok {
+				line = &routing.Line{Name: assignment.Line.Name, Id: string(assignment.Line.Id)}
+				lines[assignment.Line.Id] = line
+			}
+			var previous *model.WayPoint
+			for index := range assignment.WayPoints {
+				wayPoint := &assignment.WayPoints[index]
+				if wayPoint.Id == nil {
+					continue
+				}
+				stops.add(wayPoint)
+				if previous != nil {
+					event := routing.Event{Line: line, Departure: routing.CreateTime(previous.Departure.HourMinute()), NextStop: stops[*wayPoint.Id], TravelTime: wayPoint.Departure.Sub(previous.Departure)}
+					stops[*previous.Id].Events = append(stops[*previous.Id].Events, event)
+				}
+				previous = wayPoint
 			}
 		}
-		resLines[line.Id] = &routing.Line{Name: line.Name, Id: string(line.Id)}
 	}
-	return resStops, resLines
+	return stops
 }
 
 type stopMapping map[model.StopId]*routing.Stop
 
+func (s stopMapping) add(stop *model.WayPoint) {
+	if _, ok := s[*stop.Id]; !ok {
+		s[*stop.Id] = routing.NewStop(string(*stop.Id), stop.Name)
+	}
+}
+
 func (s stopMapping) values() []*routing.Stop {
 	result := make([]*routing.Stop, 0, len(s))
 	for _, stop := range s {
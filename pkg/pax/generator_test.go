@@ -4,17 +4,100 @@ import (
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
 	routing "github.com/fafeitsch/simple-timetable-routing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
 
 func TestConvertModel(t *testing.T) {
 	mdl, _ := model.Init("../model/testdata/wuerzburg(fictional)")
-	stops := convertModel(mdl.Lines())
+	stops := convertModel(mdl.Buses())
 	tt := routing.NewTimetable(stops.values())
 	mainfrankenTheater := model.StopId("node/248513451")
+	sanderring := model.StopId("node/28807356")
 	vogelVerlag := model.StopId("node/600918135")
 	startTime, _ := time.Parse(time.Kitchen, "6:16AM")
-	connection := tt.Query(stops[mainfrankenTheater], stops[vogelVerlag], startTime)
-	assert.Equal(t, 2, len(connection.Legs), "number of legs")
+	connection := tt.Query(stops[mainfrankenTheater], stops[sanderring], startTime)
+	require.NotNil(t, connection, "connection should exist")
+	require.Equal(t, 1, len(connection.Legs), "number of legs")
+	assert.Equal(t, "A-outbound", connection.Legs[0].Line.Id, "line of the leg")
+	assert.Equal(t, "6:24AM", connection.Arrival.Format(time.Kitchen), "arrival")
+	// the lines would offer a connection with a transfer at Sanderring, but no bus serves the connecting tour
+	assert.Nil(t, tt.Query(stops[mainfrankenTheater], stops[vogelVerlag], startTime), "connection with tours that are not operated")
+}
+
+func TestGenerator(t *testing.T) {
+	passengers := []model.Passenger{
+		{Appearance: model.MustParseTime("6:00"), Origin: "a", Destination: "b"},
+		{Appearance: model.MustParseTime("6:05"), Origin: "b", Destination: "c"},
+		{Appearance: model.MustParseTime("6:10"), Origin: "c", Destination: "a"},
+	}
+	generator := NewGenerator(passengers, nil, 0)
+	generator.Skip(model.MustParseTime("6:01"))
+	assert.Empty(t, generator.Generate(model.MustParseTime("6:04")), "no passengers before 6:05")
+	assert.Equal(t, passengers[1:], generator.Generate(model.MustParseTime("6:10")), "passengers up to 6:10")
+	assert.Empty(t, generator.Generate(model.MustParseTime("7:00")), "all passengers generated")
+}
+
+func TestGenerator_Demand(t *testing.T) {
+	seed := int64(3)
+	demand := &model.Demand{
+		Seed: &seed,
+		Flows: []model.Flow{
+			{Origin: []model.StopId{"a"}, Destination: []model.StopId{"b"}, Rate: 60},
+			{Origin: []model.StopId{"c", "d"}, Destination: []model.StopId{"c", "d"}, Rate: 30},
+		},
+	}
+	demand.Profile[6] = 1
+	demand.Profile[7] = 2
+	listed := []model.Passenger{{Appearance: model.MustParseTime("6:30"), Origin: "b", Destination: "a"}}
+	start := model.MustParseTime("6:00")
+	end := model.MustParseTime("8:00")
+
+	passengers := NewGenerator(listed, demand, start).Generate(model.MustParseTime("23:59"))
+	// 90 passengers are expected between 6:00 and 7:00, 180 between 7:00 and 8:00, and none afterwards
+	assert.True(t, len(passengers) > 200 && len(passengers) < 340, "number of passengers should be near 271, but was %d", len(passengers))
+	assert.Contains(t, passengers, listed[0], "listed passenger")
+	perHour := make(map[int]int)
+	for index, passenger := range passengers {
+		require.False(t, passenger.Appearance.Before(start), "passenger %d appeared before the start", index)
+		require.True(t, passenger.Appearance.Before(end), "passenger %d appeared after the profile", index)
+		require.NotEqual(t, passenger.Origin, passenger.Destination, "passenger %d travels to the origin", index)
+		if index > 0 {
+			require.False(t, passenger.Appearance.Before(passengers[index-1].Appearance), "passenger %d is not sorted", index)
+		}
+		hour, _ := passenger.Appearance.HourMinute()
+		perHour[hour] = perHour[hour] + 1
+	}
+	assert.True(t, perHour[7] > perHour[6], "the profile doubles the demand from 7:00, but there were %d and %d passengers", perHour[6], perHour[7])
+
+	again := NewGenerator(listed, demand, start).Generate(model.MustParseTime("23:59"))
+	assert.Equal(t, passengers, again, "same seed should sample the same passengers")
+	resumed := NewGenerator(listed, demand, start)
+	resumed.Skip(model.MustParseTime("7:00"))
+	tail := resumed.Generate(model.MustParseTime("23:59"))
+	assert.Equal(t, passengers[len(passengers)-len(tail):], tail, "skipping should not change the later passengers")
+	assert.Equal(t, perHour[7], len(tail), "passengers after skipping")
+
+	otherSeed := int64(4)
+	demand.Seed = &otherSeed
+	assert.NotEqual(t, passengers, NewGenerator(listed, demand, start).Generate(model.MustParseTime("23:59")), "other seed should sample other passengers")
+}
+
+func TestGenerator_DemandWithoutPairs(t *testing.T) {
+	demand := &model.Demand{
+		Flows: []model.Flow{
+			{Origin: []model.StopId{"a", "a"}, Destination: []model.StopId{"a"}, Rate: 60},
+			{Origin: []model.StopId{"b"}, Destination: []model.StopId{"b", "c"}, Rate: 60},
+		},
+	}
+	demand.Profile[6] = 1
+	passengers := NewGenerator(nil, demand, model.MustParseTime("6:00")).Generate(model.MustParseTime("7:00"))
+	require.NotEmpty(t, passengers, "passengers of the second flow")
+	for _, passenger := range passengers {
+		assert.Equal(t, model.Passenger{Appearance: passenger.Appearance, Origin: "b", Destination: "c"}, passenger, "only the second flow has passengers")
+	}
+
+	demand.Flows = demand.Flows[:1]
+	assert.Empty(t, NewGenerator(nil, demand, model.MustParseTime("6:00")).Generate(model.MustParseTime("7:00")), "flow without pairs")
 }
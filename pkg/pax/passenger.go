@@ -1,4 +1,79 @@
 package pax
 
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+)
+
+// passenger is the agent of a single passenger. Its journey consists of planned legs, each of which is travelled on
+// one line; between two legs, the passenger transfers at a stop.
 type passenger struct {
+	id          int
+	origin      model.StopId
+	destination model.StopId
+	appearance  model.Time
+	planned     model.Time
+	legs        []plannedLeg
+	// leg is the index of the leg the passenger is waiting for or travelling on.
+	leg     int
+	arrival *model.Time
+	trips   []Leg
+}
+
+type plannedLeg struct {
+	line model.LineId
+	from model.StopId
+	to   model.StopId
+}
+
+// board lets the passenger enter the bus at the current time.
+func (p *passenger) board(bus model.BusId, now model.Time) {
+	leg := p.legs[p.leg]
+	p.trips = append(p.trips, Leg{BusId: bus, LineId: leg.line, From: leg.from, To: leg.to, Boarded: now})
+}
+
+// alight lets the passenger leave the bus at the current time and returns true if the passenger has reached the
+// destination. Otherwise, the passenger waits for the next leg.
+func (p *passenger) alight(now model.Time) bool {
+	p.trips[len(p.trips)-1].Alighted = now
+	p.leg = p.leg + 1
+	if p.leg < len(p.legs) {
+		return false
+	}
+	p.arrival = &now
+	return true
+}
+
+// interrupt lets the passenger leave the bus at the stop before the end of the current leg because the bus does not
+// continue. The passenger continues the leg from the stop with the next bus of the line.
+func (p *passenger) interrupt(stop model.StopId, now model.Time) {
+	p.trips[len(p.trips)-1].To = stop
+	p.trips[len(p.trips)-1].Alighted = now
+	p.legs[p.leg].from = stop
+}
+
+// journey summarizes the journey of the passenger so far.
+func (p *passenger) journey() Journey {
+	result := Journey{
+		Id:             p.id,
+		Origin:         p.origin,
+		Destination:    p.destination,
+		Appearance:     p.appearance,
+		PlannedArrival: p.planned,
+		Arrival:        p.arrival,
+		Legs:           append([]Leg{}, p.trips...),
+	}
+	ready := p.appearance
+	for index, leg := range p.trips {
+		waiting := leg.Boarded.Sub(ready).Seconds()
+		result.WaitingTime = result.WaitingTime + waiting
+		if index > 0 {
+			result.TransferTime = result.TransferTime + waiting
+			result.Transfers = result.Transfers + 1
+		}
+		ready = leg.Alighted
+	}
+	if p.arrival != nil {
+		result.TravelTime = p.arrival.Sub(p.appearance).Seconds()
+	}
+	return result
 }
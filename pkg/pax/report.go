@@ -0,0 +1,48 @@
+package pax
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+)
+
+// Leg describes the ride of a passenger on a bus. Alighted is 0 as long as the passenger is on the bus.
+type Leg struct {
+	BusId    model.BusId  `json:"busId"`
+	LineId   model.LineId `json:"lineId"`
+	From     model.StopId `json:"from"`
+	To       model.StopId `json:"to"`
+	Boarded  model.Time   `json:"boarded"`
+	Alighted model.Time   `json:"alighted,omitempty"`
+}
+
+// Journey describes the journey of a passenger. All durations are given in seconds. The waiting time contains the
+// time at the origin and the transfer time; both are only counted for legs the passenger has boarded already. The
+// travel time is the time between the appearance of the passenger and the arrival at the destination.
+type Journey struct {
+	Id          int          `json:"id"`
+	Origin      model.StopId `json:"origin"`
+	Destination model.StopId `json:"destination"`
+	Appearance  model.Time   `json:"appearance"`
+	// PlannedArrival is the arrival at the destination according to the timetable.
+	PlannedArrival model.Time `json:"plannedArrival"`
+	// Arrival is nil if the passenger has not arrived yet.
+	Arrival      *model.Time `json:"arrival,omitempty"`
+	Legs         []Leg       `json:"legs"`
+	TravelTime   float64     `json:"travelTime"`
+	WaitingTime  float64     `json:"waitingTime"`
+	TransferTime float64     `json:"transferTime"`
+	Transfers    int         `json:"transfers"`
+}
+
+// Report summarizes the journeys of all passengers. The mean times are computed over the passengers that have
+// arrived at their destinations and are given in seconds.
+type Report struct {
+	// Passengers is the number of passengers that have appeared so far, including the unroutable passengers.
+	Passengers int `json:"passengers"`
+	Arrived    int `json:"arrived"`
+	// Unroutable is the number of passengers for which the timetable offers no connection.
+	Unroutable       int       `json:"unroutable"`
+	MeanTravelTime   float64   `json:"meanTravelTime"`
+	MeanWaitingTime  float64   `json:"meanWaitingTime"`
+	MeanTransferTime float64   `json:"meanTransferTime"`
+	Journeys         []Journey `json:"journeys"`
+}
@@ -0,0 +1,191 @@
+package pax
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	routing "github.com/fafeitsch/simple-timetable-routing"
+	"sync"
+	"time"
+)
+
+// day is the arbitrary date on which the timetable is queried, since model.Time has no date.
+var day = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Simulation moves the passengers through the network. When a passenger appears, the fastest connection to
+// the destination is planned with the timetable. The passenger then waits at the stop and boards the next bus of the
+// planned line, even if it is not the planned one; the passenger alights at the end of the leg and waits for the
+// next line, until the destination is reached. If the bus ends its assignment before, the passenger alights at its
+// last stop and continues the leg with the next bus of the line. Passengers without connection are counted, but not
+// simulated.
+//
+// Simulation implements bus.PassengerCounter, thus it can be used as passenger counter of a bus.Dispatcher, which lets
+// the passengers board and alight whenever a bus serves a stop. It is safe to use a Simulation concurrently.
+// New simulations should be created with NewSimulation.
+type Simulation struct {
+	mutex      sync.Mutex
+	generator  *Generator
+	stops      stopMapping
+	timetable  routing.Timetable
+	passengers []*passenger
+	waiting    map[model.StopId][]*passenger
+	riding     map[model.BusId][]*passenger
+	unroutable int
+}
+
+// NewSimulation creates a simulation for the passengers of the generator, who travel with the given buses. The
+// passengers plan their journeys with the timetable of the assignments of the buses.
+func NewSimulation(buses []model.Bus, generator *Generator) *Simulation {
+	stops := convertModel(buses)
+	return &Simulation{
+		generator: generator,
+		stops:     stops,
+		timetable: routing.NewTimetable(stops.values()),
+		waiting:   make(map[model.StopId][]*passenger),
+		riding:    make(map[model.BusId][]*passenger),
+	}
+}
+
+// Exchange lets the passengers alight from and board the bus at the stop and returns their numbers. Before, all
+// passengers that appear up to the given time are created. Passengers board the bus if it serves the line of
+// their current leg, in the order of their arrival at the stop.
+func (s *Simulation) Exchange(bus model.BusId, line model.LineId, stop model.WayPoint, now model.Time) (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.update(now)
+	if stop.Id == nil {
+		return 0, 0
+	}
+	alighting := s.alight(bus, *stop.Id, now, false)
+	return s.board(bus, line, *stop.Id, now), alighting
+}
+
+// Finish lets all passengers alight from the bus at the last stop of its assignment and returns their number.
+// Passengers whose current leg does not end at the stop wait there for the next bus of the line of the leg.
+func (s *Simulation) Finish(bus model.BusId, stop model.WayPoint, now model.Time) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.update(now)
+	if stop.Id == nil {
+		return 0
+	}
+	return s.alight(bus, *stop.Id, now, true)
+}
+
+// alight lets the passengers alight from the bus whose current leg ends at the stop, or all passengers if all is true,
+// and returns their number.
+func (s *Simulation) alight(bus model.BusId, stop model.StopId, now model.Time, all bool) int {
+	alighting := 0
+	remaining := make([]*passenger, 0, len(s.riding[bus]))
+	for _, passenger := range s.riding[bus] {
+		arrived := passenger.legs[passenger.leg].to == stop
+		if !arrived && !all {
+			remaining = append(remaining, passenger)
+			continue
+		}
+		alighting = alighting + 1
+		if !arrived {
+			passenger.interrupt(stop, now)
+			s.waiting[stop] = append(s.waiting[stop], passenger)
+		} else if !passenger.alight(now) {
+			s.waiting[stop] = append(s.waiting[stop], passenger)
+		}
+	}
+	s.riding[bus] = remaining
+	return alighting
+}
+
+// Board lets the passengers board the bus who reached the stop after the bus has arrived there, and returns their number.
+func (s *Simulation) Board(bus model.BusId, line model.LineId, stop model.WayPoint, now model.Time) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.update(now)
+	if stop.Id == nil {
+		return 0
+	}
+	return s.board(bus, line, *stop.Id, now)
+}
+
+func (s *Simulation) board(bus model.BusId, line model.LineId, stop model.StopId, now model.Time) int {
+	if line == "" {
+		return 0
+	}
+	boarding := 0
+	waiting := make([]*passenger, 0, len(s.waiting[stop]))
+	for _, passenger := range s.waiting[stop] {
+		if passenger.legs[passenger.leg].line != line {
+			waiting = append(waiting, passenger)
+			continue
+		}
+		boarding = boarding + 1
+		passenger.board(bus, now)
+		s.riding[bus] = append(s.riding[bus], passenger)
+	}
+	s.waiting[stop] = waiting
+	return boarding
+}
+
+// Update creates all passengers that appear up to the given time. Exchange does this on its own, but passengers
+// appearing after the last arrival of a bus are only created by Update. Thus, it should be called after every
+// tick of the simulation.
+func (s *Simulation) Update(now model.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.update(now)
+}
+
+func (s *Simulation) update(now model.Time) {
+	for _, generated := range s.generator.Generate(now) {
+		s.plan(generated)
+	}
+}
+
+// plan queries the timetable for the fastest connection of the passenger and lets the passenger wait at the origin.
+func (s *Simulation) plan(generated model.Passenger) {
+	origin, originOk := s.stops[generated.Origin]
+	destination, destinationOk := s.stops[generated.Destination]
+	if !originOk || !destinationOk {
+		s.unroutable = s.unroutable + 1
+		return
+	}
+	connection := s.timetable.Query(origin, destination, day.Add(time.Duration(generated.Appearance)*time.Millisecond))
+	if connection == nil {
+		s.unroutable = s.unroutable + 1
+		return
+	}
+	passenger := &passenger{
+		id:          len(s.passengers) + 1,
+		origin:      generated.Origin,
+		destination: generated.Destination,
+		appearance:  generated.Appearance,
+		planned:     model.Time(connection.Arrival.Sub(day) / time.Millisecond),
+	}
+	for _, leg := range connection.Legs {
+		passenger.legs = append(passenger.legs, plannedLeg{line: model.LineId(leg.Line.Id), from: model.StopId(leg.FirstStop.Id), to: model.StopId(leg.LastStop.Id)})
+	}
+	s.passengers = append(s.passengers, passenger)
+	s.waiting[generated.Origin] = append(s.waiting[generated.Origin], passenger)
+}
+
+// Report summarizes the journeys of all passengers that have appeared so far. The journeys are sorted by the ids of
+// the passengers, i.e. by their appearance.
+func (s *Simulation) Report() Report {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := Report{Passengers: len(s.passengers) + s.unroutable, Unroutable: s.unroutable, Journeys: make([]Journey, 0, len(s.passengers))}
+	for _, passenger := range s.passengers {
+		journey := passenger.journey()
+		result.Journeys = append(result.Journeys, journey)
+		if journey.Arrival == nil {
+			continue
+		}
+		result.Arrived = result.Arrived + 1
+		result.MeanTravelTime = result.MeanTravelTime + journey.TravelTime
+		result.MeanWaitingTime = result.MeanWaitingTime + journey.WaitingTime
+		result.MeanTransferTime = result.MeanTransferTime + journey.TransferTime
+	}
+	if result.Arrived > 0 {
+		result.MeanTravelTime = result.MeanTravelTime / float64(result.Arrived)
+		result.MeanWaitingTime = result.MeanWaitingTime / float64(result.Arrived)
+		result.MeanTransferTime = result.MeanTransferTime / float64(result.Arrived)
+	}
+	return result
+}
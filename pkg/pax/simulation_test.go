@@ -0,0 +1,158 @@
+package pax
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type mockModel struct {
+	buses []model.Bus
+}
+
+func (m mockModel) Buses() []model.Bus {
+	return m.buses
+}
+
+func (m mockModel) Bus(id model.BusId) (*model.Bus, bool) {
+	panic("not yet implemented")
+}
+
+func TestSimulation(t *testing.T) {
+	stopA := model.StopId("a")
+	stopB := model.StopId("b")
+	stopC := model.StopId("c")
+	stopD := model.StopId("d")
+	bus1 := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Line:      &model.Line{Id: "L1", Name: "A - C"},
+				Departure: model.MustParseTime("6:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stopA, Departure: model.MustParseTime("6:00"), Longitude: 9.95075, Latitude: 49.79993},
+					{Id: &stopB, Departure: model.MustParseTime("6:02"), Longitude: 9.94932, Latitude: 49.79900},
+					{Id: &stopC, Departure: model.MustParseTime("6:04"), Longitude: 9.94550, Latitude: 49.79886},
+				},
+			},
+		},
+	}
+	bus2 := model.Bus{
+		Id: "Bus2",
+		Assignments: []model.Assignment{
+			{
+				Line:      &model.Line{Id: "L2", Name: "C - D"},
+				Departure: model.MustParseTime("6:10"),
+				WayPoints: []model.WayPoint{
+					{Id: &stopC, Departure: model.MustParseTime("6:10"), Longitude: 9.94550, Latitude: 49.79886},
+					{Id: &stopD, Departure: model.MustParseTime("6:12"), Longitude: 9.94316, Latitude: 49.79919},
+				},
+			},
+		},
+	}
+	buses := []model.Bus{bus1, bus2}
+	passengers := []model.Passenger{
+		{Appearance: model.MustParseTime("5:58"), Origin: stopA, Destination: stopD},
+		// reaches the stop after the bus, but before its departure
+		{Appearance: model.MustParseTime("6:01"), Origin: stopB, Destination: stopC},
+		{Appearance: model.MustParseTime("6:00"), Origin: stopA, Destination: "unknown"},
+		{Appearance: model.MustParseTime("6:05"), Origin: stopD, Destination: stopA},
+	}
+	simulation := NewSimulation(buses, NewGenerator(passengers, nil, 0))
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	dispatcher := bus.NewDispatcher(&mockModel{buses: buses}, func(model.BusPosition) {}, routeService)
+	boarded := 0
+	dispatcher.PublishEvent = func(event model.Event) {
+		boarded = boarded + event.Boarding
+	}
+	dispatcher.AfterTick = simulation.Update
+	dispatcher.Passengers = simulation
+	dispatcher.RunHeadless(model.MustParseTime("5:55"))
+
+	report := simulation.Report()
+	assert.Equal(t, 4, report.Passengers, "number of passengers")
+	assert.Equal(t, 2, report.Unroutable, "passengers without connection")
+	assert.Equal(t, 2, report.Arrived, "arrived passengers")
+	assert.Equal(t, 3, boarded, "boarding passengers of all departures")
+	require.Equal(t, 2, len(report.Journeys), "number of journeys")
+
+	transferring := report.Journeys[0]
+	require.Equal(t, 2, len(transferring.Legs), "legs of the transferring passenger")
+	assert.Equal(t, Leg{BusId: "Bus1", LineId: "L1", From: stopA, To: stopC, Boarded: transferring.Legs[0].Boarded, Alighted: transferring.Legs[0].Alighted}, transferring.Legs[0], "first leg")
+	assert.Equal(t, Leg{BusId: "Bus2", LineId: "L2", From: stopC, To: stopD, Boarded: transferring.Legs[1].Boarded, Alighted: transferring.Legs[1].Alighted}, transferring.Legs[1], "second leg")
+	assert.Equal(t, 1, transferring.Transfers, "transfers")
+	assert.Equal(t, model.MustParseTime("6:12"), transferring.PlannedArrival, "planned arrival")
+	waiting := transferring.Legs[0].Boarded.Sub(transferring.Appearance) + transferring.Legs[1].Boarded.Sub(transferring.Legs[0].Alighted)
+	assert.Equal(t, waiting.Seconds(), transferring.WaitingTime, "waiting time")
+	assert.Equal(t, transferring.Legs[1].Boarded.Sub(transferring.Legs[0].Alighted).Seconds(), transferring.TransferTime, "transfer time")
+	assert.Equal(t, transferring.Arrival.Sub(transferring.Appearance).Seconds(), transferring.TravelTime, "travel time")
+
+	direct := report.Journeys[1]
+	require.Equal(t, 1, len(direct.Legs), "legs of the direct passenger")
+	assert.Equal(t, stopB, direct.Legs[0].From, "origin of the direct passenger")
+	assert.Equal(t, 0, direct.Transfers, "transfers of the direct passenger")
+	assert.Equal(t, 0.0, direct.TransferTime, "transfer time of the direct passenger")
+	assert.Equal(t, (transferring.TravelTime+direct.TravelTime)/2, report.MeanTravelTime, "mean travel time")
+}
+
+func TestSimulation_FinishedAssignment(t *testing.T) {
+	stopA := model.StopId("a")
+	stopB := model.StopId("b")
+	stopC := model.StopId("c")
+	line := &model.Line{Id: "L1", Name: "A - C"}
+	short := model.Bus{
+		Id: "Bus1",
+		Assignments: []model.Assignment{
+			{
+				Line:      line,
+				Departure: model.MustParseTime("5:59"),
+				WayPoints: []model.WayPoint{
+					{Id: &stopA, Departure: model.MustParseTime("5:59"), Longitude: 9.95075, Latitude: 49.79993},
+					{Id: &stopB, Departure: model.MustParseTime("6:01"), Longitude: 9.94932, Latitude: 49.79900},
+				},
+			},
+		},
+	}
+	long := model.Bus{
+		Id: "Bus2",
+		Assignments: []model.Assignment{
+			{
+				Line:      line,
+				Departure: model.MustParseTime("6:00"),
+				WayPoints: []model.WayPoint{
+					{Id: &stopA, Departure: model.MustParseTime("6:00"), Longitude: 9.95075, Latitude: 49.79993},
+					{Id: &stopB, Departure: model.MustParseTime("6:02"), Longitude: 9.94932, Latitude: 49.79900},
+					{Id: &stopC, Departure: model.MustParseTime("6:04"), Longitude: 9.94550, Latitude: 49.79886},
+				},
+			},
+		},
+	}
+	buses := []model.Bus{short, long}
+	passengers := []model.Passenger{{Appearance: model.MustParseTime("5:58"), Origin: stopA, Destination: stopC}}
+	simulation := NewSimulation(buses, NewGenerator(passengers, nil, 0))
+	routeService := func(coordinates ...model.Coordinate) ([]model.Coordinate, float64, error) {
+		return coordinates, 0, nil
+	}
+	dispatcher := bus.NewDispatcher(&mockModel{buses: buses}, func(model.BusPosition) {}, routeService)
+	alighted := make(map[model.BusId]int)
+	dispatcher.PublishEvent = func(event model.Event) {
+		alighted[event.BusId] = alighted[event.BusId] + event.Alighting
+	}
+	dispatcher.AfterTick = simulation.Update
+	dispatcher.Passengers = simulation
+	dispatcher.RunHeadless(model.MustParseTime("5:55"))
+
+	report := simulation.Report()
+	assert.Equal(t, 1, report.Arrived, "the passenger should arrive")
+	assert.Equal(t, 1, alighted["Bus1"], "the passenger should alight at the end of the assignment")
+	assert.Equal(t, 1, alighted["Bus2"], "the passenger should alight at the destination")
+	require.Equal(t, 1, len(report.Journeys), "number of journeys")
+	legs := report.Journeys[0].Legs
+	require.Equal(t, 2, len(legs), "legs of the passenger")
+	assert.Equal(t, Leg{BusId: "Bus1", LineId: "L1", From: stopA, To: stopB, Boarded: legs[0].Boarded, Alighted: legs[0].Alighted}, legs[0], "first leg")
+	assert.Equal(t, Leg{BusId: "Bus2", LineId: "L1", From: stopB, To: stopC, Boarded: legs[1].Boarded, Alighted: legs[1].Alighted}, legs[1], "second leg")
+}
@@ -0,0 +1,143 @@
+package record
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Format specifies how a Recorder writes its entries.
+type Format string
+
+const (
+	// Jsonl writes every entry as JSON object on its own line.
+	Jsonl Format = "jsonl"
+	// Csv writes every entry as row of a CSV table with a header row. The columns seed and demandSeed contain the seeds
+	// of the disturbances and of the demand; they are only filled in the row of the RunStarted event.
+	Csv Format = "csv"
+)
+
+// FormatOf determines the format from the extension of the given file name. Files ending with .csv
+// are written as CSV, all other files as JSONL.
+func FormatOf(fileName string) Format {
+	if strings.EqualFold(filepath.Ext(fileName), ".csv") {
+		return Csv
+	}
+	return Jsonl
+}
+
+// Entry is a single record of a simulation run. Exactly one of Position and Event is set.
+type Entry struct {
+	Time     model.Time         `json:"time"`
+	Position *model.BusPosition `json:"position,omitempty"`
+	Event    *model.Event       `json:"event,omitempty"`
+}
+
+var csvHeader = []string{"time", "type", "bus", "lat", "lon", "stop", "scheduled", "seed", "demandSeed"}
+
+// Recorder writes the positions and events of a simulation run to a writer. The Position and Event methods
+// match model.Publisher and model.EventPublisher, respectively. Since publishers cannot return errors,
+// the recorder stops writing after the first error and reports it in Flush. A Recorder must be created with NewRecorder.
+type Recorder struct {
+	clock  func() model.Time
+	buffer *bufio.Writer
+	json   *json.Encoder
+	csv    *csv.Writer
+	err    error
+}
+
+// NewRecorder creates a recorder writing in the given format. The clock is queried for
+// the simulation time of every recorded position.
+func NewRecorder(writer io.Writer, format Format, clock func() model.Time) *Recorder {
+	buffer := bufio.NewWriter(writer)
+	recorder := &Recorder{clock: clock, buffer: buffer}
+	switch format {
+	case Csv:
+		recorder.csv = csv.NewWriter(buffer)
+		recorder.err = recorder.csv.Write(csvHeader)
+	default:
+		recorder.json = json.NewEncoder(buffer)
+	}
+	return recorder
+}
+
+// Position records the given position with the current time of the clock.
+func (r *Recorder) Position(position model.BusPosition) {
+	r.write(Entry{Time: r.clock(), Position: &position})
+}
+
+// Event records the given event.
+func (r *Recorder) Event(event model.Event) {
+	r.write(Entry{Time: event.Time, Event: &event})
+}
+
+func (r *Recorder) write(entry Entry) {
+	if r.err != nil {
+		return
+	}
+	if r.csv != nil {
+		r.err = r.csv.Write(csvRecord(entry))
+	} else {
+		r.err = r.json.Encode(entry)
+	}
+}
+
+func csvRecord(entry Entry) []string {
+	result := make([]string, len(csvHeader))
+	result[0] = strconv.Itoa(int(entry.Time))
+	if entry.Position != nil {
+		result[1] = "position"
+		result[2] = string(entry.Position.BusId)
+		result[3] = strconv.FormatFloat(entry.Position.Location[0], 'f', -1, 64)
+		result[4] = strconv.FormatFloat(entry.Position.Location[1], 'f', -1, 64)
+		if entry.Position.StopId != nil {
+			result[5] = string(*entry.Position.StopId)
+			result[6] = strconv.Itoa(int(entry.Position.Departure))
+		}
+		return result
+	}
+	result[1] = string(entry.Event.Type)
+	result[2] = string(entry.Event.BusId)
+	if entry.Event.StopId != nil {
+		result[5] = string(*entry.Event.StopId)
+	}
+	if entry.Event.Type == model.RunStarted {
+		result[7] = formatSeed(entry.Event.Seed)
+		result[8] = formatSeed(entry.Event.DemandSeed)
+		return result
+	}
+	result[6] = strconv.Itoa(int(entry.Event.Scheduled))
+	return result
+}
+
+func formatSeed(seed *int64) string {
+	if seed == nil {
+		return ""
+	}
+	return strconv.FormatInt(*seed, 10)
+}
+
+// Flush writes all buffered entries to the underlying writer. It returns the first error that occurred
+// while recording, if any.
+func (r *Recorder) Flush() error {
+	if r.err != nil {
+		return fmt.Errorf("could not record simulation: %v", r.err)
+	}
+	if r.csv != nil {
+		r.csv.Flush()
+		r.err = r.csv.Error()
+	}
+	if r.err == nil {
+		r.err = r.buffer.Flush()
+	}
+	if r.err != nil {
+		return fmt.Errorf("could not record simulation: %v", r.err)
+	}
+	return nil
+}
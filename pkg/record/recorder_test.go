@@ -0,0 +1,61 @@
+package record
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func record(format Format) (string, error) {
+	var buffer bytes.Buffer
+	now := model.MustParseTime("6:00")
+	recorder := NewRecorder(&buffer, format, func() model.Time { return now })
+	stop := model.StopId("node/1")
+	recorder.Event(model.Event{Type: model.AssignmentStarted, BusId: "V1", LineId: "L1", Time: now, Scheduled: now})
+	recorder.Position(model.BusPosition{BusId: "V1", Location: [2]float64{49.5, 9.25}, Heading: 90, Speed: 40, Delay: 30, Distance: 120})
+	recorder.Event(model.Event{Type: model.Arrival, BusId: "V1", Time: now, StopId: &stop, Scheduled: model.MustParseTime("6:01")})
+	recorder.Position(model.BusPosition{BusId: "V1", Location: [2]float64{49.5, 9.25}, StopId: &stop, Departure: model.MustParseTime("6:01")})
+	seed, demandSeed := int64(42), int64(7)
+	recorder.Event(model.Event{Type: model.RunStarted, Time: now, Seed: &seed, DemandSeed: &demandSeed})
+	err := recorder.Flush()
+	return buffer.String(), err
+}
+
+func TestRecorder(t *testing.T) {
+	t.Run("jsonl", func(t *testing.T) {
+		got, err := record(Jsonl)
+		require.NoError(t, err)
+		expected := `{"time":21600000,"event":{"type":"assignmentStarted","id":"V1","lineId":"L1","time":21600000,"assignment":0,"scheduled":21600000}}
+{"time":21600000,"position":{"id":"V1","loc":[49.5,9.25],"heading":90,"speed":40,"assignment":0,"delay":30,"distance":120,"occupancy":0}}
+{"time":21600000,"event":{"type":"arrival","id":"V1","time":21600000,"stopId":"node/1","assignment":0,"scheduled":21660000}}
+{"time":21600000,"position":{"id":"V1","loc":[49.5,9.25],"stopId":"node/1","departure":21660000,"heading":0,"speed":0,"assignment":0,"delay":0,"distance":0,"occupancy":0}}
+{"time":21600000,"event":{"type":"runStarted","time":21600000,"assignment":0,"seed":42,"demandSeed":7}}
+`
+		assert.Equal(t, expected, got, "recorded entries")
+	})
+	t.Run("csv", func(t *testing.T) {
+		got, err := record(Csv)
+		require.NoError(t, err)
+		expected := `time,type,bus,lat,lon,stop,scheduled,seed,demandSeed
+21600000,assignmentStarted,V1,,,,21600000,,
+21600000,position,V1,49.5,9.25,,,,
+21600000,arrival,V1,,,node/1,21660000,,
+21600000,position,V1,49.5,9.25,node/1,21660000,,
+21600000,runStarted,,,,,,42,7
+`
+		assert.Equal(t, expected, got, "recorded entries")
+		rows, err := csv.NewReader(strings.NewReader(got)).ReadAll()
+		require.NoError(t, err, "rows must have the same number of fields")
+		assert.Equal(t, 6, len(rows), "number of rows")
+	})
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, Csv, FormatOf("run.CSV"), "csv file")
+	assert.Equal(t, Jsonl, FormatOf("run.jsonl"), "jsonl file")
+	assert.Equal(t, Jsonl, FormatOf("run"), "file without extension")
+}
@@ -0,0 +1,344 @@
+// Package replay plays recorded simulation runs (see package record) back. Since the recording contains all positions
+// and events with their simulation times, neither a route service nor a dispatcher is needed.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/record"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Player publishes the entries of a recording in the pace of the original simulation. Like a bus.Dispatcher,
+// the player owns a simulation clock that can be paused, resumed, accelerated, and moved. Every tick of the clock
+// publishes the entries recorded up to the current time. A Player must be created with Load.
+type Player struct {
+	mutex     sync.RWMutex
+	entries   []record.Entry
+	next      int
+	now       model.Time
+	state     bus.SimulationState
+	warp      float64
+	positions map[model.BusId]model.BusPosition
+	// assignments contains the index of the assignment every bus is serving or heading for.
+	assignments map[model.BusId]int
+	jumps       chan jump
+	halt        chan struct{}
+	halted      sync.Once
+	stopped     chan struct{}
+	// Buses is the scenario of the recording. It is optional and only needed by QueryCurrentAssignment.
+	Buses model.BusModel
+	// Publish is called for every replayed position.
+	Publish model.Publisher
+	// PublishEvent is called for every replayed event.
+	PublishEvent model.EventPublisher
+	// PublishRemoval is called for every bus that was in service before a jump, but is not in service afterwards.
+	PublishRemoval func(model.BusId)
+	// PublishStatus is called whenever the status of the clock changes.
+	PublishStatus func(bus.Status)
+	// AfterTick is called after all entries of a tick have been published.
+	AfterTick func(model.Time)
+	// Frequency is the number of ticks per second.
+	Frequency float64
+	// Warp is the initial relation between simulation time and real time.
+	Warp float64
+}
+
+type jump struct {
+	target model.Time
+	done   chan error
+}
+
+// Load reads a recording in the JSONL format, as written by record.Recorder, and creates a player for it.
+func Load(reader io.Reader) (*Player, error) {
+	decoder := json.NewDecoder(reader)
+	entries := make([]record.Entry, 0)
+	for decoder.More() {
+		entry := record.Entry{}
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("could not read entry %d of the recording: %v", len(entries)+1, err)
+		}
+		if entry.Position == nil && entry.Event == nil {
+			return nil, fmt.Errorf("entry %d of the recording has neither position nor event", len(entries)+1)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("the recording is empty")
+	}
+	// the order of the entries within a tick must be kept, e.g. an arrival is recorded after the position at the stop
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return &Player{
+		entries:        entries,
+		state:          bus.Pending,
+		positions:      make(map[model.BusId]model.BusPosition),
+		assignments:    make(map[model.BusId]int),
+		jumps:          make(chan jump),
+		halt:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+		Publish:        func(model.BusPosition) {},
+		PublishEvent:   func(model.Event) {},
+		PublishRemoval: func(model.BusId) {},
+		PublishStatus:  func(bus.Status) {},
+		AfterTick:      func(model.Time) {},
+		Frequency:      2,
+		Warp:           1,
+	}, nil
+}
+
+// Start returns the time of the first entry of the recording.
+func (p *Player) Start() model.Time {
+	return p.entries[0].Time
+}
+
+// End returns the time of the last entry of the recording.
+func (p *Player) End() model.Time {
+	return p.entries[len(p.entries)-1].Time
+}
+
+// Run starts the clock at the beginning of the recording. This method blocks until all entries have been published
+// or the replay is stopped.
+func (p *Player) Run() {
+	p.mutex.Lock()
+	p.now = p.Start()
+	p.warp = p.Warp
+	p.state = bus.Running
+	p.mutex.Unlock()
+	p.PublishStatus(p.Status())
+	defer p.finish()
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+	next := p.Start()
+	for {
+		select {
+		case request := <-p.jumps:
+			finished := p.seek(request.target)
+			close(request.done)
+			if finished {
+				return
+			}
+			next = request.target.Add(p.step())
+		case <-p.halt:
+			return
+		case <-ticker.C:
+			if p.Status().State == bus.Paused {
+				continue
+			}
+			finished := p.tick(next)
+			p.PublishStatus(p.Status())
+			if finished {
+				return
+			}
+			next = next.Add(p.step())
+		}
+	}
+}
+
+// tick publishes all entries up to the given time and returns true if all entries have been published.
+func (p *Player) tick(now model.Time) bool {
+	p.mutex.Lock()
+	p.now = now
+	entries := p.advance(now)
+	finished := p.next == len(p.entries)
+	p.mutex.Unlock()
+	for _, entry := range entries {
+		if entry.Position != nil {
+			p.Publish(*entry.Position)
+		} else {
+			p.PublishEvent(*entry.Event)
+		}
+	}
+	p.AfterTick(now)
+	return finished
+}
+
+// seek moves the clock to the target, which may also lie in the past. In contrast to the ticks, the entries
+// between the current time and the target are not published; instead, the buses that are not in service anymore
+// are removed and the latest positions of all buses are published afterwards.
+func (p *Player) seek(target model.Time) bool {
+	p.mutex.Lock()
+	before := make([]model.BusId, 0, len(p.positions))
+	for id := range p.positions {
+		before = append(before, id)
+	}
+	if target.Before(p.now) {
+		p.next = 0
+		p.positions = make(map[model.BusId]model.BusPosition)
+		p.assignments = make(map[model.BusId]int)
+	}
+	p.now = target
+	p.advance(target)
+	finished := p.next == len(p.entries)
+	positions := p.sortedPositions()
+	removed := make([]model.BusId, 0)
+	for _, id := range before {
+		if _, ok := p.positions[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	p.mutex.Unlock()
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i] < removed[j]
+	})
+	for _, id := range removed {
+		p.PublishRemoval(id)
+	}
+	for _, position := range positions {
+		p.Publish(position)
+	}
+	p.AfterTick(target)
+	p.PublishStatus(p.Status())
+	return finished
+}
+
+// advance returns all entries that are not published yet up to the given time and remembers the latest
+// position and the assignment of every bus. Like in the simulation, a bus is not in service after finishing an
+// assignment until its next position is recorded. It must be called while holding the mutex.
+func (p *Player) advance(until model.Time) []record.Entry {
+	start := p.next
+	for p.next < len(p.entries) && !until.Before(p.entries[p.next].Time) {
+		if position := p.entries[p.next].Position; position != nil {
+			p.positions[position.BusId] = *position
+			p.assignments[position.BusId] = position.Assignment
+		} else if event := p.entries[p.next].Event; event.BusId != "" {
+			p.assignments[event.BusId] = event.Assignment
+			if event.Type == model.AssignmentFinished {
+				delete(p.positions, event.BusId)
+				p.assignments[event.BusId] = event.Assignment + 1
+			}
+		}
+		p.next = p.next + 1
+	}
+	return p.entries[start:p.next]
+}
+
+// QueryCurrentAssignment returns the assignment the bus with the given id is serving or heading for according to the
+// recording; after its last assignment, this is the last assignment. The assignment is taken from Buses. If the bus
+// does not exist in Buses, this method will panic.
+func (p *Player) QueryCurrentAssignment(id model.BusId) *model.Assignment {
+	p.mutex.RLock()
+	index := p.assignments[id]
+	p.mutex.RUnlock()
+	bus, ok := p.Buses.Bus(id)
+	if !ok || len(bus.Assignments) == 0 {
+		panic(fmt.Sprintf("bus with busId \"%s\" not found", id))
+	}
+	if index >= len(bus.Assignments) {
+		index = len(bus.Assignments) - 1
+	}
+	return &bus.Assignments[index]
+}
+
+// QueryBusPositions returns the latest replayed positions of all buses in service, sorted by their ids.
+func (p *Player) QueryBusPositions() []model.BusPosition {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.sortedPositions()
+}
+
+func (p *Player) sortedPositions() []model.BusPosition {
+	result := make([]model.BusPosition, 0, len(p.positions))
+	for _, position := range p.positions {
+		result = append(result, position)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].BusId < result[j].BusId
+	})
+	return result
+}
+
+// Status returns the current status of the clock.
+func (p *Player) Status() bus.Status {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return bus.Status{Type: "simulation", Time: p.now, State: p.state, Warp: p.warp}
+}
+
+// Pause stops the clock until Resume is called. Pausing a replay that is not running results in an error.
+func (p *Player) Pause() error {
+	return p.changeState(bus.Running, bus.Paused)
+}
+
+// Resume continues a paused replay. Resuming a replay that is not paused results in an error.
+func (p *Player) Resume() error {
+	return p.changeState(bus.Paused, bus.Running)
+}
+
+func (p *Player) changeState(from bus.SimulationState, to bus.SimulationState) error {
+	p.mutex.Lock()
+	if p.state != from {
+		state := p.state
+		p.mutex.Unlock()
+		return fmt.Errorf("the simulation is %s, but must be %s", state, from)
+	}
+	p.state = to
+	p.mutex.Unlock()
+	p.PublishStatus(p.Status())
+	return nil
+}
+
+// SetWarp changes the relation between simulation time and real time. The change takes effect with the next tick.
+func (p *Player) SetWarp(warp float64) error {
+	if warp <= 0 {
+		return fmt.Errorf("warp must be positive, but was %v", warp)
+	}
+	p.mutex.Lock()
+	if p.state == bus.Pending || p.state == bus.Finished {
+		p.mutex.Unlock()
+		return fmt.Errorf("the simulation is %s", p.state)
+	}
+	p.warp = warp
+	p.mutex.Unlock()
+	p.PublishStatus(p.Status())
+	return nil
+}
+
+// JumpTo moves the clock to the given time. In contrast to bus.Dispatcher, the player can also jump back, but not
+// before the start of the recording. JumpTo blocks until the target is reached.
+func (p *Player) JumpTo(target model.Time) error {
+	state := p.Status().State
+	if state == bus.Pending || state == bus.Finished {
+		return fmt.Errorf("the simulation is %s", state)
+	}
+	if target.Before(p.Start()) {
+		return fmt.Errorf("cannot jump to %v before the start of the recording at %v", target, p.Start())
+	}
+	request := jump{target: target, done: make(chan error, 1)}
+	select {
+	case p.jumps <- request:
+		return <-request.done
+	case <-p.stopped:
+		return fmt.Errorf("the simulation is %s", bus.Finished)
+	}
+}
+
+// Stop ends the replay although not all entries have been published. Run returns after the current tick.
+// Stopping a replay that has already finished has no effect.
+func (p *Player) Stop() {
+	p.halted.Do(func() { close(p.halt) })
+}
+
+func (p *Player) step() time.Duration {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return time.Duration(float64(p.interval()) * p.warp)
+}
+
+func (p *Player) interval() time.Duration {
+	return time.Duration(float64(time.Second) / p.Frequency)
+}
+
+func (p *Player) finish() {
+	p.mutex.Lock()
+	p.state = bus.Finished
+	p.mutex.Unlock()
+	close(p.stopped)
+	p.PublishStatus(p.Status())
+}
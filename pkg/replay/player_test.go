@@ -0,0 +1,152 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func recording(t *testing.T) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	now := model.MustParseTime("6:00")
+	recorder := record.NewRecorder(buffer, record.Jsonl, func() model.Time { return now })
+	recorder.Position(model.BusPosition{BusId: "V1", Location: [2]float64{49.1, 9.1}})
+	now = now.Add(time.Minute)
+	recorder.Position(model.BusPosition{BusId: "V2", Location: [2]float64{49.2, 9.2}})
+	recorder.Position(model.BusPosition{BusId: "V1", Location: [2]float64{49.3, 9.3}})
+	recorder.Event(model.Event{Type: model.Arrival, BusId: "V1", Time: now})
+	now = now.Add(time.Minute)
+	recorder.Position(model.BusPosition{BusId: "V2", Location: [2]float64{49.4, 9.4}})
+	require.NoError(t, recorder.Flush())
+	return buffer
+}
+
+func TestLoad(t *testing.T) {
+	player, err := Load(recording(t))
+	require.NoError(t, err)
+	assert.Equal(t, model.MustParseTime("6:00"), player.Start(), "start of the recording")
+	assert.Equal(t, model.MustParseTime("6:02"), player.End(), "end of the recording")
+
+	_, err = Load(strings.NewReader(""))
+	assert.EqualError(t, err, "the recording is empty")
+	_, err = Load(strings.NewReader("{\"time\":0}\n"))
+	assert.EqualError(t, err, "entry 1 of the recording has neither position nor event")
+	_, err = Load(strings.NewReader("time,type,bus\n"))
+	assert.Error(t, err, "CSV recordings cannot be replayed")
+}
+
+func TestPlayer_Run(t *testing.T) {
+	player, err := Load(recording(t))
+	require.NoError(t, err)
+	var mutex sync.Mutex
+	published := make([]string, 0)
+	player.Publish = func(position model.BusPosition) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		published = append(published, "position "+string(position.BusId))
+	}
+	player.PublishEvent = func(event model.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		published = append(published, string(event.Type)+" "+string(event.BusId))
+	}
+	ticks := 0
+	player.AfterTick = func(model.Time) {
+		ticks = ticks + 1
+	}
+	player.Frequency = 100
+	player.Warp = 3000
+	player.Run()
+
+	assert.Equal(t, []string{"position V1", "position V2", "position V1", "arrival V1", "position V2"}, published, "published entries")
+	assert.Equal(t, 5, ticks, "number of ticks (30 seconds each)")
+	assert.Equal(t, bus.Status{Type: "simulation", Time: model.MustParseTime("6:02"), State: bus.Finished, Warp: 3000}, player.Status(), "status after the run")
+	assert.Equal(t, [2]float64{49.4, 9.4}, player.QueryBusPositions()[1].Location, "latest position of V2")
+}
+
+func TestPlayer_JumpTo(t *testing.T) {
+	player, err := Load(recording(t))
+	require.NoError(t, err)
+	positions := make(chan model.BusPosition, 10)
+	player.Publish = func(position model.BusPosition) {
+		positions <- position
+	}
+	events := make(chan model.Event, 10)
+	player.PublishEvent = func(event model.Event) {
+		events <- event
+	}
+	removals := make(chan model.BusId, 10)
+	player.PublishRemoval = func(id model.BusId) {
+		removals <- id
+	}
+	assert.EqualError(t, player.JumpTo(model.MustParseTime("6:01")), "the simulation is pending")
+	player.Frequency = 1000
+	go player.Run()
+	require.Eventually(t, func() bool { return player.Status().State == bus.Running }, time.Second, time.Millisecond, "replay should run")
+	require.NoError(t, player.Pause())
+	<-positions
+
+	require.NoError(t, player.JumpTo(model.MustParseTime("6:01").Add(30*time.Second)))
+	assert.Equal(t, [2]float64{49.3, 9.3}, (<-positions).Location, "latest position of V1 after jump")
+	assert.Equal(t, [2]float64{49.2, 9.2}, (<-positions).Location, "latest position of V2 after jump")
+	assert.Equal(t, 0, len(events), "events should not be published when jumping")
+	assert.Equal(t, 0, len(removals), "no bus should be removed when jumping ahead")
+
+	require.NoError(t, player.JumpTo(model.MustParseTime("6:00").Add(30*time.Second)))
+	assert.Equal(t, []model.BusId{"V2"}, []model.BusId{<-removals}, "V2 should be removed after jumping back")
+	assert.Equal(t, 0, len(removals), "V1 should not be removed")
+	assert.Equal(t, [2]float64{49.1, 9.1}, (<-positions).Location, "position of V1 after jumping back")
+	assert.Equal(t, 1, len(player.QueryBusPositions()), "V2 has not been recorded yet")
+	assert.Equal(t, model.MustParseTime("6:00").Add(30*time.Second), player.Status().Time, "time after jumping back")
+	assert.Equal(t, bus.Paused, player.Status().State, "jumping should not resume the replay")
+
+	assert.EqualError(t, player.JumpTo(model.MustParseTime("5:59")), "cannot jump to 05:59 before the start of the recording at 06:00")
+	require.NoError(t, player.JumpTo(model.MustParseTime("7:00")))
+	require.Eventually(t, func() bool { return player.Status().State == bus.Finished }, time.Second, time.Millisecond, "replay should finish after jumping past the end")
+}
+
+type busModel map[model.BusId]*model.Bus
+
+func (b busModel) Buses() []model.Bus {
+	return nil
+}
+
+func (b busModel) Bus(id model.BusId) (*model.Bus, bool) {
+	result, ok := b[id]
+	return result, ok
+}
+
+func TestPlayer_FinishedAssignments(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	now := model.MustParseTime("6:00")
+	recorder := record.NewRecorder(buffer, record.Jsonl, func() model.Time { return now })
+	recorder.Position(model.BusPosition{BusId: "V1", Location: [2]float64{49.1, 9.1}})
+	recorder.Position(model.BusPosition{BusId: "V2", Location: [2]float64{49.2, 9.2}})
+	recorder.Event(model.Event{Type: model.AssignmentFinished, BusId: "V1", Time: now})
+	now = now.Add(time.Minute)
+	recorder.Position(model.BusPosition{BusId: "V2", Location: [2]float64{49.4, 9.4}, Assignment: 1})
+	recorder.Event(model.Event{Type: model.AssignmentFinished, BusId: "V2", Time: now, Assignment: 1})
+	require.NoError(t, recorder.Flush())
+	player, err := Load(buffer)
+	require.NoError(t, err)
+	player.Buses = busModel{
+		"V1": {Id: "V1", Assignments: []model.Assignment{{Name: "first"}, {Name: "second"}}},
+		"V2": {Id: "V2", Assignments: []model.Assignment{{Name: "first"}, {Name: "second"}}},
+	}
+	player.Frequency = 100
+	player.Warp = 3000
+
+	assert.Equal(t, "first", player.QueryCurrentAssignment("V1").Name, "assignment before the replay")
+	player.Run()
+	assert.Equal(t, 0, len(player.QueryBusPositions()), "buses should be removed after finishing their assignments")
+	assert.Equal(t, "second", player.QueryCurrentAssignment("V1").Name, "bus should head for its next assignment")
+	assert.Equal(t, "second", player.QueryCurrentAssignment("V2").Name, "bus should keep its last assignment")
+	assert.Panics(t, func() { player.QueryCurrentAssignment("V3") }, "unknown bus")
+}
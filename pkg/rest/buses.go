@@ -18,7 +18,7 @@ func (a *api) getBusInfo(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	assignment := a.dispatcher.QueryCurrentAssignment(bus.Id)
+	assignment := a.assignments.QueryCurrentAssignment(bus.Id)
 	result := busInfo{
 		Id:         bus.Id,
 		Assignment: assignment.Name,
@@ -47,7 +47,11 @@ func (a *api) getRouteOfBus(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	assignment := a.dispatcher.QueryCurrentAssignment(bus.Id)
+	assignment := a.assignments.QueryCurrentAssignment(bus.Id)
+	if assignment.Shape != nil {
+		writeRoute(w, assignment.Shape.Coordinates())
+		return
+	}
 	coords := make([]model.Coordinate, 0, len(assignment.WayPoints))
 	for _, wp := range assignment.WayPoints {
 		coords = append(coords, wp)
@@ -55,12 +59,22 @@ func (a *api) getRouteOfBus(w http.ResponseWriter, r *http.Request) {
 	a.queryAndWriteRouteToWriter(w, coords)
 }
 
+// queryAndWriteRouteToWriter writes the route along the coordinates. Without route service, e.g. in a replay, the
+// coordinates are connected directly.
 func (a *api) queryAndWriteRouteToWriter(w http.ResponseWriter, coords []model.Coordinate) {
+	if a.gps == nil {
+		writeRoute(w, coords)
+		return
+	}
 	route, _, err := a.gps(coords...)
 	if err != nil {
 		errorResponse(w, http.StatusInternalServerError, "could not query routes: %v", err)
 		return
 	}
+	writeRoute(w, route)
+}
+
+func writeRoute(w http.ResponseWriter, route []model.Coordinate) {
 	result := make([][2]float64, 0, len(route))
 	for _, coordinate := range route {
 		result = append(result, [2]float64{coordinate.Lat(), coordinate.Lon()})
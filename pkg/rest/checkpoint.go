@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"net/http"
+)
+
+func (a *api) getCheckpoint(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(a.dispatcher.Checkpoint())
+}
+
+func (a *api) restoreCheckpoint(w http.ResponseWriter, r *http.Request) {
+	checkpoint := bus.Checkpoint{}
+	err := json.NewDecoder(r.Body).Decode(&checkpoint)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "could not parse request body: %v", err)
+		return
+	}
+	if a.passengers != nil {
+		// the riders and waiting passengers cannot be rewound together with the buses
+		errorResponse(w, http.StatusConflict, "could not restore checkpoint: the passengers are simulated individually")
+		return
+	}
+	err = a.dispatcher.Restore(checkpoint)
+	if err != nil {
+		errorResponse(w, http.StatusConflict, "could not restore checkpoint: %v", err)
+		return
+	}
+	a.writeSimulationStatus(w)
+}
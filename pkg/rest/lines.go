@@ -9,10 +9,10 @@ import (
 )
 
 type restStop struct {
-	Name      string
-	Id        model.StopId
-	Latitude  float64
-	Longitude float64
+	Name      string       `json:"name"`
+	Id        model.StopId `json:"id"`
+	Latitude  float64      `json:"lat"`
+	Longitude float64      `json:"lon"`
 }
 
 type restLine struct {
@@ -64,6 +64,10 @@ func (a *api) getRoute(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	if shape := line.Shape(); shape != nil {
+		writeRoute(w, shape.Coordinates())
+		return
+	}
 	coords := make([]model.Coordinate, 0, len(line.WayPoints()))
 	for _, stop := range line.WayPoints() {
 		coords = append(coords, stop)
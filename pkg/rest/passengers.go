@@ -0,0 +1,12 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (a *api) getPassengers(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(a.passengers.Report())
+}
@@ -0,0 +1,38 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/adherence"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"net/http"
+	"time"
+)
+
+type busAdherence struct {
+	Id model.BusId `json:"id"`
+	// Delay is the current deviation from the timetable in seconds.
+	Delay  int               `json:"delay"`
+	Visits []adherence.Visit `json:"visits"`
+}
+
+func (a *api) getPunctuality(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(a.tracker.Report())
+}
+
+func (a *api) getBusAdherence(w http.ResponseWriter, r *http.Request) {
+	bus, ok := a.findBus(w, r)
+	if !ok {
+		return
+	}
+	result := busAdherence{Id: bus.Id, Visits: a.tracker.Visits(bus.Id)}
+	for _, state := range a.dispatcher.QueryBusStates() {
+		if state.Id == bus.Id {
+			result.Delay = int(state.Delay / time.Second)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(result)
+}
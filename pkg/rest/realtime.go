@@ -0,0 +1,23 @@
+package rest
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/gtfs"
+	"net/http"
+	"time"
+)
+
+func (a *api) getVehiclePositions(w http.ResponseWriter, r *http.Request) {
+	a.writeRealtimeFeed(w, gtfs.VehiclePositions)
+}
+
+func (a *api) getTripUpdates(w http.ResponseWriter, r *http.Request) {
+	a.writeRealtimeFeed(w, gtfs.TripUpdates)
+}
+
+// writeRealtimeFeed encodes the states of the buses relative to the service day. In contrast to the wall clock, the
+// service day does not change while the simulation runs, thus the trips keep their start date after midnight.
+func (a *api) writeRealtimeFeed(w http.ResponseWriter, encode func([]bus.State, time.Time) []byte) {
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(encode(a.dispatcher.QueryBusStates(), a.serviceDay))
+}
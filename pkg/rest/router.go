@@ -3,18 +3,27 @@ package rest
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/adherence"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/pax"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"time"
 )
 
 type api struct {
-	lineModel  model.LineModel
-	busModel   model.BusModel
-	dispatcher *bus.Dispatcher
-	gps        model.RouteService
+	lineModel   model.LineModel
+	busModel    model.BusModel
+	stopModel   model.StopModel
+	dispatcher  *bus.Dispatcher
+	simulation  Simulation
+	assignments Assignments
+	serviceDay  time.Time
+	tracker     *adherence.Tracker
+	passengers  *pax.Simulation
+	gps         model.RouteService
 }
 
 func headers(next http.HandlerFunc) http.Handler {
@@ -40,23 +49,99 @@ func headers(next http.HandlerFunc) http.Handler {
 
 const apiPrefix = "/api"
 
-// RouterConfig contains the necessary models and accessors for running the rest api.
+// Simulation is a simulation clock that can be controlled via the REST api. It is implemented by
+// bus.Dispatcher and replay.Player.
+type Simulation interface {
+	Status() bus.Status
+	Pause() error
+	Resume() error
+	SetWarp(float64) error
+	JumpTo(model.Time) error
+}
+
+// Assignments provides the assignments the buses are currently serving. It is implemented by bus.Dispatcher and
+// replay.Player.
+type Assignments interface {
+	QueryCurrentAssignment(model.BusId) *model.Assignment
+}
+
+// RouterConfig contains the necessary models and accessors for running the rest api. Endpoints whose
+// models or accessors are missing in the config are not served; this allows serving a replay without dispatcher.
 type RouterConfig struct {
 	LineModel  model.LineModel
 	BusModel   model.BusModel
+	StopModel  model.StopModel
 	Dispatcher *bus.Dispatcher
-	Gps        model.RouteService
+	// Simulation defaults to the Dispatcher.
+	Simulation Simulation
+	// Assignments defaults to the Dispatcher.
+	Assignments Assignments
+	// Tracker must receive the events of the Dispatcher or the Simulation.
+	Tracker *adherence.Tracker
+	// Passengers are the passengers travelling with the buses of the Dispatcher. Their journeys are only served if set.
+	// Since they are not part of checkpoints, restoring a checkpoint is rejected if they are set.
+	Passengers *pax.Simulation
+	// ServiceDay is the simulated day; the simulation times count from it. The GTFS-Realtime feeds use it as start date
+	// of the trips. It should be computed with gtfs.ServiceDay in the time zone of the exported feed. It defaults to
+	// midnight of the day on which the router is created in the local time zone.
+	ServiceDay time.Time
+	// Gps computes the routes missing in the models. Without it, the way points of such routes are connected directly.
+	Gps model.RouteService
 }
 
 // NewRouter creates an http router for the REST Api.
 func NewRouter(config RouterConfig) http.Handler {
-	api := api{lineModel: config.LineModel, busModel: config.BusModel, dispatcher: config.Dispatcher, gps: config.Gps}
+	api := api{lineModel: config.LineModel, busModel: config.BusModel, stopModel: config.StopModel, dispatcher: config.Dispatcher, simulation: config.Simulation, assignments: config.Assignments, serviceDay: config.ServiceDay, tracker: config.Tracker, passengers: config.Passengers, gps: config.Gps}
+	if api.simulation == nil && config.Dispatcher != nil {
+		api.simulation = config.Dispatcher
+	}
+	if api.assignments == nil && config.Dispatcher != nil {
+		api.assignments = config.Dispatcher
+	}
+	if api.serviceDay.IsZero() {
+		now := time.Now()
+		api.serviceDay = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
 	router := mux.NewRouter()
-	router.Handle(apiPrefix+"/lines", headers(api.getLines))
-	router.Handle(apiPrefix+"/lines/{key}", headers(api.getLine))
-	router.Handle(apiPrefix+"/lines/{key}/route", headers(api.getRoute))
-	router.Handle(apiPrefix+"/buses/{key}/info", headers(api.getBusInfo))
-	router.Handle(apiPrefix+"/buses/{key}/route", headers(api.getRouteOfBus))
+	if config.LineModel != nil {
+		router.Handle(apiPrefix+"/lines", headers(api.getLines))
+		router.Handle(apiPrefix+"/lines/{key}", headers(api.getLine))
+		router.Handle(apiPrefix+"/lines/{key}/route", headers(api.getRoute))
+	}
+	if config.BusModel != nil && api.assignments != nil {
+		router.Handle(apiPrefix+"/buses/{key}/info", headers(api.getBusInfo))
+		router.Handle(apiPrefix+"/buses/{key}/route", headers(api.getRouteOfBus))
+	}
+	if config.BusModel != nil && config.Dispatcher != nil && config.Tracker != nil {
+		router.Handle(apiPrefix+"/buses/{key}/adherence", headers(api.getBusAdherence))
+	}
+	if config.Tracker != nil {
+		router.Handle(apiPrefix+"/punctuality", headers(api.getPunctuality))
+	}
+	if config.Passengers != nil {
+		router.Handle(apiPrefix+"/passengers", headers(api.getPassengers))
+	}
+	if config.StopModel != nil {
+		router.Handle(apiPrefix+"/stops", headers(api.getStops))
+		// stop ids may contain slashes (e.g. OSM ids such as node/123), thus the departures must be matched first
+		if config.Dispatcher != nil {
+			router.Handle(apiPrefix+"/stops/{key:.+}/departures", headers(api.getDepartures))
+		}
+		router.Handle(apiPrefix+"/stops/{key:.+}", headers(api.getStop))
+	}
+	if config.Dispatcher != nil {
+		router.Handle(apiPrefix+"/gtfs-rt/vehicle-positions", headers(api.getVehiclePositions))
+		router.Handle(apiPrefix+"/gtfs-rt/trip-updates", headers(api.getTripUpdates))
+		router.Handle(apiPrefix+"/simulation/checkpoint", headers(api.getCheckpoint)).Methods(http.MethodGet, http.MethodOptions)
+		router.Handle(apiPrefix+"/simulation/checkpoint", headers(api.restoreCheckpoint)).Methods(http.MethodPost)
+	}
+	if api.simulation != nil {
+		router.Handle(apiPrefix+"/simulation", headers(api.getSimulation))
+		router.Handle(apiPrefix+"/simulation/pause", headers(api.pauseSimulation)).Methods(http.MethodPost, http.MethodOptions)
+		router.Handle(apiPrefix+"/simulation/resume", headers(api.resumeSimulation)).Methods(http.MethodPost, http.MethodOptions)
+		router.Handle(apiPrefix+"/simulation/warp", headers(api.setWarp)).Methods(http.MethodPost, http.MethodOptions)
+		router.Handle(apiPrefix+"/simulation/jump", headers(api.jump)).Methods(http.MethodPost, http.MethodOptions)
+	}
 	return router
 }
 
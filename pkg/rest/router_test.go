@@ -2,13 +2,18 @@ package rest
 
 import (
 	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/adherence"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
 	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/pax"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func mockPublisher(position model.BusPosition) {
@@ -20,13 +25,19 @@ func gps(coordinate ...model.Coordinate) ([]model.Coordinate, float64, error) {
 }
 
 func TestNewRouter(t *testing.T) {
-	mdl, _ := model.Init("../model/testdata/wuerzburg(fictional)")
+	loaded, _ := model.Init("../model/testdata/wuerzburg(fictional)")
+	mdl, _ := model.ResolveShapes(loaded, gps)
 	config := RouterConfig{
 		LineModel:  mdl,
 		BusModel:   mdl,
+		StopModel:  mdl,
 		Dispatcher: bus.NewDispatcher(mdl, mockPublisher, gps),
+		Tracker:    adherence.NewTracker(),
+		Passengers: pax.NewSimulation(mdl.Buses(), pax.NewGenerator(mdl.Passengers(), nil, mdl.Start())),
 		Gps:        gps,
 	}
+	config.Dispatcher.PublishEvent = config.Tracker.Event
+	config.Dispatcher.Passengers = config.Passengers
 	go config.Dispatcher.Run(mdl.Start())
 	router := NewRouter(config)
 	server := httptest.NewServer(router)
@@ -107,6 +118,247 @@ func TestNewRouter(t *testing.T) {
 		assert.Equal(t, 11, len(route), "length of the route")
 		assert.Equal(t, []float64{49.7815846, 9.9356804}, route[7], "some coordinate of the route")
 	})
+	t.Run("get stops", func(t *testing.T) {
+		resp, err := http.Get(server.URL + apiPrefix + "/stops")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		var stops []restStop
+		err = json.NewDecoder(resp.Body).Decode(&stops)
+		require.NoError(t, err)
+		require.NotEmpty(t, stops, "stops")
+		assert.True(t, stops[0].Name <= stops[1].Name, "stops should be sorted by name")
+	})
+	t.Run("get stop", func(t *testing.T) {
+		resp, err := http.Get(server.URL + apiPrefix + "/stops/node/119865114")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		var stop stopDetails
+		err = json.NewDecoder(resp.Body).Decode(&stop)
+		require.NoError(t, err)
+		assert.Equal(t, model.StopId("node/119865114"), stop.Id, "stop id")
+		assert.Equal(t, "Busbahnhof (Bussteig 3)", stop.Name, "stop name")
+		require.NotEmpty(t, stop.Lines, "lines serving the stop")
+		assert.Equal(t, model.LineId("A-outbound"), stop.Lines[0].Id, "first line serving the stop")
+	})
+	t.Run("get stop 404", func(t *testing.T) {
+		resp, err := http.Get(server.URL + apiPrefix + "/stops/node/1/departures")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusNotFound)
+	})
+	t.Run("get departures", func(t *testing.T) {
+		resp, err := http.Get(server.URL + apiPrefix + "/stops/node/119865114/departures?from=18:00&limit=2")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		var result []departure
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(result), "number of departures")
+		assert.Equal(t, model.LineId("A-outbound"), result[0].Line.Id, "line of the first departure")
+		assert.Equal(t, model.MustParseTime("18:15"), result[0].Scheduled, "first departure")
+		assert.Equal(t, "Königsberger Straße", result[0].Destination, "destination")
+		assert.False(t, result[0].Realtime, "departure should not have a prediction")
+
+		resp, err = http.Get(server.URL + apiPrefix + "/stops/node/119865114/departures?limit=none")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusBadRequest)
+	})
+	t.Run("gtfs-rt vehicle positions", func(t *testing.T) {
+		resp, err := http.Get(server.URL + apiPrefix + "/gtfs-rt/vehicle-positions")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode, "status code")
+		assert.Equal(t, "application/x-protobuf", resp.Header.Get("Content-Type"), "Content-Type header")
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.NotEmpty(t, body, "feed should not be empty")
+	})
+	t.Run("passengers", func(t *testing.T) {
+		config.Passengers.Update(model.MustParseTime("23:59"))
+		resp, err := http.Get(server.URL + apiPrefix + "/passengers")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		var report pax.Report
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		require.NoError(t, err)
+		assert.Equal(t, len(mdl.Passengers()), report.Passengers, "number of passengers")
+		assert.Equal(t, report.Passengers-report.Unroutable, len(report.Journeys), "number of journeys")
+	})
+	t.Run("punctuality", func(t *testing.T) {
+		resp, err := http.Get(server.URL + apiPrefix + "/punctuality")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		var report adherence.Report
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		require.NoError(t, err)
+		assert.Equal(t, 180.0, report.Threshold, "threshold of the report")
+
+		resp, err = http.Get(server.URL + apiPrefix + "/buses/V1/adherence")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		var result busAdherence
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		require.NoError(t, err)
+		assert.Equal(t, model.BusId("V1"), result.Id, "id of the bus")
+		assert.NotNil(t, result.Visits, "visits of the bus")
+
+		resp, err = http.Get(server.URL + apiPrefix + "/buses/V42/adherence")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusNotFound)
+	})
+	t.Run("simulation control", func(t *testing.T) {
+		require.Eventually(t, func() bool { return config.Dispatcher.Status().State == bus.Running }, time.Second, time.Millisecond, "simulation should run")
+		status := postSimulation(t, server.URL+apiPrefix+"/simulation/pause", "", http.StatusOK)
+		assert.Equal(t, bus.Paused, status.State, "state after pausing")
+		postSimulation(t, server.URL+apiPrefix+"/simulation/pause", "", http.StatusConflict)
+		status = postSimulation(t, server.URL+apiPrefix+"/simulation/warp", `{"warp": 10}`, http.StatusOK)
+		assert.Equal(t, 10.0, status.Warp, "warp after change")
+		postSimulation(t, server.URL+apiPrefix+"/simulation/warp", `{"warp": -1}`, http.StatusConflict)
+		status = postSimulation(t, server.URL+apiPrefix+"/simulation/jump", `{"time": "6:20"}`, http.StatusOK)
+		assert.Equal(t, model.MustParseTime("6:20"), status.Time, "time after jump")
+		postSimulation(t, server.URL+apiPrefix+"/simulation/jump", `{"time": "noon"}`, http.StatusBadRequest)
+		resp, err := http.Get(server.URL + apiPrefix + "/simulation/checkpoint")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		checkpoint, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		postSimulation(t, server.URL+apiPrefix+"/simulation/jump", `{"time": "6:30"}`, http.StatusOK)
+		postSimulation(t, server.URL+apiPrefix+"/simulation/checkpoint", string(checkpoint), http.StatusConflict)
+		withoutPassengers := config
+		withoutPassengers.Passengers = nil
+		restoreServer := httptest.NewServer(NewRouter(withoutPassengers))
+		defer restoreServer.Close()
+		status = postSimulation(t, restoreServer.URL+apiPrefix+"/simulation/checkpoint", string(checkpoint), http.StatusOK)
+		assert.Equal(t, model.MustParseTime("6:20"), status.Time, "time after restoring the checkpoint")
+		postSimulation(t, restoreServer.URL+apiPrefix+"/simulation/checkpoint", `{"version": 0}`, http.StatusConflict)
+		status = postSimulation(t, server.URL+apiPrefix+"/simulation/resume", "", http.StatusOK)
+		assert.Equal(t, bus.Running, status.State, "state after resuming")
+
+		resp, err = http.Get(server.URL + apiPrefix + "/simulation")
+		require.NoError(t, err)
+		checkHeadersAndStatus(t, resp, http.StatusOK)
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		require.NoError(t, err)
+		assert.Equal(t, "simulation", status.Type, "type of the status")
+	})
+}
+
+type simulationStub struct {
+	status bus.Status
+}
+
+func (s *simulationStub) Status() bus.Status {
+	return s.status
+}
+
+func (s *simulationStub) Pause() error {
+	s.status.State = bus.Paused
+	return nil
+}
+
+func (s *simulationStub) Resume() error {
+	s.status.State = bus.Running
+	return nil
+}
+
+func (s *simulationStub) SetWarp(warp float64) error {
+	s.status.Warp = warp
+	return nil
+}
+
+func (s *simulationStub) JumpTo(target model.Time) error {
+	s.status.Time = target
+	return nil
+}
+
+func TestNewRouter_WithoutDispatcher(t *testing.T) {
+	simulation := &simulationStub{status: bus.Status{Type: "simulation", State: bus.Running, Warp: 1}}
+	server := httptest.NewServer(NewRouter(RouterConfig{Simulation: simulation, Tracker: adherence.NewTracker()}))
+	defer server.Close()
+
+	status := postSimulation(t, server.URL+apiPrefix+"/simulation/jump", `{"time": "6:20"}`, http.StatusOK)
+	assert.Equal(t, model.MustParseTime("6:20"), status.Time, "time after jump")
+	status = postSimulation(t, server.URL+apiPrefix+"/simulation/pause", "", http.StatusOK)
+	assert.Equal(t, bus.Paused, status.State, "state after pausing")
+	resp, err := http.Get(server.URL + apiPrefix + "/punctuality")
+	require.NoError(t, err)
+	checkHeadersAndStatus(t, resp, http.StatusOK)
+	for _, path := range []string{"/lines", "/stops", "/buses/V1/info", "/gtfs-rt/trip-updates", "/passengers"} {
+		resp, err := http.Get(server.URL + apiPrefix + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, "%s should not be served without models and dispatcher", path)
+	}
+}
+
+type assignmentsStub struct {
+	mdl model.BusModel
+}
+
+func (a assignmentsStub) QueryCurrentAssignment(id model.BusId) *model.Assignment {
+	bus, _ := a.mdl.Bus(id)
+	return &bus.Assignments[0]
+}
+
+func TestNewRouter_WithoutDispatcherWithModels(t *testing.T) {
+	mdl, err := model.Init("../model/testdata/wuerzburg(fictional)")
+	require.NoError(t, err)
+	simulation := &simulationStub{status: bus.Status{Type: "simulation", State: bus.Running, Warp: 1}}
+	config := RouterConfig{LineModel: mdl, BusModel: mdl, StopModel: mdl, Simulation: simulation, Assignments: assignmentsStub{mdl: mdl}, Tracker: adherence.NewTracker()}
+	server := httptest.NewServer(NewRouter(config))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + apiPrefix + "/buses/V1/info")
+	require.NoError(t, err)
+	checkHeadersAndStatus(t, resp, http.StatusOK)
+	var info busInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, "Busbahnhof - Residenz - Sanderau", info.Assignment, "assignment of the bus")
+	resp, err = http.Get(server.URL + apiPrefix + "/buses/V2/route")
+	require.NoError(t, err)
+	checkHeadersAndStatus(t, resp, http.StatusOK)
+	var route [][]float64
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&route))
+	bus2, _ := mdl.Bus("V2")
+	assert.Equal(t, len(bus2.Assignments[0].WayPoints), len(route), "way points should be connected directly without route service")
+	for _, path := range []string{"/lines", "/stops"} {
+		resp, err := http.Get(server.URL + apiPrefix + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "%s should be served with models", path)
+	}
+	for _, path := range []string{"/buses/V1/adherence", "/stops/node/248513451/departures", "/gtfs-rt/trip-updates"} {
+		resp, err := http.Get(server.URL + apiPrefix + path)
+		require.NoError(t, err)
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode, "%s should not be served without dispatcher", path)
+	}
+}
+
+func TestDepartures(t *testing.T) {
+	mdl, err := model.Init("../model/testdata/wuerzburg(fictional)")
+	require.NoError(t, err)
+	line, _ := mdl.Line("A-outbound")
+	bus1, _ := mdl.Bus("V1")
+	stop := model.StopId("node/534317115")
+	atStop := bus.State{Id: "V1", Assignment: &bus1.Assignments[0], NextWayPoint: 2, Delay: 2 * time.Minute}
+	got := departures(stop, []model.Line{line}, []bus.State{atStop}, model.MustParseTime("6:19"))
+	require.Equal(t, len(line.StartTimes()), len(got), "number of departures")
+	busId := model.BusId("V1")
+	assert.Equal(t, departure{Line: mapToRestLine(line), Destination: "Königsberger Straße", Scheduled: model.MustParseTime("6:18"), Expected: model.MustParseTime("6:20"), Delay: 120, Realtime: true, BusId: &busId}, got[0], "delayed departure")
+	assert.Equal(t, model.MustParseTime("6:38"), got[1].Expected, "next departure")
+
+	left := atStop
+	left.NextWayPoint = 3
+	got = departures(stop, []model.Line{line}, []bus.State{left}, model.MustParseTime("6:19"))
+	assert.Equal(t, model.MustParseTime("6:38"), got[0].Scheduled, "departure of bus that left should be omitted")
+}
+
+func postSimulation(t *testing.T, url string, body string, status int) bus.Status {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	checkHeadersAndStatus(t, resp, status)
+	var result bus.Status
+	if status == http.StatusOK {
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		require.NoError(t, err)
+	}
+	return result
 }
 
 func checkHeadersAndStatus(t *testing.T, r *http.Response, status int) {
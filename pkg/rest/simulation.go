@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"net/http"
+)
+
+type warpRequest struct {
+	Warp float64 `json:"warp"`
+}
+
+type jumpRequest struct {
+	Time string `json:"time"`
+}
+
+func (a *api) getSimulation(w http.ResponseWriter, r *http.Request) {
+	a.writeSimulationStatus(w)
+}
+
+func (a *api) pauseSimulation(w http.ResponseWriter, r *http.Request) {
+	err := a.simulation.Pause()
+	if err != nil {
+		errorResponse(w, http.StatusConflict, "could not pause simulation: %v", err)
+		return
+	}
+	a.writeSimulationStatus(w)
+}
+
+func (a *api) resumeSimulation(w http.ResponseWriter, r *http.Request) {
+	err := a.simulation.Resume()
+	if err != nil {
+		errorResponse(w, http.StatusConflict, "could not resume simulation: %v", err)
+		return
+	}
+	a.writeSimulationStatus(w)
+}
+
+func (a *api) setWarp(w http.ResponseWriter, r *http.Request) {
+	request := warpRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "could not parse request body: %v", err)
+		return
+	}
+	err = a.simulation.SetWarp(request.Warp)
+	if err != nil {
+		errorResponse(w, http.StatusConflict, "could not change warp: %v", err)
+		return
+	}
+	a.writeSimulationStatus(w)
+}
+
+func (a *api) jump(w http.ResponseWriter, r *http.Request) {
+	request := jumpRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "could not parse request body: %v", err)
+		return
+	}
+	target, err := model.ParseTime(request.Time)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, "could not parse time: %v", err)
+		return
+	}
+	err = a.simulation.JumpTo(target)
+	if err != nil {
+		errorResponse(w, http.StatusConflict, "could not jump: %v", err)
+		return
+	}
+	a.writeSimulationStatus(w)
+}
+
+func (a *api) writeSimulationStatus(w http.ResponseWriter) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(a.simulation.Status())
+}
@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/bus"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type stopDetails struct {
+	restStop
+	Lines []restLine `json:"lines"`
+}
+
+type departure struct {
+	Line        restLine     `json:"line"`
+	Destination string       `json:"destination"`
+	Scheduled   model.Time   `json:"scheduled"`
+	Expected    model.Time   `json:"expected"`
+	Delay       int          `json:"delay"`
+	Realtime    bool         `json:"realtime"`
+	BusId       *model.BusId `json:"busId,omitempty"`
+}
+
+const defaultDepartureLimit = 10
+
+func (a *api) getStops(w http.ResponseWriter, r *http.Request) {
+	stops := a.stopModel.Stops()
+	sort.Slice(stops, func(i, j int) bool {
+		if stops[i].Name != stops[j].Name {
+			return stops[i].Name < stops[j].Name
+		}
+		return *stops[i].Id < *stops[j].Id
+	})
+	result := make([]restStop, 0, len(stops))
+	for _, stop := range stops {
+		result = append(result, mapToRestStop(stop))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(result)
+}
+
+func (a *api) getStop(w http.ResponseWriter, r *http.Request) {
+	stop, ok := a.findStop(w, r)
+	if !ok {
+		return
+	}
+	result := stopDetails{restStop: mapToRestStop(stop), Lines: make([]restLine, 0)}
+	for _, line := range a.linesServing(*stop.Id) {
+		result.Lines = append(result.Lines, mapToRestLine(line))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(result)
+}
+
+func (a *api) getDepartures(w http.ResponseWriter, r *http.Request) {
+	stop, ok := a.findStop(w, r)
+	if !ok {
+		return
+	}
+	from := a.dispatcher.Now()
+	if rawFrom := r.URL.Query().Get("from"); rawFrom != "" {
+		parsed, err := model.ParseTime(rawFrom)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "could not parse parameter from: %v", err)
+			return
+		}
+		from = parsed
+	}
+	limit := defaultDepartureLimit
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed < 1 {
+			errorResponse(w, http.StatusBadRequest, "parameter limit must be a positive number, but was \"%s\"", rawLimit)
+			return
+		}
+		limit = parsed
+	}
+	result := departures(*stop.Id, a.linesServing(*stop.Id), a.dispatcher.QueryBusStates(), from)
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(result)
+}
+
+// departures computes the departures of the lines at the stop that are expected at or after the given time,
+// sorted by the expected time. A departure is expected with the current delay of the bus serving the tour. Tours that
+// are not served by a bus at the moment are expected as scheduled. Tours whose bus has already left the stop are omitted.
+func departures(stop model.StopId, lines []model.Line, states []bus.State, from model.Time) []departure {
+	result := make([]departure, 0)
+	for _, line := range lines {
+		stops := line.Stops()
+		destination := stops[len(stops)-1].Name
+		startTimes := line.StartTimes()
+		for tour, scheduled := range line.Departures(stop) {
+			if tour >= len(startTimes) {
+				// the timetable is inconsistent, see model.Validate
+				break
+			}
+			entry := departure{Line: mapToRestLine(line), Destination: destination, Scheduled: scheduled, Expected: scheduled}
+			state, ok := findTour(states, line.Id, startTimes[tour])
+			if ok {
+				if hasLeft(state, stop) {
+					continue
+				}
+				busId := state.Id
+				entry.BusId = &busId
+				entry.Realtime = true
+				// buses do not depart before the scheduled time, thus being early does not matter
+				if state.Delay > 0 {
+					entry.Delay = int(state.Delay / time.Second)
+					entry.Expected = scheduled.Add(state.Delay)
+				}
+			}
+			if entry.Expected.Before(from) {
+				continue
+			}
+			result = append(result, entry)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Expected != result[j].Expected {
+			return result[i].Expected.Before(result[j].Expected)
+		}
+		return result[i].Line.Id < result[j].Line.Id
+	})
+	return result
+}
+
+func findTour(states []bus.State, line model.LineId, start model.Time) (bus.State, bool) {
+	for _, state := range states {
+		assignment := state.Assignment
+		if assignment != nil && assignment.Line != nil && assignment.Line.Id == line && assignment.Departure == start {
+			return state, true
+		}
+	}
+	return bus.State{}, false
+}
+
+func hasLeft(state bus.State, stop model.StopId) bool {
+	for index, waypoint := range state.Assignment.WayPoints {
+		if waypoint.Id != nil && *waypoint.Id == stop {
+			return index < state.NextWayPoint
+		}
+	}
+	return false
+}
+
+func (a *api) linesServing(stop model.StopId) []model.Line {
+	result := make([]model.Line, 0)
+	for _, line := range a.lineModel.Lines() {
+		if line.Departures(stop) != nil {
+			result = append(result, line)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DefinitionIndex < result[j].DefinitionIndex
+	})
+	return result
+}
+
+func (a *api) findStop(w http.ResponseWriter, r *http.Request) (model.WayPoint, bool) {
+	id := mux.Vars(r)["key"]
+	stop, ok := a.stopModel.Stop(model.StopId(id))
+	if !ok {
+		errorResponse(w, http.StatusNotFound, "could not find stop with id \"%s\"", id)
+		return model.WayPoint{}, false
+	}
+	return stop, true
+}
+
+func mapToRestStop(stop model.WayPoint) restStop {
+	return restStop{Id: *stop.Id, Name: stop.Name, Latitude: stop.Latitude, Longitude: stop.Longitude}
+}
@@ -1,10 +1,29 @@
 package server
 
 import (
+	"encoding/json"
 	"github.com/gorilla/websocket"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
 )
 
+// JsonBatch is the name of the websocket subprotocol for receiving all messages of a batch as one JSON array.
+const JsonBatch = "ots.json.batch"
+
+// Codec encodes a batch of messages into one websocket frame.
+type Codec struct {
+	// Binary tells whether the frames are sent as binary or as text messages.
+	Binary bool
+	// Encode encodes the messages.
+	Encode func(messages []interface{}) ([]byte, error)
+}
+
+var jsonBatchCodec = Codec{Encode: func(messages []interface{}) ([]byte, error) {
+	return json.Marshal(messages)
+}}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  2024,
 	WriteBufferSize: 1024,
@@ -15,70 +34,290 @@ var upgrader = websocket.Upgrader{
 
 type client struct {
 	networkConnection *websocket.Conn
-	jsonSendChannel   chan interface{}
-	onUnregister      func(*client)
+	outbox            *outbox
+	codec             *Codec
+	subscriptions     subscriptions
+	container         *ClientContainer
+	done              chan struct{}
+	closeOnce         sync.Once
+}
+
+// close asks the writer of the client to send all queued messages and to close the connection afterwards.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// abort closes the connection immediately; queued messages are lost.
+func (c *client) abort() {
+	_ = c.networkConnection.Close()
+	c.close()
 }
 
 func (c *client) activateOutgoingMessages() {
+	ticker := time.NewTicker(c.container.PingInterval)
 	defer func() {
+		ticker.Stop()
 		_ = c.networkConnection.Close()
-		c.onUnregister(c)
+		c.container.unregister(c)
 	}()
 	for {
 		select {
-		case message, ok := <-c.jsonSendChannel:
-			if !ok {
-				_ = c.networkConnection.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.outbox.signal:
+			if !c.write() {
+				return
+			}
+		case <-ticker.C:
+			err := c.networkConnection.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(c.container.WriteTimeout))
+			if err != nil {
 				return
 			}
-			_ = c.networkConnection.WriteJSON(message)
+		case <-c.done:
+			c.outbox.flush()
+			if c.write() {
+				_ = c.networkConnection.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(c.container.WriteTimeout))
+			}
+			return
+		}
+	}
+}
+
+// write sends all queued messages to the client, either one by one as JSON or, if the client has negotiated a codec,
+// as one frame. It returns false if the connection is broken or the client does not receive the messages within
+// the write timeout.
+func (c *client) write() bool {
+	messages := c.outbox.take()
+	if c.codec == nil {
+		for _, message := range messages {
+			_ = c.networkConnection.SetWriteDeadline(time.Now().Add(c.container.WriteTimeout))
+			if err := c.networkConnection.WriteJSON(message); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+	if len(messages) == 0 {
+		return true
+	}
+	data, err := c.codec.Encode(messages)
+	if err != nil {
+		return false
+	}
+	messageType := websocket.TextMessage
+	if c.codec.Binary {
+		messageType = websocket.BinaryMessage
+	}
+	_ = c.networkConnection.SetWriteDeadline(time.Now().Add(c.container.WriteTimeout))
+	return c.networkConnection.WriteMessage(messageType, data) == nil
+}
+
+// activateIncomingMessages reads the subscription requests of the client until the connection is closed.
+// Every request is answered with the resulting subscriptions or with an error. Since the client must answer the pings
+// of the writer, the connection is regarded as broken if nothing is received within the pong timeout.
+func (c *client) activateIncomingMessages() {
+	defer c.abort()
+	c.networkConnection.SetReadLimit(4096)
+	_ = c.networkConnection.SetReadDeadline(time.Now().Add(c.container.PongTimeout))
+	c.networkConnection.SetPongHandler(func(string) error {
+		return c.networkConnection.SetReadDeadline(time.Now().Add(c.container.PongTimeout))
+	})
+	for {
+		_, data, err := c.networkConnection.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = c.networkConnection.SetReadDeadline(time.Now().Add(c.container.PongTimeout))
+		request := subscriptionRequest{}
+		err = json.Unmarshal(data, &request)
+		if err == nil {
+			err = c.subscriptions.apply(request)
+		}
+		var answer interface{} = c.subscriptions.message()
+		if err != nil {
+			answer = errorMessage{Type: "error", Error: err.Error()}
+		}
+		if !c.outbox.push(nil, answer) {
+			return
 		}
 	}
 }
 
 // ClientContainer manages all websocket clients and is responsible for sending updates to the client.
-// New client containers should be created with the NewClientContainer method.
+// Every client has its own queue of outgoing messages, thus publishing never blocks, even if clients are slow.
+// If a client's queue is full, the client's policy decides what happens, see Policy. Clients choose their policy
+// with the query parameter "policy" when connecting (e.g. /sockets?policy=coalesce).
+//
+// By default, every message is sent as its own JSON text message. Clients that request one of the Codecs as websocket
+// subprotocol receive batches instead: all updates published between two calls of Flush are sent as one frame.
+// New client containers should be created with the NewClientContainer method. The settings must not be changed
+// after the first client has connected.
 type ClientContainer struct {
+	mutex   sync.RWMutex
 	clients map[*client]bool
+	closed  bool
+	// DefaultPolicy is the policy of clients that do not choose a policy.
+	DefaultPolicy Policy
+	// QueueSize is the maximal number of queued messages per client.
+	QueueSize int
+	// MaxDropped is the number of messages that may be dropped for a client in a row before the client is disconnected.
+	MaxDropped int
+	// WriteTimeout is the time a client may take to receive a single message.
+	WriteTimeout time.Duration
+	// PingInterval is the interval in which the clients are pinged to keep the connection alive.
+	PingInterval time.Duration
+	// PongTimeout is the time after which a client is disconnected if nothing (e.g. no pong) has been received from it.
+	// It must be greater than the PingInterval.
+	PongTimeout time.Duration
+	// Snapshot returns the messages a client receives right after connecting, e.g. the current positions
+	// of all buses. Afterwards, the client receives all updates published after the snapshot has been taken. The
+	// snapshot is not synchronized with the publisher, thus these updates may repeat positions of the snapshot.
+	Snapshot func() []interface{}
+	// Codecs contains the codecs for batches by the names of their websocket subprotocols. By default,
+	// it contains the JsonBatch codec.
+	Codecs map[string]Codec
 }
 
 // NewClientContainer creates a new ClientContainer.
 func NewClientContainer() *ClientContainer {
-	return &ClientContainer{clients: make(map[*client]bool)}
+	return &ClientContainer{
+		clients:       make(map[*client]bool),
+		DefaultPolicy: Coalesce,
+		QueueSize:     256,
+		MaxDropped:    1024,
+		WriteTimeout:  10 * time.Second,
+		PingInterval:  30 * time.Second,
+		PongTimeout:   60 * time.Second,
+		Snapshot:      func() []interface{} { return nil },
+		Codecs:        map[string]Codec{JsonBatch: jsonBatchCodec},
+	}
 }
 
-// ServeHTTP registers new websocket clients to the container. All registered clients will receive updates
-// when the BroadcastJson method is called on the client container.
+// ServeHTTP registers new websocket clients to the container. New clients receive the snapshot first; afterwards,
+// all registered clients will receive updates when the BroadcastJson method is called on the client container. Clients can restrict the updates they receive
+// by sending subscription requests, see Publish.
 func (c *ClientContainer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	policy := c.DefaultPolicy
+	if value := request.URL.Query().Get("policy"); value != "" {
+		var err error
+		policy, err = parsePolicy(value)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	upgrader := upgrader
+	for subprotocol := range c.Codecs {
+		upgrader.Subprotocols = append(upgrader.Subprotocols, subprotocol)
+	}
+	sort.Strings(upgrader.Subprotocols)
 	conn, err := upgrader.Upgrade(writer, request, nil)
 	if err != nil {
 		// we do nothing here because the upgrader internally has already notified the client about the error.
 		return
 	}
+	var codec *Codec
+	if negotiated, ok := c.Codecs[conn.Subprotocol()]; ok {
+		codec = &negotiated
+	}
 	var client = &client{
 		networkConnection: conn,
-		jsonSendChannel:   make(chan interface{}, 256),
-		onUnregister: func(unregisteredClient *client) {
-			delete(c.clients, unregisteredClient)
-		},
+		outbox:            newOutbox(policy, c.QueueSize, c.MaxDropped, codec != nil),
+		codec:             codec,
+		container:         c,
+		done:              make(chan struct{}),
+	}
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		_ = conn.Close()
+		return
 	}
+	// the snapshot is queued while holding the lock, thus every update published after the snapshot reaches the client;
+	// updates computed before the snapshot, but published after it, reach the client as well
+	client.outbox.preload(c.Snapshot())
 	c.clients[client] = true
+	c.mutex.Unlock()
 
 	go client.activateOutgoingMessages()
+	go client.activateIncomingMessages()
+}
 
+func (c *ClientContainer) unregister(client *client) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.clients, client)
 }
 
-// BroadcastJson encodes the passed interface as JSON and sends it to all currently registered clients.
+func (c *ClientContainer) count() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.clients)
+}
+
+// BroadcastJson encodes the passed interface as JSON and sends it to all currently registered clients,
+// regardless of their subscriptions.
 func (c *ClientContainer) BroadcastJson(v interface{}) {
-	for client, _ := range c.clients {
-		client.jsonSendChannel <- v
+	c.Publish(nil, v)
+}
+
+// Publish encodes the passed interface as JSON and sends it to all currently registered clients whose subscriptions
+// match the topic. If the topic is nil, the message is sent to all clients. Publish never blocks; clients that
+// lag behind are treated according to their policy. Messages with topic belong to the current batch (see Flush),
+// messages without topic are sent to all clients immediately or, if a batch is incomplete, together with it.
+//
+// Clients manage their subscriptions by sending JSON messages such as {"action": "subscribe", "bus": "V1"},
+// {"action": "subscribe", "line": "A-outbound"} or {"action": "subscribe", "bbox": [south, west, north, east]}.
+// Subscriptions are removed with the action "unsubscribe"; an unsubscribe message without bus, line and bbox removes
+// all subscriptions. The container answers every request with the current subscriptions of the client, or with an
+// error message if the request is invalid, e.g. if the south of a bbox lies north of its north. Clients receive
+// a message such as {"type": "removal", "id": "V1"} when a bus stops matching their subscriptions, e.g. when it leaves
+// their bounding boxes; afterwards, they receive no positions of the bus until it matches again.
+func (c *ClientContainer) Publish(topic *Topic, v interface{}) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for client := range c.clients {
+		send, removal := client.subscriptions.filter(topic)
+		if send && !client.outbox.push(topic, v) {
+			client.abort()
+		}
+		if removal && !client.outbox.push(&Topic{Bus: topic.Bus, Discrete: true}, removalMessage{Type: "removal", Bus: topic.Bus}) {
+			client.abort()
+		}
+	}
+}
+
+// Remove tells all clients to stop showing the bus, e.g. because the bus is not in service anymore. Clients receive
+// the same removal message as for buses that stop matching their subscriptions (see Publish), but only if they
+// have not been told to remove the bus already.
+func (c *ClientContainer) Remove(bus string) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for client := range c.clients {
+		if client.subscriptions.hide(bus) && !client.outbox.push(&Topic{Bus: bus, Discrete: true}, removalMessage{Type: "removal", Bus: bus}) {
+			client.abort()
+		}
+	}
+}
+
+// Flush completes the current batch, i.e. all messages published since the last call of Flush are sent
+// to the clients that receive batches. It should be called after all updates of a simulation tick have been published.
+func (c *ClientContainer) Flush() {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for client := range c.clients {
+		client.outbox.flush()
 	}
 }
 
-// Close releases all client connections of the current clients.
+// Close sends all queued messages to the clients and closes their connections afterwards.
+// New clients are rejected after the container has been closed.
 func (c *ClientContainer) Close() error {
-	for client, _ := range c.clients {
-		close(client.jsonSendChannel)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	for client := range c.clients {
+		client.close()
 	}
 	return nil
 }
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestWebInterface_BroadcastJson(t *testing.T) {
@@ -68,3 +69,225 @@ func TestWebInterface_SocketHandler(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "Bad Request\n", string(message), "error message not correct")
 }
+
+func TestClientContainer_Publish(t *testing.T) {
+	container := NewClientContainer()
+	server := httptest.NewServer(container)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	subscribe := func(t *testing.T, connection *websocket.Conn, request string) map[string]interface{} {
+		require.NoError(t, connection.WriteMessage(websocket.TextMessage, []byte(request)))
+		var response map[string]interface{}
+		require.NoError(t, connection.ReadJSON(&response))
+		return response
+	}
+	busClient, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = busClient.Close() }()
+	response := subscribe(t, busClient, `{"action": "subscribe", "bus": "V1"}`)
+	assert.Equal(t, []interface{}{"V1"}, response["buses"], "subscribed buses")
+
+	areaClient, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = areaClient.Close() }()
+	subscribe(t, areaClient, `{"action": "subscribe", "line": "A"}`)
+	response = subscribe(t, areaClient, `{"action": "subscribe", "bbox": [49, 9, 50, 10]}`)
+	assert.Equal(t, []interface{}{"A"}, response["lines"], "subscribed lines")
+	assert.Equal(t, []interface{}{[]interface{}{49.0, 9.0, 50.0, 10.0}}, response["bboxes"], "subscribed bounding boxes")
+	response = subscribe(t, areaClient, `{"action": "subscribe", "bus": "V1", "line": "A"}`)
+	assert.Equal(t, "error", response["type"], "two topics in one request")
+
+	allClient, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = allClient.Close() }()
+	require.Eventually(t, func() bool { return container.count() == 3 }, time.Second, time.Millisecond, "clients should be registered")
+
+	inside := [2]float64{49.5, 9.5}
+	outside := [2]float64{48.5, 9.5}
+	container.Publish(&Topic{Bus: "V1", Line: "B", Location: &outside}, "V1 on B")
+	container.Publish(&Topic{Bus: "V2", Line: "A", Location: &outside}, "V2 on A")
+	container.Publish(&Topic{Bus: "V3", Line: "B", Location: &inside}, "V3 in box")
+	container.Publish(&Topic{Bus: "V4", Line: "B", Location: &outside}, "V4 elsewhere")
+	container.BroadcastJson("for everyone")
+
+	read := func(connection *websocket.Conn, count int) []interface{} {
+		result := make([]interface{}, 0, count)
+		for len(result) < count {
+			var message interface{}
+			require.NoError(t, connection.ReadJSON(&message))
+			result = append(result, message)
+		}
+		return result
+	}
+	removal := func(bus string) interface{} {
+		return map[string]interface{}{"type": "removal", "id": bus}
+	}
+	assert.Equal(t, []interface{}{"V1 on B", removal("V2"), removal("V3"), removal("V4"), "for everyone"}, read(busClient, 5), "messages of the bus client")
+	assert.Equal(t, []interface{}{removal("V1"), "V2 on A", "V3 in box", removal("V4"), "for everyone"}, read(areaClient, 5), "messages of the area client")
+	assert.Equal(t, []interface{}{"V1 on B", "V2 on A", "V3 in box", "V4 elsewhere", "for everyone"}, read(allClient, 5), "messages of the client without subscriptions")
+
+	container.Publish(&Topic{Bus: "V3", Line: "B", Location: &outside}, "V3 left the box")
+	container.Publish(&Topic{Bus: "V3", Line: "B", Location: &outside}, "V3 still outside")
+	container.Publish(&Topic{Bus: "V3", Line: "B", Location: &inside}, "V3 back in box")
+	assert.Equal(t, []interface{}{removal("V3"), "V3 back in box"}, read(areaClient, 2), "bus leaving and entering the box")
+
+	container.Remove("V2")
+	container.Publish(&Topic{Bus: "V2", Line: "B", Location: &outside}, "V2 on B")
+	container.Publish(&Topic{Bus: "V2", Line: "A", Location: &outside}, "V2 back on A")
+	assert.Equal(t, []interface{}{removal("V2"), "V2 back on A"}, read(areaClient, 2), "removed bus should not be removed twice")
+
+	response = subscribe(t, areaClient, `{"action": "subscribe", "bbox": [50, 9, 49, 10]}`)
+	assert.Equal(t, "bbox [50 9 49 10] is invalid: south must not be greater than north", response["error"], "south of the bbox north of its north")
+	response = subscribe(t, areaClient, `{"action": "subscribe", "bbox": [49, 10, 50, 9]}`)
+	assert.Equal(t, "bbox [49 10 50 9] is invalid: west must not be greater than east", response["error"], "west of the bbox east of its east")
+	response = subscribe(t, areaClient, `{"action": "subscribe", "bbox": [49, 9, 91, 10]}`)
+	assert.Equal(t, "bbox [49 9 91 10] exceeds the valid coordinates", response["error"], "bbox beyond the north pole")
+
+	response = subscribe(t, busClient, `{"action": "unsubscribe"}`)
+	assert.Equal(t, []interface{}{}, response["buses"], "buses after unsubscribing")
+}
+
+func TestClientContainer_SlowClient(t *testing.T) {
+	container := NewClientContainer()
+	container.WriteTimeout = 50 * time.Millisecond
+	server := httptest.NewServer(container)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// the slow client never reads, thus the network buffers fill up and writing to it times out
+	slowClient, _, err := websocket.DefaultDialer.Dial(url+"?policy=drop", nil)
+	require.NoError(t, err)
+	defer func() { _ = slowClient.Close() }()
+	require.Eventually(t, func() bool { return container.count() == 1 }, time.Second, time.Millisecond, "client should be registered")
+
+	message := strings.Repeat("x", 1<<16)
+	start := time.Now()
+	for i := 0; i < 2000; i++ {
+		container.BroadcastJson(message)
+	}
+	assert.True(t, time.Since(start) < time.Second, "publishing should not block")
+	require.Eventually(t, func() bool { return container.count() == 0 }, 5*time.Second, time.Millisecond, "slow client should be disconnected")
+}
+
+func TestClientContainer_Keepalive(t *testing.T) {
+	container := NewClientContainer()
+	container.PingInterval = 10 * time.Millisecond
+	container.PongTimeout = 50 * time.Millisecond
+	server := httptest.NewServer(container)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	pings := make(chan bool, 100)
+	client.SetPingHandler(func(data string) error {
+		pings <- true
+		return client.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		// reading is necessary to process the pings
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, container.count(), "client answering pings should stay connected")
+	assert.NotEmpty(t, pings, "client should have been pinged")
+
+	silent, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = silent.Close() }()
+	// the silent client does not read, thus it does not answer pings
+	require.Eventually(t, func() bool { return container.count() == 1 }, time.Second, time.Millisecond, "silent client should be disconnected")
+}
+
+func TestClientContainer_InvalidPolicy(t *testing.T) {
+	container := NewClientContainer()
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, httptest.NewRequest("GET", "http://127.0.0.1:8080/sockets?policy=ignore", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "response code")
+}
+
+func TestClientContainer_Snapshot(t *testing.T) {
+	container := NewClientContainer()
+	container.Snapshot = func() []interface{} {
+		return []interface{}{"V1 here", "V2 there"}
+	}
+	server := httptest.NewServer(container)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	require.Eventually(t, func() bool { return container.count() == 1 }, time.Second, time.Millisecond, "client should be registered")
+	container.BroadcastJson("update")
+	messages := make([]string, 3)
+	for index := range messages {
+		require.NoError(t, client.ReadJSON(&messages[index]))
+	}
+	assert.Equal(t, []string{"V1 here", "V2 there", "update"}, messages, "snapshot should be received before the updates")
+}
+
+func TestClientContainer_Batch(t *testing.T) {
+	container := NewClientContainer()
+	container.Snapshot = func() []interface{} {
+		return []interface{}{"V1 here", "V2 there"}
+	}
+	container.Codecs["test.binary"] = Codec{Binary: true, Encode: func(messages []interface{}) ([]byte, error) {
+		return []byte{byte(len(messages))}, nil
+	}}
+	server := httptest.NewServer(container)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	jsonClient, response, err := (&websocket.Dialer{Subprotocols: []string{JsonBatch}}).Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = jsonClient.Close() }()
+	assert.Equal(t, JsonBatch, response.Header.Get("Sec-Websocket-Protocol"), "negotiated subprotocol")
+	binaryClient, _, err := (&websocket.Dialer{Subprotocols: []string{"test.binary"}}).Dial(url, nil)
+	require.NoError(t, err)
+	defer func() { _ = binaryClient.Close() }()
+	require.Eventually(t, func() bool { return container.count() == 2 }, time.Second, time.Millisecond, "clients should be registered")
+
+	var batch []string
+	require.NoError(t, jsonClient.ReadJSON(&batch))
+	assert.Equal(t, []string{"V1 here", "V2 there"}, batch, "snapshot should be received as one batch")
+	messageType, data, err := binaryClient.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, messageType, "type of the binary frame")
+	assert.Equal(t, []byte{2}, data, "encoded snapshot")
+
+	container.Publish(&Topic{Bus: "V1"}, "V1 moved")
+	container.Publish(&Topic{Bus: "V2"}, "V2 moved")
+	container.Flush()
+	require.NoError(t, jsonClient.ReadJSON(&batch))
+	assert.Equal(t, []string{"V1 moved", "V2 moved"}, batch, "updates of one tick should be received as one batch")
+	_, data, err = binaryClient.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2}, data, "encoded updates")
+}
+
+func TestClientContainer_Discrete(t *testing.T) {
+	container := NewClientContainer()
+	server := httptest.NewServer(container)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	client, _, err := (&websocket.Dialer{Subprotocols: []string{JsonBatch}}).Dial(url+"?policy=coalesce", nil)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+	require.Eventually(t, func() bool { return container.count() == 1 }, time.Second, time.Millisecond, "client should be registered")
+
+	container.Publish(&Topic{Bus: "V1"}, "V1 moved")
+	container.Publish(&Topic{Bus: "V1", Discrete: true}, "V1 arrived")
+	container.Publish(&Topic{Bus: "V1"}, "V1 moved again")
+	container.Flush()
+	var batch []string
+	require.NoError(t, client.ReadJSON(&batch))
+	assert.Equal(t, []string{"V1 moved", "V1 arrived", "V1 moved again"}, batch, "discrete messages should keep their order")
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Policy defines what happens if a client does not keep up with the published messages, i.e. if its
+// queue of outgoing messages is full. Events and other messages that are not positions are never discarded by a
+// policy. They are queued beyond the capacity of the queue, but a client whose queue exceeds the capacity by more than
+// the number of positions it may drop is disconnected.
+type Policy string
+
+const (
+	// Drop discards new positions as long as the queue is full. Since dwelling buses do not publish their position
+	// again, a client may show a bus at a stale position until the bus departs.
+	Drop Policy = "drop"
+	// Coalesce replaces a queued position with a newer position of the same bus, such that the client only receives
+	// the latest position of each bus. New positions that cannot be coalesced are discarded if the queue is full.
+	// This is the default policy.
+	Coalesce Policy = "coalesce"
+	// Disconnect closes the connection to the client as soon as the queue is full.
+	Disconnect Policy = "disconnect"
+)
+
+func parsePolicy(value string) (Policy, error) {
+	switch policy := Policy(value); policy {
+	case Drop, Coalesce, Disconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown policy \"%s\", expected \"%s\", \"%s\" or \"%s\"", value, Drop, Coalesce, Disconnect)
+	}
+}
+
+type outgoing struct {
+	key     string
+	message interface{}
+}
+
+// outbox is the queue of outgoing messages of a client. In contrast to a buffered channel, pushing
+// to an outbox never blocks, thus a slow client cannot stall the publisher. If the outbox is batched, pushing
+// a batchable message does not signal the writer, instead, the writer is signaled by flush. Only the messages
+// before the incomplete batch, if any, are ready to be taken by the writer.
+type outbox struct {
+	mutex      sync.Mutex
+	policy     Policy
+	batched    bool
+	capacity   int
+	maxDropped int
+	messages   []outgoing
+	ready      int
+	keys       map[string]int
+	dropped    int
+	signal     chan struct{}
+}
+
+func newOutbox(policy Policy, capacity int, maxDropped int, batched bool) *outbox {
+	return &outbox{policy: policy, capacity: capacity, maxDropped: maxDropped, batched: batched, keys: make(map[string]int), signal: make(chan struct{}, 1)}
+}
+
+// push queues the message. Only positions, i.e. messages with a topic that is not discrete, can be coalesced with
+// positions of the same bus or dropped; all other messages are kept in the order they were pushed. A position is never
+// coalesced with a position queued before a discrete message of the same bus. Messages without topic are not
+// batchable; if a batch is incomplete, they are sent together with it to keep the batch in one frame. The method
+// returns false if the client lags behind too much and should be disconnected, i.e. if the queue is full and the
+// policy is Disconnect, if more than maxDropped positions have been dropped since the client last took messages, or
+// if the queue exceeds its capacity by more than maxDropped messages.
+func (o *outbox) push(topic *Topic, message interface{}) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	position := topic != nil && !topic.Discrete
+	bus := ""
+	if topic != nil {
+		bus = topic.Bus
+	}
+	if index, ok := o.keys[bus]; ok && position && o.policy == Coalesce {
+		o.messages[index].message = message
+		return true
+	}
+	if len(o.messages) >= o.capacity {
+		if o.policy == Disconnect {
+			return false
+		}
+		if position {
+			o.dropped = o.dropped + 1
+			return o.dropped <= o.maxDropped
+		}
+		if len(o.messages) >= o.capacity+o.maxDropped {
+			return false
+		}
+	}
+	if position && bus != "" {
+		o.keys[bus] = len(o.messages)
+	} else {
+		delete(o.keys, bus)
+	}
+	complete := o.ready == len(o.messages)
+	o.messages = append(o.messages, outgoing{key: bus, message: message})
+	if !o.batched || (topic == nil && complete) {
+		o.ready = len(o.messages)
+		o.notify()
+	}
+	return true
+}
+
+// preload queues the messages regardless of the capacity of the outbox. It is meant for messages that must not get
+// lost, such as the snapshot of a new client. It must be called before the first push.
+func (o *outbox) preload(messages []interface{}) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, message := range messages {
+		o.messages = append(o.messages, outgoing{message: message})
+	}
+	o.ready = len(o.messages)
+	if len(messages) > 0 {
+		o.notify()
+	}
+}
+
+// flush signals the writer that the current batch is complete.
+func (o *outbox) flush() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.ready = len(o.messages)
+	if o.ready > 0 {
+		o.notify()
+	}
+}
+
+func (o *outbox) notify() {
+	select {
+	case o.signal <- struct{}{}:
+	default:
+	}
+}
+
+// take removes all queued messages that are ready from the outbox and returns them. The messages of an incomplete
+// batch stay in the outbox.
+func (o *outbox) take() []interface{} {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	result := make([]interface{}, 0, o.ready)
+	for _, message := range o.messages[:o.ready] {
+		result = append(result, message.message)
+	}
+	o.messages = append([]outgoing(nil), o.messages[o.ready:]...)
+	for key, index := range o.keys {
+		if index < o.ready {
+			delete(o.keys, key)
+		} else {
+			o.keys[key] = index - o.ready
+		}
+	}
+	o.ready = 0
+	o.dropped = 0
+	return result
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOutbox_Push(t *testing.T) {
+	position := &Topic{Bus: "V1"}
+	event := &Topic{Bus: "V1", Discrete: true}
+	t.Run("drop", func(t *testing.T) {
+		box := newOutbox(Drop, 2, 1, false)
+		assert.True(t, box.push(position, 1), "first message")
+		assert.True(t, box.push(position, 2), "second message")
+		assert.True(t, box.push(position, 3), "third message should be dropped")
+		assert.False(t, box.push(position, 4), "client should lag behind too much")
+		assert.Equal(t, []interface{}{1, 2}, box.take(), "queued messages")
+		assert.True(t, box.push(position, 5), "client should have caught up")
+	})
+	t.Run("drop events", func(t *testing.T) {
+		box := newOutbox(Drop, 1, 2, false)
+		assert.True(t, box.push(position, 1), "first message")
+		assert.True(t, box.push(event, "arrived"), "event should not be dropped")
+		assert.True(t, box.push(nil, "status"), "message without topic should not be dropped")
+		assert.True(t, box.push(position, 2), "position should be dropped")
+		assert.Equal(t, []interface{}{1, "arrived", "status"}, box.take(), "queued messages")
+	})
+	t.Run("too many events", func(t *testing.T) {
+		box := newOutbox(Coalesce, 1, 1, false)
+		assert.True(t, box.push(event, "arrived"), "first event")
+		assert.True(t, box.push(event, "departed"), "event beyond the capacity")
+		assert.False(t, box.push(nil, "status"), "client should lag behind too much")
+	})
+	t.Run("coalesce", func(t *testing.T) {
+		box := newOutbox(Coalesce, 2, 0, false)
+		assert.True(t, box.push(position, 1), "first message")
+		assert.True(t, box.push(nil, "status"), "message without key")
+		assert.True(t, box.push(position, 2), "message should be coalesced")
+		assert.False(t, box.push(&Topic{Bus: "V2"}, 3), "message of other bus cannot be coalesced")
+		assert.Equal(t, []interface{}{2, "status"}, box.take(), "coalesced message should keep its position")
+	})
+	t.Run("coalesce events", func(t *testing.T) {
+		box := newOutbox(Coalesce, 10, 0, false)
+		box.push(position, 1)
+		box.push(event, "arrived")
+		box.push(position, 2)
+		box.push(position, 3)
+		assert.Equal(t, []interface{}{1, "arrived", 3}, box.take(), "positions should not overtake events")
+	})
+	t.Run("disconnect", func(t *testing.T) {
+		box := newOutbox(Disconnect, 1, 100, false)
+		assert.True(t, box.push(position, 1), "first message")
+		assert.False(t, box.push(position, 2), "client should be disconnected")
+	})
+}
+
+func TestOutbox_Preload(t *testing.T) {
+	box := newOutbox(Drop, 1, 0, false)
+	box.preload([]interface{}{1, 2, 3})
+	assert.False(t, box.push(&Topic{Bus: "V1"}, 4), "outbox should be full")
+	assert.Equal(t, []interface{}{1, 2, 3}, box.take(), "preloaded messages should exceed the capacity")
+}
+
+func TestOutbox_Flush(t *testing.T) {
+	box := newOutbox(Drop, 10, 0, true)
+	box.push(&Topic{Bus: "V1"}, 1)
+	box.push(&Topic{Bus: "V2"}, 2)
+	assert.Equal(t, 0, len(box.signal), "writer should not be signaled before the batch is complete")
+	box.flush()
+	assert.Equal(t, 1, len(box.signal), "writer should be signaled after the batch is complete")
+	<-box.signal
+	assert.Equal(t, []interface{}{1, 2}, box.take(), "complete batch")
+	box.push(nil, "status")
+	assert.Equal(t, 1, len(box.signal), "messages that are not batchable should be sent immediately")
+	<-box.signal
+	assert.Equal(t, []interface{}{"status"}, box.take(), "message without topic")
+
+	box.push(&Topic{Bus: "V1"}, 3)
+	box.push(nil, "subscriptions")
+	assert.Equal(t, 0, len(box.signal), "messages that are not batchable should wait for an incomplete batch")
+	assert.Equal(t, []interface{}{}, box.take(), "incomplete batch should stay in the outbox")
+	box.push(&Topic{Bus: "V2"}, 4)
+	box.flush()
+	assert.Equal(t, []interface{}{3, "subscriptions", 4}, box.take(), "batch with the message without topic")
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := parsePolicy("coalesce")
+	assert.NoError(t, err)
+	assert.Equal(t, Coalesce, policy, "parsed policy")
+	_, err = parsePolicy("ignore")
+	assert.EqualError(t, err, "unknown policy \"ignore\", expected \"drop\", \"coalesce\" or \"disconnect\"")
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Topic describes what a published message is about. Clients that have subscribed to buses, lines or bounding boxes
+// only receive messages whose topic matches at least one of their subscriptions. Clients without subscriptions receive all messages.
+type Topic struct {
+	Bus  string
+	Line string
+	// Location is the location ([lat, lon]) the message refers to. It is nil if the message has no location.
+	Location *[2]float64
+	// Discrete marks messages that must never be coalesced with other messages of the bus or dropped, such as events.
+	Discrete bool
+}
+
+// subscriptionRequest is sent by the clients. Action is either "subscribe" or "unsubscribe". Exactly one
+// of Bus, Line and BoundingBox must be set. An unsubscribe request without any of them removes all subscriptions.
+type subscriptionRequest struct {
+	Action      string      `json:"action"`
+	Bus         string      `json:"bus,omitempty"`
+	Line        string      `json:"line,omitempty"`
+	BoundingBox *[4]float64 `json:"bbox,omitempty"`
+}
+
+type subscriptionMessage struct {
+	Type          string       `json:"type"`
+	Buses         []string     `json:"buses"`
+	Lines         []string     `json:"lines"`
+	BoundingBoxes [][4]float64 `json:"bboxes"`
+}
+
+// removalMessage tells a client that it does not receive the positions of a bus anymore and should stop showing it.
+type removalMessage struct {
+	Type string `json:"type"`
+	Bus  string `json:"id"`
+}
+
+type errorMessage struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// subscriptions stores the topics a client is interested in. Bounding boxes are given as [south, west, north, east].
+// Additionally, it remembers the buses whose positions have stopped matching, i.e. the buses the client does not show.
+type subscriptions struct {
+	mutex         sync.RWMutex
+	buses         []string
+	lines         []string
+	boundingBoxes [][4]float64
+	hidden        map[string]bool
+}
+
+func (s *subscriptions) apply(request subscriptionRequest) error {
+	given := 0
+	for _, set := range []bool{request.Bus != "", request.Line != "", request.BoundingBox != nil} {
+		if set {
+			given = given + 1
+		}
+	}
+	if given > 1 || (given == 0 && request.Action != "unsubscribe") {
+		return fmt.Errorf("exactly one of bus, line and bbox must be given")
+	}
+	if box := request.BoundingBox; box != nil && request.Action == "subscribe" {
+		if err := validateBoundingBox(*box); err != nil {
+			return err
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch request.Action {
+	case "subscribe":
+		if request.Bus != "" {
+			s.buses = appendUnique(s.buses, request.Bus)
+		} else if request.Line != "" {
+			s.lines = appendUnique(s.lines, request.Line)
+		} else {
+			s.boundingBoxes = append(s.boundingBoxes, *request.BoundingBox)
+		}
+	case "unsubscribe":
+		if given == 0 {
+			s.buses, s.lines, s.boundingBoxes = nil, nil, nil
+		} else if request.Bus != "" {
+			s.buses = remove(s.buses, request.Bus)
+		} else if request.Line != "" {
+			s.lines = remove(s.lines, request.Line)
+		} else {
+			boxes := make([][4]float64, 0, len(s.boundingBoxes))
+			for _, box := range s.boundingBoxes {
+				if box != *request.BoundingBox {
+					boxes = append(boxes, box)
+				}
+			}
+			s.boundingBoxes = boxes
+		}
+	default:
+		return fmt.Errorf("unknown action \"%s\", expected \"subscribe\" or \"unsubscribe\"", request.Action)
+	}
+	return nil
+}
+
+func validateBoundingBox(box [4]float64) error {
+	south, west, north, east := box[0], box[1], box[2], box[3]
+	if south < -90 || north > 90 || west < -180 || east > 180 {
+		return fmt.Errorf("bbox %v exceeds the valid coordinates", box)
+	}
+	if south > north {
+		return fmt.Errorf("bbox %v is invalid: south must not be greater than north", box)
+	}
+	if west > east {
+		return fmt.Errorf("bbox %v is invalid: west must not be greater than east", box)
+	}
+	return nil
+}
+
+// filter tells whether a message with the given topic is sent to the client. Additionally, it tells whether
+// the client must be told to remove the bus of the topic, which is the case for the first position of a bus
+// that does not match anymore, e.g. because the bus has left all bounding boxes.
+func (s *subscriptions) filter(topic *Topic) (send bool, removal bool) {
+	send = s.matches(topic)
+	if topic == nil || topic.Discrete || topic.Bus == "" {
+		return send, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if send {
+		delete(s.hidden, topic.Bus)
+		return true, false
+	}
+	if s.hidden[topic.Bus] {
+		return false, false
+	}
+	s.hideLocked(topic.Bus)
+	return false, true
+}
+
+// hide remembers that the client does not show the bus, e.g. because the client has been told to remove it.
+// It returns false if the bus has been hidden already.
+func (s *subscriptions) hide(bus string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.hidden[bus] {
+		return false
+	}
+	s.hideLocked(bus)
+	return true
+}
+
+// hideLocked works like hide, but must be called while holding the mutex.
+func (s *subscriptions) hideLocked(bus string) {
+	if s.hidden == nil {
+		s.hidden = make(map[string]bool)
+	}
+	s.hidden[bus] = true
+}
+
+func (s *subscriptions) matches(topic *Topic) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if topic == nil || (len(s.buses) == 0 && len(s.lines) == 0 && len(s.boundingBoxes) == 0) {
+		return true
+	}
+	for _, bus := range s.buses {
+		if bus == topic.Bus {
+			return true
+		}
+	}
+	for _, line := range s.lines {
+		if line == topic.Line {
+			return true
+		}
+	}
+	if topic.Location == nil {
+		return false
+	}
+	lat, lon := topic.Location[0], topic.Location[1]
+	for _, box := range s.boundingBoxes {
+		if box[0] <= lat && lat <= box[2] && box[1] <= lon && lon <= box[3] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *subscriptions) message() subscriptionMessage {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := subscriptionMessage{Type: "subscriptions", Buses: []string{}, Lines: []string{}, BoundingBoxes: [][4]float64{}}
+	result.Buses = append(result.Buses, s.buses...)
+	result.Lines = append(result.Lines, s.lines...)
+	result.BoundingBoxes = append(result.BoundingBoxes, s.boundingBoxes...)
+	return result
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func remove(values []string, value string) []string {
+	result := make([]string, 0, len(values))
+	for _, existing := range values {
+		if existing != value {
+			result = append(result, existing)
+		}
+	}
+	return result
+}
@@ -0,0 +1,30 @@
+package sink
+
+import (
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/record"
+	"os"
+)
+
+type file struct {
+	*record.Recorder
+	file *os.File
+}
+
+// NewFile creates a sink recording the output to the file with the given path, see record.Recorder.
+func NewFile(path string, clock func() model.Time) (Sink, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create output file: %v", err)
+	}
+	return &file{Recorder: record.NewRecorder(out, record.FormatOf(path), clock), file: out}, nil
+}
+
+func (f *file) Close() error {
+	err := f.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/record"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mqttQueueSize = 4096
+	mqttKeepAlive = 60 * time.Second
+	mqttTimeout   = 5 * time.Second
+)
+
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+type mqtt struct {
+	address  string
+	prefix   string
+	clock    func() model.Time
+	client   paho.Client
+	messages chan mqttMessage
+	done     chan struct{}
+	dropped  int64
+	blocking bool
+}
+
+// NewMqtt creates a sink publishing the output as JSON encoded record.Entry to the MQTT broker with the given
+// address (host:port). Positions are published to the topic <prefix>/positions/<bus id>, events of buses to
+// <prefix>/events/<bus id>, and events of the whole run, such as RunStarted, to <prefix>/run. The messages are
+// published with QoS 0 by a background goroutine; if the broker cannot keep up, messages are dropped, unless blocking
+// is true, in which case publishing waits for the broker. If the connection breaks, the sink reconnects. The method
+// fails if the broker cannot be reached initially.
+func NewMqtt(address string, prefix string, blocking bool, clock func() model.Time) (Sink, error) {
+	options := paho.NewClientOptions().
+		AddBroker("tcp://" + address).
+		SetClientID(fmt.Sprintf("ots-%d", time.Now().UnixNano())).
+		SetKeepAlive(mqttKeepAlive).
+		SetConnectTimeout(mqttTimeout).
+		SetWriteTimeout(mqttTimeout).
+		SetAutoReconnect(true)
+	client := paho.NewClient(options)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("could not connect to mqtt broker \"%s\": timeout", address)
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("could not connect to mqtt broker \"%s\": %v", address, token.Error())
+	}
+	result := &mqtt{
+		address:  address,
+		prefix:   prefix,
+		clock:    clock,
+		client:   client,
+		messages: make(chan mqttMessage, mqttQueueSize),
+		done:     make(chan struct{}),
+		blocking: blocking,
+	}
+	go result.run()
+	return result, nil
+}
+
+func (m *mqtt) Position(position model.BusPosition) {
+	m.publish("positions/"+string(position.BusId), record.Entry{Time: m.clock(), Position: &position})
+}
+
+func (m *mqtt) Event(event model.Event) {
+	topic := "run"
+	if event.BusId != "" {
+		topic = "events/" + string(event.BusId)
+	}
+	m.publish(topic, record.Entry{Time: event.Time, Event: &event})
+}
+
+// Close publishes all queued messages and disconnects from the broker. It returns an error if messages have been
+// dropped. Neither Position nor Event must be called afterwards.
+func (m *mqtt) Close() error {
+	close(m.messages)
+	<-m.done
+	m.client.Disconnect(uint(mqttTimeout / time.Millisecond))
+	if dropped := atomic.LoadInt64(&m.dropped); dropped > 0 {
+		return fmt.Errorf("%d messages could not be published to mqtt broker \"%s\"", dropped, m.address)
+	}
+	return nil
+}
+
+func (m *mqtt) publish(topic string, entry record.Entry) {
+	if m.prefix != "" {
+		topic = m.prefix + "/" + topic
+	}
+	payload, _ := json.Marshal(entry)
+	if m.blocking {
+		m.messages <- mqttMessage{topic: topic, payload: payload}
+		return
+	}
+	select {
+	case m.messages <- mqttMessage{topic: topic, payload: payload}:
+	default:
+		atomic.AddInt64(&m.dropped, 1)
+	}
+}
+
+// run hands the queued messages to the client until the sink is closed. Messages that cannot be published,
+// e.g. while the client reconnects, are dropped.
+func (m *mqtt) run() {
+	defer close(m.done)
+	for message := range m.messages {
+		token := m.client.Publish(message.topic, 0, false, message.payload)
+		if !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+			atomic.AddInt64(&m.dropped, 1)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/record"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// disconnectHook reports the disconnects of the clients of the embedded broker.
+type disconnectHook struct {
+	mqttserver.HookBase
+	disconnected chan bool
+}
+
+func (h *disconnectHook) ID() string {
+	return "disconnect"
+}
+
+func (h *disconnectHook) Provides(b byte) bool {
+	return b == mqttserver.OnDisconnect
+}
+
+func (h *disconnectHook) OnDisconnect(_ *mqttserver.Client, _ error, _ bool) {
+	h.disconnected <- true
+}
+
+// startBroker starts an embedded MQTT broker accepting all connections. The messages published below the topic
+// ots are reported on the returned channel.
+func startBroker(t *testing.T) (*mqttserver.Server, string, chan message, chan bool) {
+	server := mqttserver.New(&mqttserver.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	require.NoError(t, server.AddHook(new(auth.AllowHook), nil))
+	disconnected := make(chan bool, 1)
+	require.NoError(t, server.AddHook(&disconnectHook{disconnected: disconnected}, nil))
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	require.NoError(t, server.AddListener(tcp))
+	messages := make(chan message, 100)
+	require.NoError(t, server.Subscribe("ots/#", 1, func(_ *mqttserver.Client, _ packets.Subscription, pk packets.Packet) {
+		messages <- message{topic: pk.TopicName, payload: pk.Payload}
+	}))
+	go func() {
+		_ = server.Serve()
+	}()
+	return server, tcp.Address(), messages, disconnected
+}
+
+func TestNewMqtt(t *testing.T) {
+	broker, address, messages, disconnected := startBroker(t)
+	defer broker.Close()
+
+	now := model.MustParseTime("6:00")
+	sink, err := Open("mqtt://"+address+"/ots", false, func() model.Time { return now })
+	require.NoError(t, err)
+	sink.Position(model.BusPosition{BusId: "V1", Location: [2]float64{49.5, 9.25}})
+	sink.Event(model.Event{Type: model.Arrival, BusId: "V2", Time: now})
+	sink.Event(model.Event{Type: model.RunStarted, Time: now})
+	require.NoError(t, sink.Close())
+
+	received := func() message {
+		select {
+		case result := <-messages:
+			return result
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "broker did not receive message")
+			return message{}
+		}
+	}
+	first := received()
+	assert.Equal(t, "ots/positions/V1", first.topic, "topic of the position")
+	var entry record.Entry
+	require.NoError(t, json.Unmarshal(first.payload, &entry))
+	assert.Equal(t, now, entry.Time, "time of the position")
+	assert.Equal(t, model.BusId("V1"), entry.Position.BusId, "bus of the position")
+	second := received()
+	assert.Equal(t, "ots/events/V2", second.topic, "topic of the event")
+	third := received()
+	assert.Equal(t, "ots/run", third.topic, "topic of the event without bus")
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "sink should disconnect when closed")
+	}
+
+	_, err = NewMqtt("127.0.0.1:1", "", false, func() model.Time { return now })
+	assert.Error(t, err, "broker cannot be reached")
+}
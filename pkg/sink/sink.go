@@ -0,0 +1,80 @@
+// Package sink delivers the output of a simulation, i.e. the positions and events of the buses, to other systems
+// such as files, MQTT brokers, or webhooks. Several sinks can be combined with FanOut.
+package sink
+
+import (
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"net/url"
+	"strings"
+)
+
+// Sink receives the positions and events of a simulation. The Position and Event methods match
+// model.Publisher and model.EventPublisher, respectively; they must not block the simulation for long, unless the sink
+// has been opened as blocking sink (see Open).
+// Close delivers all pending output and releases the resources of the sink.
+type Sink interface {
+	Position(position model.BusPosition)
+	Event(event model.Event)
+	Close() error
+}
+
+// FanOut forwards the positions and events to all contained sinks.
+type FanOut []Sink
+
+// Position forwards the position to all sinks.
+func (f FanOut) Position(position model.BusPosition) {
+	for _, sink := range f {
+		sink.Position(position)
+	}
+}
+
+// Event forwards the event to all sinks.
+func (f FanOut) Event(event model.Event) {
+	for _, sink := range f {
+		sink.Event(event)
+	}
+}
+
+// Close closes all sinks and returns the first error that occurred.
+func (f FanOut) Close() error {
+	var result error
+	for _, sink := range f {
+		if err := sink.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// Open creates the sink described by the specification:
+//
+//	file:<path>                    writes the output to the file (CSV if the path ends with .csv, JSONL otherwise)
+//	mqtt://<host>:<port>/<prefix>  publishes the output to the MQTT broker below the topic prefix
+//	http(s)://…                    posts the output in batches to the webhook
+//
+// The clock is queried for the simulation time of the positions. If blocking is true, the MQTT and webhook sinks
+// never drop output but slow the simulation down if they cannot keep up, which is needed for headless runs.
+func Open(specification string, blocking bool, clock func() model.Time) (Sink, error) {
+	if strings.HasPrefix(specification, "file:") {
+		return NewFile(strings.TrimPrefix(specification, "file:"), clock)
+	}
+	parsed, err := url.Parse(specification)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse sink \"%s\": %v", specification, err)
+	}
+	switch parsed.Scheme {
+	case "mqtt":
+		port := parsed.Port()
+		if port == "" {
+			port = "1883"
+		}
+		return NewMqtt(parsed.Hostname()+":"+port, strings.Trim(parsed.Path, "/"), blocking, clock)
+	case "http", "https":
+		webhook := NewWebhook(specification, clock)
+		webhook.Blocking = blocking
+		return webhook, nil
+	default:
+		return nil, fmt.Errorf("unknown sink \"%s\", expected \"file:<path>\", \"mqtt://<host>:<port>/<prefix>\" or an http(s) URL", specification)
+	}
+}
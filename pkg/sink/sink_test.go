@@ -0,0 +1,38 @@
+package sink
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	directory, err := ioutil.TempDir("", "sinks")
+	require.NoError(t, err)
+	defer os.RemoveAll(directory)
+	clock := func() model.Time { return model.MustParseTime("6:00") }
+
+	path := filepath.Join(directory, "output.csv")
+	first, err := Open("file:"+path, false, clock)
+	require.NoError(t, err)
+	second, err := Open("file:"+filepath.Join(directory, "output.jsonl"), false, clock)
+	require.NoError(t, err)
+	sinks := FanOut{first, second}
+	sinks.Position(model.BusPosition{BusId: "V1", Location: [2]float64{49.5, 9.25}})
+	require.NoError(t, sinks.Close())
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "time,type,bus,lat,lon,stop,scheduled,seed,demandSeed\n21600000,position,V1,49.5,9.25,,,,\n", string(content), "content of the file")
+
+	webhook, err := Open("https://example.com/hook", true, clock)
+	require.NoError(t, err)
+	assert.IsType(t, &Webhook{}, webhook, "type of the http sink")
+	assert.True(t, webhook.(*Webhook).Blocking, "blocking webhook")
+
+	_, err = Open("ftp://example.com", false, clock)
+	assert.EqualError(t, err, "unknown sink \"ftp://example.com\", expected \"file:<path>\", \"mqtt://<host>:<port>/<prefix>\" or an http(s) URL")
+}
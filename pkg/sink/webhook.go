@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/record"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webhookPendingBatches is the number of full batches that wait for being posted while a post is retried.
+const webhookPendingBatches = 4
+
+// Webhook is a sink posting the output in batches to a URL. Every batch is a JSON array of record.Entry. Failed
+// posts are retried with exponential backoff, unless the webhook rejects the batch with a client error (4xx except 429).
+// The entries are collected and posted by two background goroutines, thus the entries keep being collected while a
+// post is retried. If the webhook cannot keep up, entries are dropped, unless Blocking is set.
+// New webhooks should be created with NewWebhook. The settings must not be changed after the first entry has been published.
+type Webhook struct {
+	url     string
+	clock   func() model.Time
+	entries chan record.Entry
+	batches chan []record.Entry
+	done    chan struct{}
+	start   sync.Once
+	lost    int64
+	// BatchSize is the maximal number of entries in one batch.
+	BatchSize int
+	// FlushInterval is the maximal time an entry waits until it is posted.
+	FlushInterval time.Duration
+	// Retries is the number of retries of a failed post.
+	Retries int
+	// Backoff is the time to wait before the first retry. It doubles with every further retry.
+	Backoff time.Duration
+	// Client is used for posting the batches.
+	Client *http.Client
+	// Blocking lets Position and Event wait until the entry can be queued instead of dropping it. This slows the
+	// simulation down to the pace of the webhook, which is needed for headless runs.
+	Blocking bool
+}
+
+// NewWebhook creates a webhook sink posting to the given URL. The clock is queried for the simulation time
+// of the positions.
+func NewWebhook(url string, clock func() model.Time) *Webhook {
+	return &Webhook{
+		url:           url,
+		clock:         clock,
+		entries:       make(chan record.Entry, 4096),
+		batches:       make(chan []record.Entry, webhookPendingBatches),
+		done:          make(chan struct{}),
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		Retries:       3,
+		Backoff:       500 * time.Millisecond,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Position queues the position for posting.
+func (w *Webhook) Position(position model.BusPosition) {
+	w.queue(record.Entry{Time: w.clock(), Position: &position})
+}
+
+// Event queues the event for posting.
+func (w *Webhook) Event(event model.Event) {
+	w.queue(record.Entry{Time: event.Time, Event: &event})
+}
+
+// Close posts all queued entries. It returns an error if entries have been lost, either because they were dropped
+// or because the webhook did not accept them. Neither Position nor Event must be called afterwards.
+func (w *Webhook) Close() error {
+	w.start.Do(w.startWorkers)
+	close(w.entries)
+	<-w.done
+	if lost := atomic.LoadInt64(&w.lost); lost > 0 {
+		return fmt.Errorf("%d entries could not be posted to webhook \"%s\"", lost, w.url)
+	}
+	return nil
+}
+
+func (w *Webhook) queue(entry record.Entry) {
+	w.start.Do(w.startWorkers)
+	if w.Blocking {
+		w.entries <- entry
+		return
+	}
+	select {
+	case w.entries <- entry:
+	default:
+		atomic.AddInt64(&w.lost, 1)
+	}
+}
+
+func (w *Webhook) startWorkers() {
+	go w.collect()
+	go func() {
+		defer close(w.done)
+		for batch := range w.batches {
+			w.post(batch)
+		}
+	}()
+}
+
+// collect groups the queued entries into batches and hands them over for posting until the webhook is closed.
+func (w *Webhook) collect() {
+	defer close(w.batches)
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]record.Entry, 0, w.BatchSize)
+	for {
+		select {
+		case entry, ok := <-w.entries:
+			if !ok {
+				w.handOver(batch, true)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= w.BatchSize {
+				w.handOver(batch, w.Blocking)
+				batch = make([]record.Entry, 0, w.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.handOver(batch, w.Blocking)
+				batch = make([]record.Entry, 0, w.BatchSize)
+			}
+		}
+	}
+}
+
+// handOver passes the batch to the posting goroutine. If too many batches are pending, the batch is dropped
+// unless wait is true.
+func (w *Webhook) handOver(batch []record.Entry, wait bool) {
+	if len(batch) == 0 {
+		return
+	}
+	if wait {
+		w.batches <- batch
+		return
+	}
+	select {
+	case w.batches <- batch:
+	default:
+		atomic.AddInt64(&w.lost, int64(len(batch)))
+	}
+}
+
+// post posts the batch and retries if necessary.
+func (w *Webhook) post(batch []record.Entry) {
+	body, _ := json.Marshal(batch)
+	backoff := w.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.send(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == w.Retries {
+			log.Printf("webhook sink: could not post %d entries to %s: %v", len(batch), w.url, err)
+			atomic.AddInt64(&w.lost, int64(len(batch)))
+			return
+		}
+		time.Sleep(backoff)
+		backoff = 2 * backoff
+	}
+}
+
+// send posts the body once. If it fails, it returns whether the post should be retried.
+func (w *Webhook) send(body []byte) (bool, error) {
+	response, err := w.Client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook answered with status %d", response.StatusCode)
+}
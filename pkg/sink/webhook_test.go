@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var mutex sync.Mutex
+	batches := make([][]record.Entry, 0)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		attempts = attempts + 1
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []record.Entry
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+
+	now := model.MustParseTime("6:00")
+	webhook := NewWebhook(server.URL, func() model.Time { return now })
+	webhook.BatchSize = 2
+	webhook.FlushInterval = time.Hour
+	webhook.Backoff = time.Millisecond
+	webhook.Position(model.BusPosition{BusId: "V1"})
+	webhook.Event(model.Event{Type: model.Arrival, BusId: "V1", Time: now})
+	webhook.Position(model.BusPosition{BusId: "V2"})
+	require.NoError(t, webhook.Close())
+
+	assert.Equal(t, 3, attempts, "the first post should be retried")
+	require.Equal(t, 2, len(batches), "number of batches")
+	require.Equal(t, 2, len(batches[0]), "first batch should be full")
+	assert.Equal(t, model.BusId("V1"), batches[0][0].Position.BusId, "first entry")
+	assert.Equal(t, model.Arrival, batches[0][1].Event.Type, "second entry")
+	require.Equal(t, 1, len(batches[1]), "remaining entries should be posted when closing")
+	assert.Equal(t, model.BusId("V2"), batches[1][0].Position.BusId, "last entry")
+}
+
+func TestWebhook_Rejected(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = attempts + 1
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, func() model.Time { return 0 })
+	webhook.Backoff = time.Millisecond
+	webhook.Position(model.BusPosition{BusId: "V1"})
+	assert.EqualError(t, webhook.Close(), "1 entries could not be posted to webhook \""+server.URL+"\"")
+	assert.Equal(t, 1, attempts, "client errors should not be retried")
+}
+
+func TestWebhook_Blocking(t *testing.T) {
+	var mutex sync.Mutex
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []record.Entry
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = received + len(batch)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, func() model.Time { return 0 })
+	webhook.BatchSize = 100
+	webhook.Blocking = true
+	for index := 0; index < 10000; index++ {
+		webhook.Position(model.BusPosition{BusId: "V1"})
+	}
+	require.NoError(t, webhook.Close())
+	assert.Equal(t, 10000, received, "no entry should be dropped")
+}
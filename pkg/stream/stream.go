@@ -0,0 +1,104 @@
+// Package stream encodes the messages of the websocket stream in a compact binary format, which is considerably
+// smaller than JSON. A frame contains all messages of one simulation tick and is encoded as the protobuf message
+// Frame of the following definition:
+//
+//	syntax = "proto3";
+//
+//	message Frame {
+//	  repeated Position positions = 1;
+//	  // all other messages, e.g. the status of the simulation, as JSON documents
+//	  repeated string messages = 2;
+//	}
+//
+//	message Position {
+//	  string id = 1;
+//	  string line_id = 2;
+//	  float lat = 3;
+//	  float lon = 4;
+//	  string stop_id = 5;
+//	  // milliseconds since midnight
+//	  uint64 departure = 6;
+//	  float heading = 7;
+//	  float speed = 8;
+//	  uint32 assignment = 9;
+//	  string next_stop_id = 10;
+//	  sint32 delay = 11;
+//	  float distance = 12;
+//	  uint32 occupancy = 13;
+//	}
+//
+// The fields correspond to the fields of model.BusPosition of the model.SchemaVersion, which clients receive in the
+// first frame. Fields with default values are omitted.
+//
+// The order of the messages is only retained within positions and messages, respectively.
+package stream
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/internal/wire"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Subprotocol is the name of the websocket subprotocol clients must request in order to receive protobuf frames.
+const Subprotocol = "ots.protobuf"
+
+// Encode encodes the messages as one Frame. Bus positions are encoded as protobuf messages,
+// all other messages as JSON documents.
+func Encode(messages []interface{}) ([]byte, error) {
+	var result []byte
+	var others [][]byte
+	for _, message := range messages {
+		if position, ok := message.(model.BusPosition); ok {
+			result = wire.AppendMessage(result, 1, encodePosition(position))
+			continue
+		}
+		data, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		others = append(others, data)
+	}
+	for _, other := range others {
+		result = wire.AppendMessage(result, 2, other)
+	}
+	return result, nil
+}
+
+func encodePosition(position model.BusPosition) []byte {
+	var result []byte
+	result = wire.AppendString(result, 1, string(position.BusId))
+	if position.LineId != "" {
+		result = wire.AppendString(result, 2, string(position.LineId))
+	}
+	result = wire.AppendFloat(result, 3, position.Location[0])
+	result = wire.AppendFloat(result, 4, position.Location[1])
+	if position.StopId != nil {
+		result = wire.AppendString(result, 5, string(*position.StopId))
+	}
+	if position.Departure != 0 {
+		result = wire.AppendVarint(result, 6, uint64(position.Departure))
+	}
+	if position.Heading != 0 {
+		result = wire.AppendFloat(result, 7, position.Heading)
+	}
+	if position.Speed != 0 {
+		result = wire.AppendFloat(result, 8, position.Speed)
+	}
+	if position.Assignment != 0 {
+		result = wire.AppendVarint(result, 9, uint64(position.Assignment))
+	}
+	if position.NextStopId != nil {
+		result = wire.AppendString(result, 10, string(*position.NextStopId))
+	}
+	if position.Delay != 0 {
+		result = wire.AppendVarint(result, 11, protowire.EncodeZigZag(int64(position.Delay)))
+	}
+	if position.Distance != 0 {
+		result = wire.AppendFloat(result, 12, position.Distance)
+	}
+	if position.Occupancy != 0 {
+		result = wire.AppendVarint(result, 13, uint64(position.Occupancy))
+	}
+	return result
+}
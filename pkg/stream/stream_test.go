@@ -0,0 +1,53 @@
+package stream
+
+import (
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/internal/wire"
+	"github.com/fafeitsch/Open-Traffic-Sandbox/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"testing"
+)
+
+func decode(t *testing.T, message []byte) map[protowire.Number][]interface{} {
+	result, err := wire.Decode(message)
+	require.NoError(t, err)
+	return result
+}
+
+func TestEncode(t *testing.T) {
+	stop := model.StopId("S1")
+	messages := []interface{}{
+		map[string]string{"type": "status"},
+		model.BusPosition{BusId: "V1", LineId: "L1", Location: [2]float64{49.5, 9.25}, StopId: &stop, Departure: model.MustParseTime("12:30"), Assignment: 2, NextStopId: &stop, Delay: -30, Distance: 1500, Occupancy: 12},
+		model.BusPosition{BusId: "V2", Location: [2]float64{49.75, 9.5}},
+	}
+	data, err := Encode(messages)
+	require.NoError(t, err)
+	frame := decode(t, data)
+	require.Equal(t, 2, len(frame[1]), "number of positions")
+	require.Equal(t, 1, len(frame[2]), "number of other messages")
+	assert.Equal(t, `{"type":"status"}`, string(frame[2][0].([]byte)), "other message")
+
+	first := decode(t, frame[1][0].([]byte))
+	assert.Equal(t, "V1", string(first[1][0].([]byte)), "bus id")
+	assert.Equal(t, "L1", string(first[2][0].([]byte)), "line id")
+	assert.Equal(t, float32(49.5), math.Float32frombits(uint32(first[3][0].(uint64))), "latitude")
+	assert.Equal(t, float32(9.25), math.Float32frombits(uint32(first[4][0].(uint64))), "longitude")
+	assert.Equal(t, "S1", string(first[5][0].([]byte)), "stop id")
+	assert.Equal(t, uint64(model.MustParseTime("12:30")), first[6][0], "departure")
+	assert.Nil(t, first[7], "heading of zero should be omitted")
+	assert.Equal(t, uint64(2), first[9][0], "assignment")
+	assert.Equal(t, "S1", string(first[10][0].([]byte)), "next stop id")
+	assert.Equal(t, int64(-30), protowire.DecodeZigZag(first[11][0].(uint64)), "delay")
+	assert.Equal(t, float32(1500), math.Float32frombits(uint32(first[12][0].(uint64))), "distance")
+	assert.Equal(t, uint64(12), first[13][0], "occupancy")
+
+	second := decode(t, frame[1][1].([]byte))
+	assert.Equal(t, "V2", string(second[1][0].([]byte)), "bus id")
+	assert.Nil(t, second[2], "bus without line should not have a line id")
+	assert.Nil(t, second[5], "bus on the road should not have a stop id")
+	assert.Nil(t, second[6], "bus on the road should not have a departure")
+	assert.Nil(t, second[13], "empty bus should not have an occupancy")
+}
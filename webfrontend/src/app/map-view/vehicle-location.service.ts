@@ -7,9 +7,16 @@ import { environment } from '../../environments/environment';
 
 export interface VehicleLocation {
   id: string;
+  lineId?: string;
   loc: number[];
   departure?: number;
   stopId?: string;
+  heading: number;
+  speed: number;
+  assignment: number;
+  nextStopId?: string;
+  delay: number;
+  distance: number;
 }
 
 @Injectable({
@@ -25,7 +32,7 @@ export class VehicleLocationService {
     }
     return of(url).pipe(
       filter(apiUrl => !!apiUrl),
-      map(apiUrl => apiUrl.replace(/^http/, 'ws') + '/sockets'),
+      map(apiUrl => apiUrl.replace(/^http/, 'ws') + '/sockets?policy=coalesce'),
       switchMap(wsUrl => {
         if (this.connection$) {
           return this.connection$;
@@ -34,7 +41,9 @@ export class VehicleLocationService {
           return this.connection$;
         }
       }),
-      retryWhen(errors => errors.pipe(delay(10)))
+      retryWhen(errors => errors.pipe(delay(10))),
+      // the socket also transmits schema and simulation status messages, which have no location
+      filter(message => !!message.loc)
     );
   }
 }